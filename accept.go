@@ -0,0 +1,64 @@
+package limlistener
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// default backoff between temporary accept errors, same as http.Server
+	defaultMinAcceptBackoff = 5 * time.Millisecond
+	defaultMaxAcceptBackoff = 1 * time.Second
+)
+
+// SetAcceptBackoff configures how Accept retries temporary errors (eg.
+// running out of file descriptors) instead of returning them: it waits min,
+// doubling up to max, between attempts. A min of 0 returns them right away.
+func (ll *LimitedListener) SetAcceptBackoff(min, max time.Duration) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	if max < min {
+		max = min
+	}
+	ll.minAcceptBackoff = min
+	ll.maxAcceptBackoff = max
+}
+
+// OnAcceptError calls fn with each temporary accept error being retried
+// and how long Accept backs off before the next attempt
+func (ll *LimitedListener) OnAcceptError(fn func(err error, backoff time.Duration)) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.onAcceptError = fn
+}
+
+// retryAccept tells if Accept should try again after err, backing off
+// first. backoff is the previous wait, 0 on the first error.
+func (ll *LimitedListener) retryAccept(err error, backoff *time.Duration) bool {
+	ne, ok := err.(net.Error)
+	if !ok || !ne.Temporary() {
+		return false
+	}
+	ll.mu.Lock()
+	min, max, hook := ll.minAcceptBackoff, ll.maxAcceptBackoff, ll.onAcceptError
+	ll.mu.Unlock()
+	if min <= 0 {
+		return false
+	}
+	if fdExhausted(err) {
+		ll.relieveFDPressure(err)
+	}
+
+	if *backoff == 0 {
+		*backoff = min
+	} else if *backoff *= 2; *backoff > max {
+		*backoff = max
+	}
+	if hook != nil {
+		hook(err, *backoff)
+	}
+	time.Sleep(*backoff)
+	return true
+}
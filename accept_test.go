@@ -0,0 +1,72 @@
+package limlistener
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyListener fails the first accepts with a temporary error
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	}
+	return l.Listener.Accept()
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	l := &flakyListener{Listener: listen(t), failures: 4}
+	ll := NewWithListener(l)
+	defer ll.Close()
+	ll.SetAcceptBackoff(time.Millisecond, 4*time.Millisecond)
+	var backoffs []time.Duration
+	ll.OnAcceptError(func(err error, backoff time.Duration) {
+		if !errors.Is(err, syscall.EMFILE) {
+			t.Errorf("retried %v, want EMFILE", err)
+		}
+		backoffs = append(backoffs, backoff)
+	})
+
+	// connects through the backlog, before being accepted
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ll.Accept()
+	if err != nil {
+		t.Fatalf("Accept() = %v, want the errors retried", err)
+	}
+	defer conn.Close()
+	// doubling up to the max
+	want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond}
+	if len(backoffs) != len(want) {
+		t.Fatalf("backoffs = %v, want %v", backoffs, want)
+	}
+	for i := range want {
+		if backoffs[i] != want[i] {
+			t.Errorf("backoffs = %v, want %v", backoffs, want)
+			break
+		}
+	}
+}
+
+func TestAcceptNoBackoff(t *testing.T) {
+	l := &flakyListener{Listener: listen(t), failures: 1}
+	ll := NewWithListener(l)
+	defer ll.Close()
+	ll.SetAcceptBackoff(0, 0)
+
+	if _, err := ll.Accept(); !errors.Is(err, syscall.EMFILE) {
+		t.Errorf("Accept() = %v, want EMFILE returned right away", err)
+	}
+}
@@ -0,0 +1,63 @@
+package limlistener
+
+import (
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// AcceptStats tell how pressed the accept side of a listener is: Accept
+// calls returning right away with connections piling up in the kernel
+// backlog mean clients arrive faster than they're taken in
+type AcceptStats struct {
+	// connections taken off the underlying listener, refused ones included
+	Accepted int64
+	// time each Accept call waited on the underlying listener
+	Wait Histogram
+	// connections queued in the kernel backlog and its size, only
+	// known for TCP listeners on Linux
+	BacklogKnown bool
+	Backlog      int
+	BacklogMax   int
+}
+
+// acceptCounters are AcceptStats updated atomically
+type acceptCounters struct {
+	accepted int64
+	wait     histogram
+}
+
+// observe records an Accept call to the underlying listener
+// that started at start and got a connection
+func (ac *acceptCounters) observe(start time.Time) {
+	ac.wait.observe(time.Since(start))
+	atomic.AddInt64(&ac.accepted, 1)
+}
+
+// AcceptStats returns the accept latency and kernel backlog of the listener
+func (ll *LimitedListener) AcceptStats() AcceptStats {
+	stats := AcceptStats{
+		Accepted: atomic.LoadInt64(&ll.accept.accepted),
+		Wait:     ll.accept.wait.snapshot(),
+	}
+	stats.Backlog, stats.BacklogMax, stats.BacklogKnown = listenerBacklog(ll.listener)
+	return stats
+}
+
+// listenerBacklog returns how many connections are queued in the
+// backlog of a listening socket and its size, where the platform tells
+func listenerBacklog(l interface{}) (queued, max int, ok bool) {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return 0, 0, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, 0, false
+	}
+	ok = false
+	raw.Control(func(fd uintptr) {
+		queued, max, ok = socketBacklog(fd)
+	})
+	return queued, max, ok
+}
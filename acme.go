@@ -0,0 +1,113 @@
+package limlistener
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+)
+
+// ACMEALPNProtocol is the ALPN protocol ACME tls-alpn-01 challenges
+// (RFC 8737) are answered over, see autocert.Manager
+const ACMEALPNProtocol = "acme-tls/1"
+
+// isACMEChallenge tells if a ClientHello is a tls-alpn-01 challenge,
+// which offers that protocol alone
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == ACMEALPNProtocol
+}
+
+// exemptACME returns a copy of config that lifts the limits of connections
+// of ACME challenges for their handshake, so certificates get issued (eg.
+// by autocert.Manager, whose GetCertificate config is using) however tight
+// the limits are. Offering the protocol is up to the client, the exemption
+// is only kept when the handshake negotiates it and dropped otherwise. The
+// config's own GetConfigForClient and VerifyConnection, if any, are still
+// called.
+func exemptACME(config *tls.Config) *tls.Config {
+	if config == nil {
+		return nil
+	}
+	config = config.Clone()
+	getConfig := config.GetConfigForClient
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		var cfg *tls.Config
+		if getConfig != nil {
+			var err error
+			if cfg, err = getConfig(hello); err != nil {
+				return nil, err
+			}
+		}
+		if !isACMEChallenge(hello) {
+			return cfg, nil
+		}
+		if cfg == nil {
+			cfg = config
+		}
+		exempt(hello.Conn)
+		return verifyACME(cfg, hello.Conn), nil
+	}
+	return config
+}
+
+// verifyACME returns a copy of config dropping the exemption of conn
+// once its handshake is done, unless it negotiated the ACME protocol
+func verifyACME(config *tls.Config, conn net.Conn) *tls.Config {
+	config = config.Clone()
+	verify := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if state.NegotiatedProtocol != ACMEALPNProtocol {
+			unexempt(conn)
+		}
+		if verify != nil {
+			return verify(state)
+		}
+		return nil
+	}
+	return config
+}
+
+// exempt lifts the limits of conn if it's a limited connection
+func exempt(conn net.Conn) {
+	if e := exemptionOf(conn); e != nil {
+		e.set()
+	}
+}
+
+// unexempt puts the limits of conn back in place
+func unexempt(conn net.Conn) {
+	if e := exemptionOf(conn); e != nil {
+		e.clear()
+	}
+}
+
+func exemptionOf(conn net.Conn) *exemption {
+	switch c := conn.(type) {
+	case LimitedConn:
+		return c.exempt
+	case releasingConn:
+		return c.exempt
+	}
+	return nil
+}
+
+// exemption marks a connection as not throttled at all,
+// nil for connections that can't be exempted
+type exemption struct {
+	v int32
+}
+
+func (e *exemption) set() {
+	if e != nil {
+		atomic.StoreInt32(&e.v, 1)
+	}
+}
+
+func (e *exemption) clear() {
+	if e != nil {
+		atomic.StoreInt32(&e.v, 0)
+	}
+}
+
+func (e *exemption) is() bool {
+	return e != nil && atomic.LoadInt32(&e.v) == 1
+}
@@ -0,0 +1,62 @@
+package limlistener
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// acmeHandshake runs a handshake with a client offering protos against a
+// listener layering TLS inside the limits with serverProtos, and tells if
+// the connection is exempted afterwards
+func acmeHandshake(t *testing.T, serverProtos, protos []string) bool {
+	t.Helper()
+	serverConfig, clientConfig := testTLSConfig(t)
+	serverConfig.NextProtos = serverProtos
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, ll := NewTLSWithLimits(l, serverConfig)
+	defer tl.Close()
+
+	config := clientConfig.Clone()
+	config.ServerName = "localhost"
+	config.NextProtos = protos
+	go func() {
+		c, err := tls.Dial("tcp", tl.Addr().String(), config)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Read(make([]byte, 1))
+	}()
+	conn, err := tl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.(*tls.Conn).Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	exempted := ll.Conns(func(lc *LimitedConn) bool {
+		return lc.exempt.is()
+	})
+	return len(exempted) == 1
+}
+
+func TestACMEChallengeExempted(t *testing.T) {
+	if !acmeHandshake(t, []string{ACMEALPNProtocol}, []string{ACMEALPNProtocol}) {
+		t.Error("negotiated ACME challenge not exempted")
+	}
+}
+
+func TestACMEOfferNotExempted(t *testing.T) {
+	// offered, but the config doesn't answer challenges
+	if acmeHandshake(t, nil, []string{ACMEALPNProtocol}) {
+		t.Error("connection exempted without negotiating the ACME protocol")
+	}
+	if acmeHandshake(t, []string{"h2"}, []string{"h2"}) {
+		t.Error("plain connection exempted")
+	}
+}
@@ -0,0 +1,150 @@
+package limlistener
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// rateFrameMagic starts the rate frame, followed by the write and
+// read rates in bytes/sec as big endian uint64s
+var rateFrameMagic = [4]byte{'L', 'L', 'R', 1}
+
+// RateFrameSize is the size of the rate frame a listener
+// advertising rates writes ahead of everything else
+const RateFrameSize = 20
+
+// ErrNoRateFrame is returned when a connection doesn't
+// start with a rate frame
+var ErrNoRateFrame = errors.New("limlistener: no rate frame")
+
+// Rates are the rates in bytes/sec a connection is held to, 0 for none
+type Rates struct {
+	// the lowest of its connection, class, per-key and global write limits
+	Write BytesPerSecond
+	// the lowest of its connection and global read limits
+	Read BytesPerSecond
+}
+
+// AssignedRates returns the rates the connection is held to, a peer
+// pacing itself to them never queues up at the limiters
+func (lc LimitedConn) AssignedRates() Rates {
+	write := math.Inf(1)
+	for _, l := range lc.bucketLimiters() {
+		write = lowestLimit(write, l)
+	}
+	if w := lc.global.window; w.enabled() {
+		w.mu.Lock()
+		write = math.Min(write, float64(w.limit)/w.interval.Seconds())
+		w.mu.Unlock()
+	}
+	read := math.Inf(1)
+	// dialed connections have no listener
+	if lc.readLimiter != nil && lc.listener != nil {
+		read = lowestLimit(lowestLimit(read, lc.readLimiter), lc.listener.readGlobal)
+	}
+	return Rates{Write: rateOf(write), Read: rateOf(read)}
+}
+
+// lowestLimit returns the lowest of rate and the limit of l
+func lowestLimit(rate float64, l *limiter) float64 {
+	if limit := l.Limit(); limit != rlimit.Inf && limit != 0 {
+		return math.Min(rate, float64(limit))
+	}
+	return rate
+}
+
+// rateOf turns no limit into 0
+func rateOf(rate float64) BytesPerSecond {
+	if math.IsInf(rate, 1) {
+		return 0
+	}
+	return BytesPerSecond(rate)
+}
+
+// SetRateAdvertisement makes the listener write a rate frame with the
+// rates assigned to every connection it accepts from now on (see
+// AssignedRates) ahead of anything else, so well-behaved clients can read
+// it (see ReadRateFrame and SelfPace) and pace themselves. It's opt-in
+// framing both ends have to agree on. TLS connections get it once their
+// handshake is done on their first read or write, with the rates of the
+// class their TLS classifier puts them in (see SetTLSClassifier).
+func (ll *LimitedListener) SetRateAdvertisement(enabled bool) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.advertiseRate = enabled
+}
+
+// OnRateAssigned calls fn with every accepted connection and its assigned
+// rates before Accept returns it (TLS connections once their handshake is
+// done, see SetRateAdvertisement), for protocols telling their clients
+// about them on their own (eg. in a response header) rather than with a
+// rate frame
+func (ll *LimitedListener) OnRateAssigned(fn func(conn LimitedConn, rates Rates)) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.onRateAssigned = fn
+}
+
+// advertise tells the peer of an accepted connection
+// about its rates, if asked to
+func (ll *LimitedListener) advertise(lconn LimitedConn) error {
+	ll.mu.Lock()
+	enabled, hook := ll.advertiseRate, ll.onRateAssigned
+	ll.mu.Unlock()
+	if !enabled && hook == nil {
+		return nil
+	}
+
+	rates := lconn.AssignedRates()
+	if hook != nil {
+		hook(lconn, rates)
+	}
+	if !enabled {
+		return nil
+	}
+	var frame [RateFrameSize]byte
+	copy(frame[:], rateFrameMagic[:])
+	binary.BigEndian.PutUint64(frame[4:], uint64(rates.Write))
+	binary.BigEndian.PutUint64(frame[12:], uint64(rates.Read))
+	// the frame isn't shaped, it's out before anything else
+	_, err := lconn.conn.Write(frame[:])
+	return err
+}
+
+// ReadRateFrame reads the rate frame a listener advertising rates starts
+// every connection with, the rates being the listener's side of it
+func ReadRateFrame(r io.Reader) (Rates, error) {
+	var frame [RateFrameSize]byte
+	if _, err := io.ReadFull(r, frame[:]); err != nil {
+		return Rates{}, err
+	}
+	if !bytes.Equal(frame[:4], rateFrameMagic[:]) {
+		return Rates{}, ErrNoRateFrame
+	}
+	return Rates{
+		Write: BytesPerSecond(binary.BigEndian.Uint64(frame[4:])),
+		Read:  BytesPerSecond(binary.BigEndian.Uint64(frame[12:])),
+	}, nil
+}
+
+// SelfPace reads the rate frame off a dialed connection and, if it's a
+// LimitedConn, limits its writes to the rate the listener reads at so
+// they never queue up at the other end
+func SelfPace(conn net.Conn) (Rates, error) {
+	rates, err := ReadRateFrame(conn)
+	if err != nil {
+		return Rates{}, err
+	}
+	if lconn, ok := conn.(LimitedConn); ok && rates.Read > 0 {
+		lconn.SetRate(rates.Read)
+	}
+	return rates, nil
+}
@@ -0,0 +1,131 @@
+package limlistener
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// auditSize is how many limit changes the audit trail remembers
+const auditSize = 256
+
+// LimitChange is an entry of the audit trail of limit changes
+type LimitChange struct {
+	Time time.Time
+	// who made the change, empty unless given
+	Actor string
+	// what changed: "global", "conn", "class <name>" (its shared
+	// cap), "class <name> conn" or "conn <id>"
+	Target string
+	Old    int
+	New    int
+}
+
+func (c LimitChange) String() string {
+	actor := c.Actor
+	if actor == "" {
+		actor = "-"
+	}
+	return fmt.Sprintf("%s %s %s %d -> %d", c.Time.Format(time.RFC3339), actor, c.Target, c.Old, c.New)
+}
+
+type auditTrail struct {
+	mu      sync.Mutex
+	entries []LimitChange
+	hook    func(LimitChange)
+}
+
+// record appends a change, unless nothing changed
+func (a *auditTrail) record(now time.Time, actor, target string, old, new int) {
+	if old == new {
+		return
+	}
+	change := LimitChange{
+		Time:   now,
+		Actor:  actor,
+		Target: target,
+		Old:    old,
+		New:    new,
+	}
+	a.mu.Lock()
+	if len(a.entries) == auditSize {
+		copy(a.entries, a.entries[1:])
+		a.entries = a.entries[:auditSize-1]
+	}
+	a.entries = append(a.entries, change)
+	hook := a.hook
+	a.mu.Unlock()
+
+	if hook != nil {
+		hook(change)
+	}
+}
+
+// OnLimitChange calls fn with every limit change from now on
+func (ll *LimitedListener) OnLimitChange(fn func(LimitChange)) {
+	ll.audit.mu.Lock()
+	defer ll.audit.mu.Unlock()
+
+	ll.audit.hook = fn
+}
+
+// AuditTrail returns the latest limit changes, oldest first
+func (ll *LimitedListener) AuditTrail() []LimitChange {
+	ll.audit.mu.Lock()
+	defer ll.audit.mu.Unlock()
+
+	entries := make([]LimitChange, len(ll.audit.entries))
+	copy(entries, ll.audit.entries)
+	return entries
+}
+
+// SetLimitsBy is SetLimits recording actor as the author of the change
+func (ll *LimitedListener) SetLimitsBy(actor string, globalLimit, connLimit int) {
+	// refused limits can only go to the error handler
+	ll.reportError(nil, ll.trySetLimitsBy(actor, globalLimit, connLimit))
+}
+
+func (ll *LimitedListener) trySetLimitsBy(actor string, globalLimit, connLimit int) error {
+	ll.mu.Lock()
+	oldGlobal, oldConn := ll.baseGlobalLimit, ll.baseConnLimit
+	check := ll.limitsCheck
+	ll.mu.Unlock()
+
+	connLimit, err := check.apply(globalLimit, connLimit)
+	if err != nil {
+		return err
+	}
+	ll.setLimits(globalLimit, connLimit)
+	now := ll.getClock().Now()
+	ll.audit.record(now, actor, "global", oldGlobal, globalLimit)
+	ll.audit.record(now, actor, "conn", oldConn, connLimit)
+	return nil
+}
+
+// SetClassBy is SetClass recording actor as the author of the change
+func (ll *LimitedListener) SetClassBy(actor, name string, connLimit, limit int) {
+	ll.mu.Lock()
+	var old limitClass
+	if cl := ll.classes[name]; cl != nil {
+		old = *cl
+	}
+	ll.mu.Unlock()
+
+	ll.setClass(name, connLimit, limit)
+	now := ll.getClock().Now()
+	ll.audit.record(now, actor, "class "+name, old.limit, limit)
+	ll.audit.record(now, actor, "class "+name+" conn", old.connLimit, connLimit)
+}
+
+// SetLimitBy is SetLimit recording actor as the author of the change
+func (lc *LimitedConn) SetLimitBy(actor string, limit int) {
+	old := 0
+	if lc.connLimiter != nil {
+		old = int(lc.LimiterState().Limit)
+	}
+	lc.setLimit(limit)
+	// dialed connections have no listener to keep the trail
+	if lc.listener != nil {
+		lc.listener.audit.record(lc.listener.getClock().Now(), actor, fmt.Sprintf("conn %d", lc.id), old, limit)
+	}
+}
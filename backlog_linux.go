@@ -0,0 +1,24 @@
+//go:build linux && !386
+// +build linux,!386
+
+package limlistener
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// socketBacklog reads the accept queue of a listening socket out of
+// TCP_INFO, where the kernel reports its length as unacked and its
+// size as sacked
+func socketBacklog(fd uintptr) (int, int, bool) {
+	var info syscall.TCPInfo
+	size := uint32(unsafe.Sizeof(info))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+		uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	// TCP_LISTEN
+	if errno != 0 || info.State != 10 {
+		return 0, 0, false
+	}
+	return int(info.Unacked), int(info.Sacked), true
+}
@@ -0,0 +1,8 @@
+//go:build !linux || 386
+// +build !linux 386
+
+package limlistener
+
+func socketBacklog(fd uintptr) (int, int, bool) {
+	return 0, 0, false
+}
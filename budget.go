@@ -0,0 +1,211 @@
+package limlistener
+
+import (
+	"sync"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+const (
+	// bytes billed as one GB
+	gigabyte = 1 << 30
+	// how often a tightened limit gets recomputed
+	budgetAdjustInterval = time.Second
+)
+
+// BudgetStatus is a point in time view of the monthly egress spend
+type BudgetStatus struct {
+	// start of the current billing month
+	Period time.Time
+	// bytes sent so far this month
+	Bytes int64
+	// money spent so far this month and the monthly budget
+	Spent  float64
+	Budget float64
+	// Spent over Budget
+	Fraction float64
+}
+
+type budgetHook struct {
+	fraction float64
+	fn       func(BudgetStatus)
+	fired    bool
+}
+
+// Budget tracks egress spend against a monthly budget, converting bytes
+// into currency at a fixed price per GB. Once attached to a listener it can
+// tighten the global limit as the budget approaches exhaustion and fire hooks
+// when spend thresholds are crossed.
+type Budget struct {
+	mu         sync.Mutex
+	clock      Clock
+	pricePerGB float64
+	monthly    float64
+	// current billing month and bytes sent in it
+	period time.Time
+	bytes  int64
+	hooks  []*budgetHook
+	// tightening kicks in above this spend fraction (0 disables it)
+	tightenAt float64
+	floor     int
+	// the global limiter being tightened and its configured limit,
+	// 0 if there's none
+	limiter    *limiter
+	configured int
+	lastAdjust time.Time
+}
+
+// NewBudget creates a budget of monthly currency units for traffic
+// priced at pricePerGB
+func NewBudget(pricePerGB, monthly float64) *Budget {
+	b := &Budget{
+		clock:      systemClock{},
+		pricePerGB: pricePerGB,
+		monthly:    monthly,
+	}
+	b.period = monthStart(b.clock.Now())
+	return b
+}
+
+// OnThreshold registers fn to be called once a month when spend crosses
+// fraction of the budget (eg. 0.8 for 80%). It's called from the writing
+// goroutine so it should not block.
+func (b *Budget) OnThreshold(fraction float64, fn func(BudgetStatus)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.hooks = append(b.hooks, &budgetHook{
+		fraction: fraction,
+		fn:       fn,
+		fired:    b.fraction() >= fraction,
+	})
+}
+
+// TightenAt makes the budget cap the global limit once spend crosses
+// fraction of the budget, the cap is the rate that spreads what's left of
+// the budget over the rest of the month and never goes below floor bytes/sec
+func (b *Budget) TightenAt(fraction float64, floor int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tightenAt = fraction
+	b.floor = floor
+	b.adjust(b.clock.Now())
+}
+
+// Status returns the current month's spend
+func (b *Budget) Status() BudgetStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roll(b.clock.Now())
+	return b.status()
+}
+
+// attach hands the budget the global limiter it should tighten
+func (b *Budget) attach(limiter *limiter, configured int, clock Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.limiter = limiter
+	b.configured = configured
+	b.clock = clock
+	now := clock.Now()
+	b.roll(now)
+	b.adjust(now)
+}
+
+// charge accounts n bytes sent
+func (b *Budget) charge(n int) {
+	b.mu.Lock()
+	now := b.clock.Now()
+	b.roll(now)
+	b.bytes += int64(n)
+	// was any threshold crossed?
+	var fire []*budgetHook
+	for _, h := range b.hooks {
+		if !h.fired && b.fraction() >= h.fraction {
+			h.fired = true
+			fire = append(fire, h)
+		}
+	}
+	if len(fire) > 0 || now.Sub(b.lastAdjust) >= budgetAdjustInterval {
+		b.adjust(now)
+	}
+	status := b.status()
+	b.mu.Unlock()
+
+	for _, h := range fire {
+		h.fn(status)
+	}
+}
+
+// roll starts a new billing month when needed,
+// must be called with the lock held
+func (b *Budget) roll(now time.Time) {
+	period := monthStart(now)
+	if !period.After(b.period) {
+		return
+	}
+	b.period = period
+	b.bytes = 0
+	for _, h := range b.hooks {
+		h.fired = false
+	}
+}
+
+// adjust recomputes the global limit, must be called with the lock held
+func (b *Budget) adjust(now time.Time) {
+	b.lastAdjust = now
+	if b.limiter == nil {
+		return
+	}
+	// without a configured limit there's none until tightened
+	limit := rlimit.Limit(b.configured)
+	if b.configured <= 0 {
+		limit = rlimit.Inf
+	}
+	if b.tightenAt > 0 && b.fraction() >= b.tightenAt {
+		// spread the remaining budget over the rest of the month
+		remaining := (b.monthly - b.spent()) / b.pricePerGB * gigabyte
+		left := b.period.AddDate(0, 1, 0).Sub(now).Seconds()
+		rate := b.floor
+		if remaining > 0 && left > 0 {
+			if r := int(remaining / left); r > rate {
+				rate = r
+			}
+		}
+		if r := rlimit.Limit(rate); r < limit {
+			limit = r
+		}
+	}
+	b.limiter.SetLimit(limit)
+}
+
+func (b *Budget) spent() float64 {
+	return float64(b.bytes) / gigabyte * b.pricePerGB
+}
+
+func (b *Budget) fraction() float64 {
+	if b.monthly <= 0 {
+		return 0
+	}
+	return b.spent() / b.monthly
+}
+
+func (b *Budget) status() BudgetStatus {
+	return BudgetStatus{
+		Period:   b.period,
+		Bytes:    b.bytes,
+		Spent:    b.spent(),
+		Budget:   b.monthly,
+		Fraction: b.fraction(),
+	}
+}
+
+// monthStart returns the beginning of the (UTC) calendar month of t
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
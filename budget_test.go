@@ -0,0 +1,91 @@
+package limlistener
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// manualClock is a clock only moving when told to
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.advance(d)
+	return ch
+}
+
+func (c *manualClock) advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+func TestBudgetTightensDefaultListener(t *testing.T) {
+	ll := newTestListener(t)
+	clock := newManualClock()
+	ll.SetClock(clock)
+	// $1/GB with a $1 budget, tightening at half of it
+	budget := NewBudget(1, 1)
+	budget.TightenAt(0.5, 1024)
+	ll.SetBudget(budget)
+
+	limiter := ll.GlobalLimiter().limiter
+	if limit := limiter.Limit(); limit != rlimit.Inf {
+		t.Fatalf("limit before tightening = %v, want no limit", limit)
+	}
+	budget.charge(gigabyte / 4)
+	if limit := limiter.Limit(); limit != rlimit.Inf {
+		t.Fatalf("limit below the threshold = %v, want no limit", limit)
+	}
+	// the limit is recomputed once a second at most
+	clock.advance(budgetAdjustInterval)
+	budget.charge(gigabyte / 2)
+	limit := limiter.Limit()
+	if limit == rlimit.Inf || limit < 1024 {
+		t.Fatalf("limit past the threshold = %v, want a finite one of at least the floor", limit)
+	}
+	// swapping the global limiter keeps it tightened
+	ll.SetGlobalLimiter(NewGlobalLimiter(0))
+	if limit := ll.GlobalLimiter().limiter.Limit(); limit == rlimit.Inf || limit < 1024 {
+		t.Fatalf("limit of the new global limiter = %v, want a finite one of at least the floor", limit)
+	}
+}
+
+func TestBudgetKeepsConfiguredLimit(t *testing.T) {
+	ll := newTestListener(t)
+	clock := newManualClock()
+	ll.SetClock(clock)
+	ll.SetLimits(1<<20, 1<<20)
+	budget := NewBudget(1, 1)
+	budget.TightenAt(0.5, 1024)
+	ll.SetBudget(budget)
+
+	limiter := ll.GlobalLimiter().limiter
+	if limit := limiter.Limit(); limit != 1<<20 {
+		t.Fatalf("limit before tightening = %v, want %d", limit, 1<<20)
+	}
+	clock.advance(budgetAdjustInterval)
+	budget.charge(gigabyte * 3 / 4)
+	if limit := limiter.Limit(); limit >= 1<<20 || limit < 1024 {
+		t.Fatalf("limit past the threshold = %v, want below %d and at least the floor", limit, 1<<20)
+	}
+}
@@ -0,0 +1,158 @@
+package limlistener
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+const (
+	// calibrations without a context deadline run this long
+	defaultCalibration = 3 * time.Second
+	// the achieved rate is sampled this often
+	calibrationInterval = 100 * time.Millisecond
+)
+
+// CalibrationReport compares the configured rate of a calibration run
+// with what got through
+type CalibrationReport struct {
+	// configured rate and the MTU it ran with
+	Target int
+	MTU    int
+	// bytes that got through, how long it took and at what rate
+	Bytes    int64
+	Duration time.Duration
+	Achieved float64
+	// relative error of the achieved rate, negative when short of it
+	Deviation float64
+	// spread of the rate sampled every 100ms relative to its mean, and the
+	// longest the receiver went without getting anything
+	Jitter float64
+	MaxGap time.Duration
+}
+
+// RunCalibration loops data through a local socket pair shaped at
+// targetRate with the listener's current MTU, chunking, record overhead and
+// micro-pacing settings, reporting how far the achieved rate deviates from
+// it and how steady it is. It runs until ctx is done, for 3s if it has no
+// deadline, helping to tune the settings for the platform.
+func (ll *LimitedListener) RunCalibration(ctx context.Context, targetRate int) (CalibrationReport, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultCalibration)
+		defer cancel()
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return CalibrationReport{}, err
+	}
+	cl := NewWithListener(l)
+	defer cl.Close()
+
+	ll.mu.Lock()
+	cl.mtu = ll.mtu
+	cl.chunking = ll.chunking
+	cl.recordOverhead = ll.recordOverhead
+	cl.microPieces = ll.microPieces
+	ll.mu.Unlock()
+	cl.connLimit = targetRate
+
+	report := CalibrationReport{
+		Target: targetRate,
+		MTU:    cl.mtu,
+	}
+
+	// the sending side writes until the run is over
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := cl.Accept()
+		if err != nil {
+			accepted <- err
+			return
+		}
+		accepted <- nil
+		defer cl.CloseConnection(conn)
+
+		buf := make([]byte, 32<<10)
+		for ctx.Err() == nil {
+			if _, err := conn.(LimitedConn).writeContext(ctx, buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return report, err
+	}
+	defer conn.Close()
+	if err := <-accepted; err != nil {
+		return report, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// the receiving side samples what gets through
+	var samples []float64
+	var sampled int64
+	start := time.Now()
+	last, next := start, start.Add(calibrationInterval)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := conn.Read(buf)
+		now := time.Now()
+		if n > 0 {
+			report.Bytes += int64(n)
+			if gap := now.Sub(last); gap > report.MaxGap {
+				report.MaxGap = gap
+			}
+			last = now
+		}
+		for !now.Before(next) {
+			samples = append(samples, float64(report.Bytes-sampled)/calibrationInterval.Seconds())
+			sampled = report.Bytes
+			next = next.Add(calibrationInterval)
+		}
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				return report, err
+			}
+			break
+		}
+	}
+
+	report.Duration = last.Sub(start)
+	if report.Duration > 0 {
+		report.Achieved = float64(report.Bytes) / report.Duration.Seconds()
+	}
+	if targetRate > 0 {
+		report.Deviation = (report.Achieved - float64(targetRate)) / float64(targetRate)
+	}
+	report.Jitter = relativeStdDev(samples)
+	return report, nil
+}
+
+// relativeStdDev is the standard deviation of samples over their mean
+func relativeStdDev(samples []float64) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += s
+	}
+	mean := sum / float64(len(samples))
+	if mean == 0 {
+		return 0
+	}
+	var sq float64
+	for _, s := range samples {
+		sq += (s - mean) * (s - mean)
+	}
+	return math.Sqrt(sq/float64(len(samples)-1)) / mean
+}
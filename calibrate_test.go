@@ -0,0 +1,38 @@
+package limlistener
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRunCalibration(t *testing.T) {
+	ll := newTestListener(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	report, err := ll.RunCalibration(ctx, 200000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Target != 200000 || report.MTU != ll.mtu {
+		t.Errorf("report = %+v, want a target of 200000 at an MTU of %d", report, ll.mtu)
+	}
+	if report.Bytes == 0 || report.Duration < 300*time.Millisecond {
+		t.Fatalf("report = %+v, want about 500ms of traffic", report)
+	}
+	if math.Abs(report.Deviation) > 0.25 {
+		t.Errorf("achieved %.0f bytes/sec, %.0f%% off the target", report.Achieved, report.Deviation*100)
+	}
+}
+
+func TestRelativeStdDev(t *testing.T) {
+	if dev := relativeStdDev([]float64{100, 100, 100}); dev != 0 {
+		t.Errorf("relativeStdDev() of a steady rate = %v, want 0", dev)
+	}
+	// a standard deviation of 50 over a mean of 100
+	if dev := relativeStdDev([]float64{50, 100, 150}); math.Abs(dev-0.5) > 1e-9 {
+		t.Errorf("relativeStdDev() = %v, want 0.5", dev)
+	}
+}
@@ -0,0 +1,142 @@
+package limlistener
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+)
+
+// captureMagic starts every capture stream
+const captureMagic = "LIMCAP01"
+
+// MaxCaptureRecord is the largest payload of a capture record, larger
+// chunks are split over several records and readers reject records
+// claiming more than this
+const MaxCaptureRecord = 16 << 20
+
+// Capture records throttled traffic into a simple length-prefixed stream:
+// an 8 byte magic followed by one record per written chunk made of the unix
+// timestamp in nanoseconds (8 bytes), the connection id (4 bytes), the
+// payload length (4 bytes) and the payload, all big endian. Captures can be
+// switched on and off at runtime and narrowed down to some connections.
+type Capture struct {
+	mu      sync.Mutex
+	w       io.Writer
+	closer  io.Closer
+	enabled bool
+	// connection ids to record, all of them when empty
+	only  map[int]bool
+	clock Clock
+	err   error
+}
+
+// NewCapture starts an enabled capture stream on w
+func NewCapture(w io.Writer) (*Capture, error) {
+	if _, err := io.WriteString(w, captureMagic); err != nil {
+		return nil, err
+	}
+	return &Capture{
+		w:       w,
+		enabled: true,
+		clock:   systemClock{},
+	}, nil
+}
+
+// NewCaptureFile creates (or truncates) the file at path and
+// starts an enabled capture on it, Close closes the file
+func NewCaptureFile(path string) (*Capture, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCapture(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.closer = f
+	return c, nil
+}
+
+// SetEnabled switches recording on or off
+func (c *Capture) SetEnabled(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.enabled = enabled
+}
+
+// Only narrows recording down to the given connection ids,
+// calling it with no ids records every connection again
+func (c *Capture) Only(ids ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.only = nil
+	if len(ids) == 0 {
+		return
+	}
+	c.only = make(map[int]bool, len(ids))
+	for _, id := range ids {
+		c.only[id] = true
+	}
+}
+
+// Record appends a chunk written on connection id to the capture, it has
+// the signature of a Tap function. Once a write to the stream fails the
+// capture stops recording and Err reports why.
+func (c *Capture) Record(id int, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.enabled || c.err != nil {
+		return
+	}
+	if c.only != nil && !c.only[id] {
+		return
+	}
+	now := c.clock.Now()
+	for {
+		p := b
+		if len(p) > MaxCaptureRecord {
+			p = b[:MaxCaptureRecord]
+		}
+		var hdr [16]byte
+		binary.BigEndian.PutUint64(hdr[0:], uint64(now.UnixNano()))
+		binary.BigEndian.PutUint32(hdr[8:], uint32(id))
+		binary.BigEndian.PutUint32(hdr[12:], uint32(len(p)))
+		if _, err := c.w.Write(hdr[:]); err != nil {
+			c.err = err
+			return
+		}
+		if _, err := c.w.Write(p); err != nil {
+			c.err = err
+			return
+		}
+		b = b[len(p):]
+		if len(b) == 0 {
+			return
+		}
+	}
+}
+
+// Err returns the error that stopped the capture, if any
+func (c *Capture) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Close stops recording and closes the file the capture owns
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.enabled = false
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
@@ -0,0 +1,78 @@
+package limlistener
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	c, err := NewCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Record(1, []byte("hello"))
+	c.Record(2, []byte("world"))
+
+	cr, err := NewCaptureReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct {
+		id   int
+		data string
+	}{{1, "hello"}, {2, "world"}} {
+		rec, err := cr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.ID != want.id || string(rec.Data) != want.data {
+			t.Errorf("record = %d %q, want %d %q", rec.ID, rec.Data, want.id, want.data)
+		}
+	}
+	if _, err := cr.Next(); err != io.EOF {
+		t.Errorf("Next() after the last record = %v, want io.EOF", err)
+	}
+}
+
+func TestCaptureSplitsLargeChunks(t *testing.T) {
+	var buf bytes.Buffer
+	c, err := NewCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Record(1, make([]byte, MaxCaptureRecord+10))
+
+	cr, err := NewCaptureReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int{MaxCaptureRecord, 10} {
+		rec, err := cr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.Data) != want {
+			t.Errorf("record of %d bytes, want %d", len(rec.Data), want)
+		}
+	}
+}
+
+func TestCaptureRejectsOversizedRecords(t *testing.T) {
+	var hdr [16]byte
+	binary.BigEndian.PutUint32(hdr[8:], 1)
+	binary.BigEndian.PutUint32(hdr[12:], 0xffffffff)
+	stream := append([]byte(captureMagic), hdr[:]...)
+
+	cr, err := NewCaptureReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rejected rather than allocating the 4 GiB claimed
+	if _, err := cr.Next(); !errors.Is(err, ErrBadCapture) {
+		t.Errorf("Next() = %v, want ErrBadCapture", err)
+	}
+}
@@ -0,0 +1,113 @@
+package limlistener
+
+import (
+	"context"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+const (
+	// adaptive chunks are sized to go out at about this pace
+	adaptiveInterval = 10 * time.Millisecond
+	// and are never smaller than this
+	minAdaptiveChunk = 128
+)
+
+// ChunkingStrategy decides how writes are split into
+// the reservations made against the limiters
+type ChunkingStrategy int
+
+const (
+	// ChunkFixed splits writes into MTU sized chunks, each one
+	// written as soon as it's been granted
+	ChunkFixed ChunkingStrategy = iota
+	// ChunkWhole reserves a whole write before any of it is written so
+	// messages are paced all-or-nothing instead of as a byte stream
+	ChunkWhole
+	// ChunkAdaptive sizes chunks after the lowest rate the connection is
+	// subject to so each takes about 10ms worth of it, between 128 bytes
+	// and the smallest limiter burst
+	ChunkAdaptive
+)
+
+// SetChunking sets how the writes of new connections are split up
+func (ll *LimitedListener) SetChunking(strategy ChunkingStrategy) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.chunking = strategy
+}
+
+// limiters returns every token bucket the connection waits on
+func (lc LimitedConn) limiters() []*limiter {
+	limiters := []*limiter{lc.connLimiter}
+	// windowed global limits aren't token buckets
+	if !lc.global.window.enabled() {
+		limiters = append(limiters, lc.global.limiter)
+	}
+	if _, limiter := lc.class.get(); limiter != nil {
+		limiters = append(limiters, limiter)
+	}
+	if lc.keyLimiter != nil {
+		limiters = append(limiters, lc.keyLimiter)
+	}
+	return limiters
+}
+
+// chunkSize returns the size of the next chunk to reserve
+func (lc LimitedConn) chunkSize() int {
+	mtu := lc.mtu.get()
+	if lc.chunking != ChunkAdaptive {
+		return mtu
+	}
+	size := mtu
+	rate := rlimit.Inf
+	for _, l := range lc.limiters() {
+		if burst := l.Burst(); burst < size {
+			size = burst
+		}
+		if limit := l.Limit(); limit < rate {
+			rate = limit
+		}
+	}
+	if rate != rlimit.Inf {
+		if paced := int(float64(rate) * adaptiveInterval.Seconds()); paced < size {
+			size = paced
+		}
+	}
+	if size < minAdaptiveChunk {
+		size = minAdaptiveChunk
+	}
+	if size > mtu {
+		size = mtu
+	}
+	// overhead still has to fit
+	if size <= lc.recordOverhead {
+		size = lc.recordOverhead + 1
+	}
+	return size
+}
+
+// writeWhole waits for the whole of b to be granted, an MTU at a time so
+// no single wait exceeds the limiter bursts, and then writes it at once
+func (lc LimitedConn) writeWhole(ctx context.Context, b []byte) (int, error) {
+	size := lc.mtu.get() - lc.recordOverhead
+	overhead := 0
+	for left := len(b); left > 0; left -= size {
+		chunk := left
+		if chunk > size {
+			chunk = size
+		}
+		if err := lc.waitN(ctx, chunk+lc.recordOverhead); err != nil {
+			lc.shedWait(err, len(b))
+			return 0, err
+		}
+		overhead += lc.recordOverhead
+	}
+	w, err := lc.send(ctx, b, overhead)
+	if err != nil {
+		return 0, err
+	}
+	return w, nil
+}
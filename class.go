@@ -0,0 +1,140 @@
+package limlistener
+
+import (
+	"sync"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// limitClass are the limits of a class of connections
+type limitClass struct {
+	// per-connection limit of the class connections, 0 keeps the listener's
+	connLimit int
+	// cap shared by the class connections, 0 for none
+	limit int
+	// aggregate limiter shared by all the class connections, nil if none
+	limiter *limiter
+}
+
+// connClass tracks which class a connection belongs to
+type connClass struct {
+	mu   sync.Mutex
+	name string
+	// aggregate limiter of the class
+	limiter *limiter
+	// per-connection limit overriding the class and listener ones
+	override int
+	// supplied by the application, see SetLimiter
+	custom Limiter
+}
+
+func (cc *connClass) get() (string, *limiter) {
+	if cc == nil {
+		return "", nil
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return cc.name, cc.limiter
+}
+
+func (cc *connClass) set(name string, limiter *limiter) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.name = name
+	cc.limiter = limiter
+}
+
+func (cc *connClass) getOverride() int {
+	if cc == nil {
+		return 0
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return cc.override
+}
+
+func (cc *connClass) setOverride(limit int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.override = limit
+}
+
+// SetClass defines the limits of a class of connections: connLimit replaces
+// the per-connection limit of its connections (0 keeps the listener's) and
+// limit caps the bandwidth they share (0 for no cap). Running connections of
+// the class get the new limits right away, the change is recorded
+// in the audit trail.
+func (ll *LimitedListener) SetClass(name string, connLimit, limit int) {
+	ll.SetClassBy("", name, connLimit, limit)
+}
+
+func (ll *LimitedListener) setClass(name string, connLimit, limit int) {
+	ll.mu.Lock()
+	cl, ok := ll.classes[name]
+	if !ok {
+		cl = &limitClass{}
+		if ll.classes == nil {
+			ll.classes = make(map[string]*limitClass)
+		}
+		ll.classes[name] = cl
+	}
+	cl.connLimit = connLimit
+	cl.limit = limit
+	switch {
+	case limit <= 0 && cl.limiter != nil:
+		cl.limiter.SetLimit(rlimit.Inf)
+	case limit > 0 && cl.limiter == nil:
+		cl.limiter = newLimiter(rlimit.Limit(limit), ll.mtu)
+	case limit > 0:
+		cl.limiter.setLimitSmoothed(rlimit.Limit(limit), ll.limitTransition(), ll.mtu)
+	}
+	ll.mu.Unlock()
+
+	// update the running connections of the class
+	for _, conn := range ll.connections() {
+		if class, _ := conn.class.get(); class == name {
+			ll.classify(*conn, name)
+		}
+	}
+}
+
+// classify puts a connection in a class, unknown classes are only recorded
+func (ll *LimitedListener) classify(lc LimitedConn, name string) {
+	ll.mu.Lock()
+	var classLimiter *limiter
+	if cl := ll.classes[name]; cl != nil {
+		classLimiter = cl.limiter
+	}
+	lc.class.set(name, classLimiter)
+	connLimit := ll.connLimitFor(&lc)
+	ll.mu.Unlock()
+
+	lc.connLimiter.setLimitSmoothed(rlimit.Limit(connLimit), ll.limitTransition(), lc.mtu.get())
+	lc.events.limitChanged(connLimit)
+	lc.tuneBuffers()
+	ll.applyTwoRate(lc)
+	ll.applyDSCP(lc)
+}
+
+// connLimitFor returns the per-connection limit that applies to lc,
+// must be called with the lock held
+func (ll *LimitedListener) connLimitFor(lc *LimitedConn) int {
+	if override := lc.class.getOverride(); override > 0 {
+		return override
+	}
+	name, _ := lc.class.get()
+	if cl := ll.classes[name]; cl != nil && cl.connLimit > 0 {
+		return cl.connLimit
+	}
+	return ll.connLimit
+}
+
+// Class returns the class the connection was put in, if any
+func (lc LimitedConn) Class() string {
+	name, _ := lc.class.get()
+	return name
+}
@@ -0,0 +1,55 @@
+package limlistener
+
+import "time"
+
+// Clock is the time source used by the interval based accounting
+// (eg. windowed global limits), it can be swapped for tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the default wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// scaledClock runs factor times faster than the wall clock
+type scaledClock struct {
+	origin time.Time
+	start  time.Time
+	factor float64
+}
+
+// NewScaledClock returns a clock starting at start that runs factor times
+// faster than the wall clock (eg. 3600 makes an hour pass every second) so
+// long running quota and schedule scenarios can be tested in seconds
+func NewScaledClock(start time.Time, factor float64) Clock {
+	if factor <= 0 {
+		factor = 1
+	}
+	return &scaledClock{
+		origin: time.Now(),
+		start:  start,
+		factor: factor,
+	}
+}
+
+func (c *scaledClock) Now() time.Time {
+	elapsed := time.Since(c.origin)
+	return c.start.Add(time.Duration(float64(elapsed) * c.factor))
+}
+
+func (c *scaledClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	time.AfterFunc(time.Duration(float64(d)/c.factor), func() {
+		ch <- c.Now()
+	})
+	return ch
+}
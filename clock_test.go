@@ -0,0 +1,60 @@
+package limlistener
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScaledClockWindowQuota(t *testing.T) {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	// an hour every 100ms
+	clock := NewScaledClock(start, 36000)
+	w := newWindow(clock)
+	w.set(1000, time.Hour)
+
+	ctx := context.Background()
+	if err := w.waitN(ctx, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if avail := w.available(); avail != 0 {
+		t.Fatalf("available() with the quota used up = %d, want 0", avail)
+	}
+	// the next byte waits for the hour to be over
+	began := time.Now()
+	if err := w.waitN(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if now := clock.Now(); now.Before(start.Add(time.Hour)) {
+		t.Errorf("quota reset at %v, before the hour was over", now)
+	}
+	if elapsed := time.Since(began); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("waited %v for the quota to reset, want about 100ms", elapsed)
+	}
+	if avail := w.available(); avail != 999 {
+		t.Errorf("available() in the next hour = %d, want 999", avail)
+	}
+}
+
+func TestScaledClockBudgetMonth(t *testing.T) {
+	// a second before next month, ten seconds every 100ms
+	next := monthStart(time.Now()).AddDate(0, 1, 0)
+	start := next.Add(-time.Second)
+	clock := NewScaledClock(start, 100)
+	ll := newTestListener(t)
+	ll.SetClock(clock)
+	budget := NewBudget(1, 1)
+	ll.SetBudget(budget)
+
+	budget.charge(gigabyte)
+	status := budget.Status()
+	if status.Fraction < 1 || !status.Period.Equal(monthStart(start)) {
+		t.Fatalf("Status() = %+v, want this month's budget spent", status)
+	}
+	time.Sleep(50 * time.Millisecond)
+	// the next month starts over
+	status = budget.Status()
+	if !status.Period.Equal(next) || status.Bytes != 0 {
+		t.Errorf("Status() = %+v, want nothing spent in %v", status, next.Month())
+	}
+}
@@ -0,0 +1,104 @@
+package limlistener
+
+import "time"
+
+// CloseMode decides what closing the listener does with the
+// connections it accepted that are still open
+type CloseMode int
+
+const (
+	// CloseListenerOnly leaves them alone, their handlers close them
+	CloseListenerOnly CloseMode = iota
+	// CloseConns closes them, flushing queued writes (see SetCloseFlush)
+	CloseConns
+	// AbortConns closes them right away, discarding unsent data
+	AbortConns
+)
+
+// SetCloseMode sets what Close does with the connections still open,
+// so stopping the service stops its traffic too. With a grace period Close
+// first waits up to that long for their handlers to finish with them.
+func (ll *LimitedListener) SetCloseMode(mode CloseMode, grace time.Duration) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.closeMode = mode
+	ll.closeGrace = grace
+}
+
+// CloseAll closes every connection still open and returns how many
+// there were, they're reported as CloseShutdown
+func (ll *LimitedListener) CloseAll() int {
+	conns := ll.connections()
+	for _, conn := range conns {
+		conn.Close()
+		ll.forget(*conn, CloseShutdown)
+	}
+	return len(conns)
+}
+
+// AbortAll is CloseAll closing them abortively
+func (ll *LimitedListener) AbortAll() int {
+	conns := ll.connections()
+	for _, conn := range conns {
+		conn.AbortiveClose()
+		ll.forget(*conn, CloseShutdown)
+	}
+	return len(conns)
+}
+
+// isShutdown tells if the listener was closed
+func (ll *LimitedListener) isShutdown() bool {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	return ll.shutdown
+}
+
+// cascadeClose applies the close mode to the connections left
+func (ll *LimitedListener) cascadeClose() {
+	ll.mu.Lock()
+	mode, grace := ll.closeMode, ll.closeGrace
+	var drain *connsDrain
+	if mode != CloseListenerOnly && grace > 0 && len(ll.conns) > 0 {
+		drain = &connsDrain{done: make(chan struct{})}
+		ll.drain = drain
+	}
+	ll.mu.Unlock()
+
+	if drain != nil {
+		t := time.NewTimer(grace)
+		select {
+		case <-drain.done:
+		case <-t.C:
+		}
+		t.Stop()
+		ll.mu.Lock()
+		ll.drain = nil
+		ll.mu.Unlock()
+	}
+	switch mode {
+	case CloseConns:
+		ll.CloseAll()
+	case AbortConns:
+		ll.AbortAll()
+	}
+}
+
+// connsDrain tells a cascading Close all connections are gone
+type connsDrain struct {
+	done chan struct{}
+}
+
+// forgot is told how many connections are left after one went away,
+// must be called with the listener lock held
+func (d *connsDrain) forgot(left int) {
+	if d == nil || left > 0 {
+		return
+	}
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+}
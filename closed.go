@@ -0,0 +1,137 @@
+package limlistener
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// close events kept for a slow reader of Closed before dropping new ones
+const closedQueueSize = 4096
+
+// CloseReason tells why a connection was cleaned up
+type CloseReason int
+
+const (
+	// cleaned up with CloseConnection
+	CloseNormal CloseReason = iota
+	// cleaned up with AbortConnection
+	CloseAborted
+	// evicted by an admission policy or to relieve descriptor pressure
+	CloseEvicted
+	// closed along with the listener, see SetCloseMode
+	CloseShutdown
+	// handed off to another listener, see Detach
+	CloseDetached
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseNormal:
+		return "closed"
+	case CloseAborted:
+		return "aborted"
+	case CloseEvicted:
+		return "evicted"
+	case CloseShutdown:
+		return "shutdown"
+	case CloseDetached:
+		return "detached"
+	}
+	return "unknown"
+}
+
+// ConnClosedEvent reports a connection cleaned up by the listener
+type ConnClosedEvent struct {
+	ID         int
+	RemoteAddr net.Addr
+	Reason     CloseReason
+	Time       time.Time
+	// its counters as it went away
+	Stats ConnStats
+	// events dropped right before this one, the reader falling behind
+	Missed int
+}
+
+// Closed returns a channel delivering, in order, an event for every
+// connection cleaned up from now on, so supervisory code can follow the
+// churn without polling snapshots. Up to a few thousand events wait for a
+// slow reader, the ones past that are dropped and counted in the Missed of
+// the next one delivered. The channel is closed along with the listener.
+func (ll *LimitedListener) Closed() <-chan ConnClosedEvent {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	if ll.closed == nil {
+		ll.closed = &closeNotifier{
+			wake: make(chan struct{}, 1),
+			ch:   make(chan ConnClosedEvent),
+			stop: make(chan struct{}),
+		}
+		go ll.closed.run()
+	}
+	return ll.closed.ch
+}
+
+// closeNotifier queues close events for the Closed channel
+type closeNotifier struct {
+	mu     sync.Mutex
+	queue  []ConnClosedEvent
+	missed int
+	wake   chan struct{}
+	ch     chan ConnClosedEvent
+	stop   chan struct{}
+}
+
+// push queues an event, never blocking
+func (cn *closeNotifier) push(ev ConnClosedEvent) {
+	if cn == nil {
+		return
+	}
+	cn.mu.Lock()
+	if len(cn.queue) >= closedQueueSize {
+		cn.missed++
+		cn.mu.Unlock()
+		return
+	}
+	ev.Missed, cn.missed = cn.missed, 0
+	cn.queue = append(cn.queue, ev)
+	cn.mu.Unlock()
+
+	select {
+	case cn.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers the queued events until stopped
+func (cn *closeNotifier) run() {
+	defer close(cn.ch)
+	for {
+		cn.mu.Lock()
+		if len(cn.queue) == 0 {
+			cn.mu.Unlock()
+			select {
+			case <-cn.wake:
+				continue
+			case <-cn.stop:
+				return
+			}
+		}
+		ev := cn.queue[0]
+		cn.queue = cn.queue[1:]
+		cn.mu.Unlock()
+
+		select {
+		case cn.ch <- ev:
+		case <-cn.stop:
+			return
+		}
+	}
+}
+
+// evict cleans up a connection abortively on the listener's own initiative
+func (ll *LimitedListener) evict(conn LimitedConn) {
+	conn.AbortiveClose()
+	ll.forget(conn, CloseEvicted)
+}
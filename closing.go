@@ -0,0 +1,161 @@
+package limlistener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connClosing is the closed state of a connection shared by all of its
+// copies, closing it more than once returns net.ErrClosed. It doubles as
+// the context plain writes and reads wait on the limiters with, canceled
+// once the connection is closed, after flushing its queued writes when
+// Close flushes them, so the flush still gets to wait on the limiters.
+// It also keeps the read and write deadlines of the connection, which
+// bound those waits as well.
+type connClosing struct {
+	// as UnixNano, 0 for none, first for alignment
+	readDeadline  int64
+	writeDeadline int64
+
+	closed int32
+	once   sync.Once
+	done   chan struct{}
+}
+
+func newConnClosing() *connClosing {
+	return &connClosing{done: make(chan struct{})}
+}
+
+// start marks the connection closed, false if it already was
+func (c *connClosing) start() bool {
+	// connections built by hand have none
+	if c == nil {
+		return true
+	}
+	return atomic.CompareAndSwapInt32(&c.closed, 0, 1)
+}
+
+// cancel gets the waits on the limiters out of the way, once the
+// connection has been closed
+func (c *connClosing) cancel() {
+	if c == nil {
+		return
+	}
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+// is tells if the connection was closed
+func (c *connClosing) is() bool {
+	if c == nil {
+		return false
+	}
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// context returns what plain writes and reads wait with
+func (c *connClosing) context() context.Context {
+	if c == nil {
+		return context.Background()
+	}
+	return c
+}
+
+// setDeadlines records the deadlines waits on the limiters give up at,
+// the zero time for none
+func (c *connClosing) setDeadlines(read, write bool, t time.Time) {
+	if c == nil {
+		return
+	}
+	var deadline int64
+	if !t.IsZero() {
+		deadline = t.UnixNano()
+	}
+	if read {
+		atomic.StoreInt64(&c.readDeadline, deadline)
+	}
+	if write {
+		atomic.StoreInt64(&c.writeDeadline, deadline)
+	}
+}
+
+// deadlines returns the read and write deadlines of the connection
+func (c *connClosing) deadlines() (read, write time.Time) {
+	if c == nil {
+		return time.Time{}, time.Time{}
+	}
+	return deadlineTime(atomic.LoadInt64(&c.readDeadline)), deadlineTime(atomic.LoadInt64(&c.writeDeadline))
+}
+
+func deadlineTime(deadline int64) time.Time {
+	if deadline == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, deadline)
+}
+
+// readContext returns what plain reads wait with, bounded by the read
+// deadline if there's one. cancel has to be called once done.
+func (c *connClosing) readContext() (ctx context.Context, cancel context.CancelFunc) {
+	if c == nil {
+		return context.Background(), func() {}
+	}
+	return c.withDeadline(atomic.LoadInt64(&c.readDeadline))
+}
+
+// writeContext is readContext for plain writes and the write deadline
+func (c *connClosing) writeContext() (ctx context.Context, cancel context.CancelFunc) {
+	if c == nil {
+		return context.Background(), func() {}
+	}
+	return c.withDeadline(atomic.LoadInt64(&c.writeDeadline))
+}
+
+func (c *connClosing) withDeadline(deadline int64) (context.Context, context.CancelFunc) {
+	if deadline == 0 {
+		return c, func() {}
+	}
+	return context.WithDeadline(c, deadlineTime(deadline))
+}
+
+// waitErr is the error of a failed wait on the limiters with ctx: rate
+// limiters give up early on waits that can't make the deadline, those
+// fail with os.ErrDeadlineExceeded like the connection itself would
+func waitErr(ctx context.Context, err error) error {
+	if _, ok := ctx.Deadline(); ok && !errors.Is(err, ErrBurstExceeded) && !errors.Is(err, net.ErrClosed) {
+		return os.ErrDeadlineExceeded
+	}
+	return err
+}
+
+// Deadline implements context.Context, there's none
+func (c *connClosing) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done implements context.Context, closed along with the connection
+func (c *connClosing) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err implements context.Context, waits on a closed connection
+// fail like its writes do
+func (c *connClosing) Err() error {
+	select {
+	case <-c.done:
+		return net.ErrClosed
+	default:
+		return nil
+	}
+}
+
+// Value implements context.Context, it carries none
+func (c *connClosing) Value(key interface{}) interface{} {
+	return nil
+}
@@ -0,0 +1,254 @@
+package limlistener
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteDeadlineEndsLimiterWait(t *testing.T) {
+	ll := newTestListener(t)
+	conn := acceptDrained(t, ll)
+	conn.SetLimit(1000)
+
+	conn.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	start := time.Now()
+	_, err := conn.Write(make([]byte, 10000))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write() = %v, want os.ErrDeadlineExceeded", err)
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("%v isn't a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Write() gave up after %v, past the deadline", elapsed)
+	}
+
+	// clearing the deadline lets writes wait again
+	conn.SetWriteDeadline(time.Time{})
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Errorf("Write() without a deadline = %v", err)
+	}
+}
+
+func TestReadDeadlineEndsLimiterWait(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetReadLimits(0, 1000)
+	conn, client := acceptPair(t, ll)
+	go client.Write(make([]byte, 10000))
+
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	start := time.Now()
+	_, err := io.ReadFull(conn, make([]byte, 10000))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ReadFull() = %v, want os.ErrDeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("ReadFull() gave up after %v, past the deadline", elapsed)
+	}
+}
+
+func TestDeadlineWaitClosed(t *testing.T) {
+	ll := newTestListener(t)
+	conn := acceptDrained(t, ll)
+	conn.SetLimit(1000)
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	// closing still reads as closing, not as missing the deadline
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.Close()
+	}()
+	if _, err := conn.Write(make([]byte, 10000)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Write() = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	ll := newTestListener(t)
+	conn, _ := acceptPair(t, ll)
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("closing the connection again = %v, want net.ErrClosed", err)
+	}
+	if err := ll.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ll.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("closing the listener again = %v, want net.ErrClosed", err)
+	}
+	if _, err := ll.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept() on a closed listener = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestReleasingConnCloseTwice(t *testing.T) {
+	ll := newTestListener(t)
+	client, err := net.Dial("tcp", ll.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := releasingListener{ll: ll}.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ll.Conns(nil)); n != 0 {
+		t.Errorf("listener still tracks %d connections", n)
+	}
+	if err := conn.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("closing again = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestCloseAcceptWriteRace(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetLimits(0, 1<<20)
+	addr := ll.Addr().String()
+
+	stop := make(chan struct{})
+	var clients sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				c, err := net.Dial("tcp", addr)
+				if err != nil {
+					continue
+				}
+				io.Copy(ioutil.Discard, c)
+				c.Close()
+			}
+		}()
+	}
+
+	var handlers sync.WaitGroup
+	accepted := make(chan struct{}, 1)
+	acceptDone := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := ll.Accept()
+			if err != nil {
+				acceptDone <- err
+				return
+			}
+			select {
+			case accepted <- struct{}{}:
+			default:
+			}
+			lc := conn.(LimitedConn)
+			handlers.Add(2)
+			// one copy writing while another closes it
+			go func() {
+				defer handlers.Done()
+				for i := 0; i < 10; i++ {
+					if _, err := lc.Write(make([]byte, 4096)); err != nil {
+						return
+					}
+				}
+			}()
+			go func() {
+				defer handlers.Done()
+				time.Sleep(time.Millisecond)
+				lc.Close()
+				lc.Close()
+				ll.CloseConnection(lc)
+			}()
+		}
+	}()
+
+	<-accepted
+	time.Sleep(100 * time.Millisecond)
+	// closed from several goroutines at once, while accepting
+	var closers sync.WaitGroup
+	var nilErrs int32
+	for i := 0; i < 4; i++ {
+		closers.Add(1)
+		go func() {
+			defer closers.Done()
+			if ll.Close() == nil {
+				atomic.AddInt32(&nilErrs, 1)
+			}
+		}()
+	}
+	closers.Wait()
+	if nilErrs != 1 {
+		t.Errorf("%d closes of the listener succeeded, want 1", nilErrs)
+	}
+	if err := <-acceptDone; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept() after Close = %v, want net.ErrClosed", err)
+	}
+	close(stop)
+	handlers.Wait()
+	if n := len(ll.Conns(nil)); n != 0 {
+		t.Errorf("listener still tracks %d connections", n)
+	}
+	clients.Wait()
+}
+
+func TestCloseFlushUnderLimit(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetWriteQueue(64<<10, QueueBlock)
+	ll.SetCloseFlush(5 * time.Second)
+	conn, client := acceptPair(t, ll)
+	conn.SetLimit(20000)
+	read := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(ioutil.Discard, client)
+		read <- n
+	}()
+
+	if _, err := conn.Write(make([]byte, 10000)); err != nil {
+		t.Fatal(err)
+	}
+	// the queued writes still wait on the limiter while flushing
+	start := time.Now()
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("flushed 10000 bytes at 20000 bytes/sec in %v", elapsed)
+	}
+	if n := <-read; n != 10000 {
+		t.Errorf("client read %d bytes, want 10000", n)
+	}
+	if err := conn.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("closing again = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestCloseFlushTimeoutUnderLimit(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetWriteQueue(64<<10, QueueBlock)
+	conn := acceptDrained(t, ll)
+	conn.SetLimit(1000)
+
+	if _, err := conn.Write(make([]byte, 10000)); err != nil {
+		t.Fatal(err)
+	}
+	// the flush running out of time gets the drain out of its wait
+	start := time.Now()
+	if err := conn.CloseWithTimeout(100 * time.Millisecond); !errors.Is(err, ErrFlushTimeout) {
+		t.Errorf("CloseWithTimeout() = %v, want ErrFlushTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("CloseWithTimeout() returned after %v", elapsed)
+	}
+}
@@ -0,0 +1,333 @@
+// limbench measures how accurately connections are shaped end-to-end,
+// iperf style: a server (-s) and a client (-c) streaming to each other
+// through a LimitedListener (download tests) or a LimitedDialer (upload
+// tests), the client reporting the throughput against the expected rate.
+//
+//	$ limbench -s -rate 5M
+//	$ limbench -c server:7001 -rate 5M -time 10s -streams 4
+//	$ limbench -c server:7001 -rate 5M -up -json
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lrascao/limlistener"
+)
+
+var (
+	server   = flag.Bool("s", false, "run as server")
+	listen   = flag.String("listen", ":7001", "address the server listens on")
+	connect  = flag.String("c", "", "run as client against this server address")
+	rate     = flag.String("rate", "1M", "per-stream limit in bytes/sec (K, M and G suffixes), enforced by the sending side")
+	global   = flag.String("global", "0", "limit shared by all streams in bytes/sec, 0 for none")
+	duration = flag.Duration("time", 10*time.Second, "test duration")
+	streams  = flag.Int("streams", 1, "parallel streams")
+	up       = flag.Bool("up", false, "upload test, the client sends")
+	interval = flag.Duration("interval", time.Second, "report interval")
+	asJSON   = flag.Bool("json", false, "print the report as JSON")
+	buffer   = flag.String("buffer", "32K", "size of each write streamed")
+	grant    = flag.String("grant", "0", "largest grant the server reserves at once, see SetMaxGrant")
+	// tolerated relative error of the measured rate
+	tolerance = flag.Float64("tolerance", 0.05, "relative error above which the client exits with status 1")
+)
+
+func main() {
+	flag.Parse()
+	perStream, err := parseRate(*rate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	globalLimit, err := parseRate(*global)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if bufferSize, err = parseRate(*buffer); err != nil || bufferSize <= 0 {
+		log.Fatalf("invalid buffer size %q", *buffer)
+	}
+	maxGrant, err := parseRate(*grant)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case *server:
+		log.Fatal(serve(*listen, perStream, globalLimit, maxGrant))
+	case *connect != "":
+		r, err := run(*connect, perStream, globalLimit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.print(os.Stdout, *asJSON)
+		if math.Abs(r.Error) > *tolerance {
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// parseRate parses a bytes/sec amount with an optional K, M or G suffix
+func parseRate(s string) (int, error) {
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int(n * float64(mult)), nil
+}
+
+// serve runs the server: download tests are shaped by the listener,
+// upload tests are received and measured
+func serve(addr string, perStream, globalLimit, maxGrant int) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	ll := limlistener.NewWithListener(l)
+	defer ll.Close()
+
+	ll.SetLimits(globalLimit, perStream)
+	ll.SetMaxGrant(maxGrant)
+	log.Printf("listening on %s, %d bytes/sec per stream", l.Addr(), perStream)
+	return ll.Serve(func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		// each stream starts with "<down|up> <duration>\n"
+		line, err := r.ReadString('\n')
+		if err != nil {
+			log.Printf("%s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		var direction string
+		var d time.Duration
+		if _, err := fmt.Sscanf(line, "%s %d", &direction, &d); err != nil {
+			log.Printf("%s: bad request %q", conn.RemoteAddr(), line)
+			return
+		}
+		switch direction {
+		case "down":
+			n, _ := stream(conn, d, nil)
+			log.Printf("%s: sent %d bytes", conn.RemoteAddr(), n)
+		case "up":
+			start := time.Now()
+			n, _ := io.Copy(io.Discard, r)
+			elapsed := time.Since(start)
+			fmt.Fprintf(conn, "%d %d\n", n, elapsed)
+			log.Printf("%s: received %d bytes", conn.RemoteAddr(), n)
+		}
+	})
+}
+
+// bufferSize is the size of each write streamed
+var bufferSize int
+
+// stream writes to w for d, adding what's written to counter
+func stream(w io.Writer, d time.Duration, counter *int64) (int64, error) {
+	buf := make([]byte, bufferSize)
+	var n int64
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		m, err := w.Write(buf)
+		n += int64(m)
+		if counter != nil {
+			atomic.AddInt64(counter, int64(m))
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// report is the outcome of a test
+type report struct {
+	Direction string
+	Streams   int
+	Duration  time.Duration
+	Bytes     int64
+	// measured and expected aggregate rates in bytes/sec
+	Rate     float64
+	Expected float64
+	// relative error of the measured rate
+	Error float64
+	// aggregate rate of each report interval, and their spread
+	Intervals []float64
+	StdDev    float64
+}
+
+func (r report) print(w io.Writer, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+		return
+	}
+	for i, v := range r.Intervals {
+		fmt.Fprintf(w, "[%3d] %12.0f bytes/sec\n", i, v)
+	}
+	fmt.Fprintf(w, "%s, %d streams, %s: %d bytes\n", r.Direction, r.Streams, r.Duration.Round(time.Millisecond), r.Bytes)
+	fmt.Fprintf(w, "rate %.0f bytes/sec, expected %.0f (%+.2f%%), stddev %.0f\n", r.Rate, r.Expected, r.Error*100, r.StdDev)
+}
+
+// run runs the client, returning the report of the test
+func run(addr string, perStream, globalLimit int) (report, error) {
+	direction := "down"
+	if *up {
+		direction = "up"
+	}
+	ld := limlistener.NewWithDialer(&net.Dialer{Timeout: 5 * time.Second})
+	if *up {
+		ld.SetLimits(globalLimit, perStream)
+	}
+
+	expected := float64(perStream * *streams)
+	if globalLimit > 0 && float64(globalLimit) < expected {
+		expected = float64(globalLimit)
+	}
+	r := report{
+		Direction: direction,
+		Streams:   *streams,
+		Expected:  expected,
+	}
+
+	// bytes moved so far by all streams, sampled every interval
+	var counter int64
+	done := make(chan struct{})
+	sampled := make(chan []float64)
+	go func() {
+		sampled <- sample(&counter, done)
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, *streams)
+	received := make(chan int64, *streams)
+	for i := 0; i < *streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := runStream(&ld, addr, direction, &counter)
+			if err != nil {
+				errs <- err
+				return
+			}
+			received <- n
+		}()
+	}
+	wg.Wait()
+	r.Duration = time.Since(start)
+	close(done)
+	r.Intervals = <-sampled
+	close(errs)
+	close(received)
+	if err := <-errs; err != nil {
+		return r, err
+	}
+	for n := range received {
+		r.Bytes += n
+	}
+
+	r.Rate = float64(r.Bytes) / r.Duration.Seconds()
+	if r.Expected > 0 {
+		r.Error = (r.Rate - r.Expected) / r.Expected
+	}
+	r.StdDev = stddev(r.Intervals)
+	return r, nil
+}
+
+// runStream runs a single stream, returning the bytes the receiving side got
+func runStream(ld *limlistener.LimitedDialer, addr, direction string, counter *int64) (int64, error) {
+	conn, err := ld.Dial("tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer ld.CloseConnection(conn)
+
+	if _, err := fmt.Fprintf(conn, "%s %d\n", direction, *duration); err != nil {
+		return 0, err
+	}
+	if direction == "down" {
+		return io.Copy(countingWriter{counter}, conn)
+	}
+	if _, err := stream(conn, *duration, counter); err != nil {
+		return 0, err
+	}
+	// tell the server we're done, it answers with what it got
+	if err := conn.(limlistener.LimitedConn).CloseWrite(); err != nil {
+		return 0, err
+	}
+	var n int64
+	var elapsed time.Duration
+	if _, err := fmt.Fscanf(conn, "%d %d\n", &n, &elapsed); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+type countingWriter struct {
+	counter *int64
+}
+
+func (cw countingWriter) Write(b []byte) (int, error) {
+	atomic.AddInt64(cw.counter, int64(len(b)))
+	return len(b), nil
+}
+
+// sample records the rate of counter every interval until done
+func sample(counter *int64, done chan struct{}) []float64 {
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	var rates []float64
+	var last int64
+	for {
+		select {
+		case <-done:
+			return rates
+		case <-ticker.C:
+		}
+		now := atomic.LoadInt64(counter)
+		rates = append(rates, float64(now-last)/interval.Seconds())
+		last = now
+	}
+}
+
+func stddev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sq / float64(len(values)-1))
+}
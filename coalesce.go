@@ -0,0 +1,136 @@
+package limlistener
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flushTicker is the common tick granted chunks are flushed on
+type flushTicker struct {
+	mu sync.Mutex
+	// closed on the next tick
+	next chan struct{}
+	stop chan struct{}
+}
+
+func newFlushTicker(interval time.Duration) *flushTicker {
+	ft := &flushTicker{
+		next: make(chan struct{}),
+		stop: make(chan struct{}),
+	}
+	go ft.run(interval)
+	return ft
+}
+
+func (ft *flushTicker) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ft.stop:
+			return
+		case <-ticker.C:
+		}
+		ft.mu.Lock()
+		close(ft.next)
+		ft.next = make(chan struct{})
+		ft.mu.Unlock()
+	}
+}
+
+// wait returns a channel closed on the next tick
+func (ft *flushTicker) wait() <-chan struct{} {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	return ft.next
+}
+
+// SetFlushTick makes every connection hold on to its granted chunks and
+// flush them together on a common tick (eg. every 10ms), so each tick a
+// connection writes what it's been granted in one go, batching syscalls at
+// the cost of up to a tick of latency. A tick of 0 writes chunks as soon as
+// they're granted again.
+func (ll *LimitedListener) SetFlushTick(tick time.Duration) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	if ll.flush != nil {
+		close(ll.flush.stop)
+		ll.flush = nil
+	}
+	if tick > 0 {
+		ll.flush = newFlushTicker(tick)
+	}
+}
+
+func (ll *LimitedListener) flushTicker() *flushTicker {
+	// dialed connections have no listener
+	if ll == nil {
+		return nil
+	}
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	return ll.flush
+}
+
+// writeCoalesced waits for b a chunk at a time like writeContext does but
+// only writes what's been granted so far on each tick
+func (lc LimitedConn) writeCoalesced(ctx context.Context, b []byte, ft *flushTicker) (int, error) {
+	n := 0
+	// granted bytes not written yet and how many chunks they are
+	var pending []byte
+	chunks := 0
+	tick := ft.wait()
+	flush := func() error {
+		w, err := lc.send(ctx, pending, chunks*lc.recordOverhead)
+		n += w
+		pending = pending[len(pending):]
+		chunks = 0
+		tick = ft.wait()
+		return err
+	}
+	for len(b) > 0 {
+		size := lc.chunkSize() - lc.recordOverhead
+		s := b
+		if len(b) > size {
+			s = b[:size]
+		}
+		if err := lc.waitN(ctx, len(s)+lc.recordOverhead); err != nil {
+			lc.shedWait(err, len(pending)+len(b))
+			return 0, err
+		}
+		// granted chunks are contiguous in b
+		if len(pending) == 0 {
+			pending = s
+		} else {
+			pending = pending[:len(pending)+len(s)]
+		}
+		chunks++
+		b = b[len(s):]
+
+		select {
+		case <-tick:
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		default:
+		}
+	}
+	if len(pending) == 0 {
+		return n, nil
+	}
+	select {
+	case <-tick:
+	case <-ctx.Done():
+		lc.shedWait(ctx.Err(), len(pending))
+		return n, ctx.Err()
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
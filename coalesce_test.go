@@ -0,0 +1,71 @@
+package limlistener
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeCounter counts the writes to a connection
+type writeCounter struct {
+	net.Conn
+	writes *int32
+}
+
+func (c writeCounter) Write(b []byte) (int, error) {
+	atomic.AddInt32(c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+// countingListener counts the writes to the connections it accepts
+type countingListener struct {
+	net.Listener
+	writes int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return writeCounter{c, &l.writes}, nil
+}
+
+// coalescedWrites returns how many writes it took to send 20 chunks at
+// about 10 chunks every 100ms, flushed on tick
+func coalescedWrites(t *testing.T, tick time.Duration) int32 {
+	t.Helper()
+	l := &countingListener{Listener: listen(t)}
+	ll := NewWithListener(l)
+	defer ll.Close()
+	ll.SetFlushTick(tick)
+	conn, client := acceptPair(t, &ll)
+	conn.SetLimit(100 * ll.mtu)
+	read := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(ioutil.Discard, client)
+		read <- n
+	}()
+
+	if n, err := conn.Write(make([]byte, 20*ll.mtu)); err != nil || n != 20*ll.mtu {
+		t.Fatalf("Write() = %d, %v, want %d bytes written", n, err, 20*ll.mtu)
+	}
+	ll.CloseConnection(conn)
+	if n := <-read; n != int64(20*ll.mtu) {
+		t.Errorf("client read %d bytes, want %d", n, 20*ll.mtu)
+	}
+	return atomic.LoadInt32(&l.writes)
+}
+
+func TestFlushTickBatchesWrites(t *testing.T) {
+	if writes := coalescedWrites(t, 0); writes != 20 {
+		t.Errorf("20 chunks written in %d writes without a flush tick, want one each", writes)
+	}
+	// about 200ms worth of chunks, on a tick every 50ms
+	if writes := coalescedWrites(t, 50*time.Millisecond); writes < 2 || writes > 6 {
+		t.Errorf("20 chunks written in %d writes on a 50ms tick, want about 4", writes)
+	}
+}
@@ -0,0 +1,218 @@
+package limlistener
+
+import (
+	"net"
+	"time"
+)
+
+// Config gathers a listener's settings in one place, zero values
+// leave the defaults alone
+type Config struct {
+	Write  WriteConfig
+	Read   ReadConfig
+	Accept AcceptConfig
+	// limit shared by the connections of each client IP
+	// and how many client IPs are tracked at most
+	IPLimit      int
+	IPv6Prefix   int
+	IPMaxEntries int
+	// limit classes by name
+	Classes map[string]ClassConfig
+	Rules   []ConnCountRule
+	// static labels, see SetLabels
+	Labels map[string]string
+	// makes FromConfig fail when Validate finds a problem
+	Strict bool
+}
+
+// WriteConfig are the egress settings
+type WriteConfig struct {
+	GlobalLimit int
+	ConnLimit   int
+	// conn limits above the global one, see SetLimitsCheck
+	LimitsCheck LimitsCheck
+	MTU         int
+	Chunking    ChunkingStrategy
+	// async write mode, see SetWriteQueue
+	QueueSize   int
+	QueuePolicy WriteQueuePolicy
+	// queued writes flushed on close for up to, see SetCloseFlush
+	CloseFlush time.Duration
+	// kernel buffers sized to this round trip time, see SetSocketBuffers
+	BufferRTT time.Duration
+	// common tick granted chunks are flushed on, see SetFlushTick
+	FlushTick time.Duration
+	// first bytes of each connection going out without waiting,
+	// see SetShortConnections
+	ShortConnBytes int
+	// chunks a write takes before yielding, see SetYieldEvery
+	YieldEvery int
+	// largest reservation of a write spanning several chunks, see SetMaxGrant
+	MaxGrant int
+	// first bytes of each connection and their limit, see SetFastStart
+	FastStartBytes int
+	FastStartLimit int
+}
+
+// ReadConfig are the ingress settings
+type ReadConfig struct {
+	GlobalLimit int
+	ConnLimit   int
+}
+
+// AcceptConfig are the settings of the accept loop
+type AcceptConfig struct {
+	// retrying of temporary errors, see SetAcceptBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// response to running out of file descriptors, see SetFDPressure
+	FDPressure FDPressureAction
+	FDMinIdle  time.Duration
+}
+
+// ClassConfig are the limits of a class, see SetClass
+type ClassConfig struct {
+	ConnLimit int
+	Limit     int
+	// optional two-rate limits and DSCP code point
+	TwoRate *TwoRate
+	DSCP    *int
+}
+
+// Option tweaks a Config, for settings that don't warrant spelling
+// out a whole one
+type Option func(*Config)
+
+// WithLimits sets the global and per-connection write limits
+func WithLimits(globalLimit, connLimit int) Option {
+	return func(c *Config) {
+		c.Write.GlobalLimit = globalLimit
+		c.Write.ConnLimit = connLimit
+	}
+}
+
+// WithReadLimits sets the global and per-connection read limits
+func WithReadLimits(globalLimit, connLimit int) Option {
+	return func(c *Config) {
+		c.Read.GlobalLimit = globalLimit
+		c.Read.ConnLimit = connLimit
+	}
+}
+
+// WithMTU sets the size of the chunks writes are split into
+func WithMTU(mtu int) Option {
+	return func(c *Config) {
+		c.Write.MTU = mtu
+	}
+}
+
+// WithClass adds a limit class
+func WithClass(name string, class ClassConfig) Option {
+	return func(c *Config) {
+		if c.Classes == nil {
+			c.Classes = make(map[string]ClassConfig)
+		}
+		c.Classes[name] = class
+	}
+}
+
+// WithRules sets the connection count rules
+func WithRules(rules ...ConnCountRule) Option {
+	return func(c *Config) {
+		c.Rules = rules
+	}
+}
+
+// WithStrict makes FromConfig validate the configuration
+func WithStrict() Option {
+	return func(c *Config) {
+		c.Strict = true
+	}
+}
+
+// FromConfig creates a LimitedListener out of l configured by cfg once
+// opts are applied to it. In strict mode an inconsistent configuration
+// fails it with the ValidationErrors found.
+func FromConfig(l net.Listener, cfg Config, opts ...Option) (*LimitedListener, error) {
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ll := NewWithListener(l)
+	if cfg.Write.MTU > 0 {
+		if err := ll.SetMTU(cfg.Write.MTU); err != nil {
+			return nil, err
+		}
+	}
+	ll.SetLimitsCheck(cfg.Write.LimitsCheck)
+	if cfg.Write.GlobalLimit > 0 || cfg.Write.ConnLimit > 0 {
+		if err := ll.TrySetLimits(cfg.Write.GlobalLimit, cfg.Write.ConnLimit); err != nil {
+			return nil, err
+		}
+	}
+	ll.SetChunking(cfg.Write.Chunking)
+	if cfg.Write.QueueSize > 0 {
+		ll.SetWriteQueue(cfg.Write.QueueSize, cfg.Write.QueuePolicy)
+	}
+	if cfg.Write.CloseFlush > 0 {
+		ll.SetCloseFlush(cfg.Write.CloseFlush)
+	}
+	if cfg.Write.FastStartBytes > 0 {
+		ll.SetFastStart(cfg.Write.FastStartBytes, cfg.Write.FastStartLimit)
+	}
+	if cfg.Write.ShortConnBytes > 0 {
+		ll.SetShortConnections(cfg.Write.ShortConnBytes)
+	}
+	if cfg.Write.YieldEvery > 0 {
+		ll.SetYieldEvery(cfg.Write.YieldEvery)
+	}
+	if cfg.Write.MaxGrant > 0 {
+		ll.SetMaxGrant(cfg.Write.MaxGrant)
+	}
+	if cfg.Write.FlushTick > 0 {
+		ll.SetFlushTick(cfg.Write.FlushTick)
+	}
+	if cfg.Write.BufferRTT > 0 {
+		ll.SetSocketBuffers(cfg.Write.BufferRTT)
+	}
+	if cfg.Read.GlobalLimit > 0 || cfg.Read.ConnLimit > 0 {
+		ll.SetReadLimits(cfg.Read.GlobalLimit, cfg.Read.ConnLimit)
+	}
+	if cfg.Accept.MinBackoff > 0 {
+		ll.SetAcceptBackoff(cfg.Accept.MinBackoff, cfg.Accept.MaxBackoff)
+	}
+	ll.SetFDPressure(cfg.Accept.FDPressure, cfg.Accept.FDMinIdle, nil)
+	if cfg.IPv6Prefix > 0 {
+		ll.SetIPv6Prefix(cfg.IPv6Prefix)
+	}
+	if len(cfg.Labels) > 0 {
+		ll.SetLabels(cfg.Labels)
+	}
+	if cfg.IPMaxEntries > 0 {
+		ll.SetIPMaxEntries(cfg.IPMaxEntries)
+	}
+	if cfg.IPLimit > 0 {
+		ll.SetIPLimit(cfg.IPLimit)
+	}
+	for name, class := range cfg.Classes {
+		ll.SetClass(name, class.ConnLimit, class.Limit)
+		if class.TwoRate != nil {
+			if err := ll.SetTwoRate(name, *class.TwoRate); err != nil {
+				return nil, err
+			}
+		}
+		if class.DSCP != nil {
+			ll.SetDSCP(name, *class.DSCP)
+		}
+	}
+	if len(cfg.Rules) > 0 {
+		ll.SetConnCountRules(cfg.Rules...)
+	}
+
+	if cfg.Strict {
+		if err := ll.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &ll, nil
+}
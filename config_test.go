@@ -0,0 +1,67 @@
+package limlistener
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestFromConfig(t *testing.T) {
+	cfg := Config{
+		Write:  WriteConfig{GlobalLimit: 1 << 20, ConnLimit: 1 << 16},
+		Labels: map[string]string{"region": "eu"},
+	}
+	ll, err := FromConfig(listen(t), cfg,
+		WithReadLimits(0, 1<<15),
+		WithClass("bulk", ClassConfig{ConnLimit: 1 << 10, TwoRate: &TwoRate{Committed: 1 << 10, Peak: 1 << 12}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ll.Close()
+
+	if global, conn := ll.Limits(); global != 1<<20 || conn != 1<<16 {
+		t.Errorf("Limits() = %d, %d, want %d, %d", global, conn, 1<<20, 1<<16)
+	}
+	if ll.readConnLimit != 1<<15 {
+		t.Errorf("read conn limit = %d, want %d", ll.readConnLimit, 1<<15)
+	}
+	if cl := ll.classes["bulk"]; cl == nil || cl.connLimit != 1<<10 {
+		t.Errorf("class bulk = %+v, want a conn limit of %d", cl, 1<<10)
+	}
+	if tr := ll.twoRates["bulk"]; tr.Peak != 1<<12 {
+		t.Errorf("two-rate limits of bulk = %+v, want a peak of %d", tr, 1<<12)
+	}
+	if labels := ll.Labels(); labels["region"] != "eu" {
+		t.Errorf("Labels() = %v, want region eu", labels)
+	}
+}
+
+func TestFromConfigInvalid(t *testing.T) {
+	// per-connection limit above the global one
+	cfg := Config{Write: WriteConfig{GlobalLimit: 1000, ConnLimit: 2000}}
+	if _, err := FromConfig(listen(t), cfg); err != nil {
+		t.Fatalf("FromConfig() = %v, want only strict mode to fail", err)
+	}
+	var errs ValidationErrors
+	if _, err := FromConfig(listen(t), cfg, WithStrict()); !errors.As(err, &errs) {
+		t.Errorf("strict FromConfig() = %v, want ValidationErrors", err)
+	}
+
+	cfg = Config{Classes: map[string]ClassConfig{
+		"bulk": {TwoRate: &TwoRate{Committed: 2000, Peak: 1000}},
+	}}
+	if _, err := FromConfig(listen(t), cfg); err == nil {
+		t.Error("FromConfig() with a peak below the committed rate succeeded")
+	}
+}
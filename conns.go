@@ -0,0 +1,47 @@
+package limlistener
+
+import (
+	"context"
+	"net"
+)
+
+// ConnInfo describes an open connection
+type ConnInfo struct {
+	ID         int
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	// class the connection was put in, empty when unclassified
+	Class string
+	// the connection token bucket, including its limit
+	Limiter LimiterState
+	// running counters
+	Stats ConnStats
+	// application context, see LimitedConn.SetContext
+	Context context.Context `json:"-"`
+}
+
+// Conns describes the open connections that filter lets through,
+// a nil filter returns them all
+func (ll *LimitedListener) Conns(filter func(*LimitedConn) bool) []ConnInfo {
+	var infos []ConnInfo
+	for _, conn := range ll.connections() {
+		if filter != nil && !filter(conn) {
+			continue
+		}
+		infos = append(infos, conn.Info())
+	}
+	return infos
+}
+
+// Info describes the connection
+func (lc LimitedConn) Info() ConnInfo {
+	return ConnInfo{
+		ID:         lc.id,
+		LocalAddr:  lc.conn.LocalAddr(),
+		RemoteAddr: lc.conn.RemoteAddr(),
+		Class:      lc.Class(),
+		Limiter:    lc.LimiterState(),
+		Stats:      lc.Stats(),
+		Context:    lc.Context(),
+	}
+}
@@ -0,0 +1,58 @@
+package limlistener
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// connContext is the application context of a connection
+type connContext struct {
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+func newConnContext(ctx context.Context) *connContext {
+	return &connContext{ctx: ctx}
+}
+
+// SetConnContext sets fn to derive the context of every accepted
+// connection from the background one before the admission policy is
+// consulted, eg. to attach the tenant it belongs to, like
+// http.Server.ConnContext
+func (ll *LimitedListener) SetConnContext(fn func(ctx context.Context, conn net.Conn) context.Context) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.connContext = fn
+}
+
+func (ll *LimitedListener) getConnContext() func(context.Context, net.Conn) context.Context {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	return ll.connContext
+}
+
+// SetContext associates ctx with the connection so request-scoped
+// values (eg. a tenant ID) travel along with it to hooks and policies
+// without a parallel lookup table. Only its values are used, its
+// cancellation doesn't affect the connection.
+func (lc LimitedConn) SetContext(ctx context.Context) {
+	lc.ctx.mu.Lock()
+	defer lc.ctx.mu.Unlock()
+
+	lc.ctx.ctx = ctx
+}
+
+// Context returns the context associated with the connection,
+// the background one unless set
+func (lc LimitedConn) Context() context.Context {
+	if lc.ctx == nil {
+		return context.Background()
+	}
+	lc.ctx.mu.Lock()
+	defer lc.ctx.mu.Unlock()
+
+	return lc.ctx.ctx
+}
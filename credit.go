@@ -0,0 +1,44 @@
+package limlistener
+
+import "sync/atomic"
+
+// burstCredit are one-time extra tokens of a connection
+type burstCredit struct {
+	remaining int64
+}
+
+// take takes up to n bytes of credit, returning how many it took
+func (bc *burstCredit) take(n int) int {
+	if bc == nil {
+		return 0
+	}
+	for {
+		remaining := atomic.LoadInt64(&bc.remaining)
+		if remaining <= 0 {
+			return 0
+		}
+		took := int64(n)
+		if took > remaining {
+			took = remaining
+		}
+		if atomic.CompareAndSwapInt64(&bc.remaining, remaining, remaining-took) {
+			return int(took)
+		}
+	}
+}
+
+// GrantBurst gives the connection a one-time allowance of n bytes on top of
+// its limit (eg. to flush a handshake or a critical message right away),
+// written without waiting on the per-connection limit until used up. The
+// global, class and per-IP limits still apply and the steady state limit
+// is left alone. Grants add up.
+func (lc LimitedConn) GrantBurst(n int) {
+	if n > 0 {
+		atomic.AddInt64(&lc.credit.remaining, int64(n))
+	}
+}
+
+// BurstCredit returns how much of the granted bursts is left
+func (lc LimitedConn) BurstCredit() int {
+	return int(atomic.LoadInt64(&lc.credit.remaining))
+}
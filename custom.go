@@ -0,0 +1,35 @@
+package limlistener
+
+import "context"
+
+// Limiter is what a connection needs of a limiter supplied by the
+// application, *rate.Limiter is one
+type Limiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// SetLimiter makes the writes of a connection also wait on l, for when its
+// budget is shared with traffic the listener doesn't see (eg. an
+// out-of-band UDP flow of the same session waiting on the same
+// *rate.Limiter). Accepted, dialed and wrapped connections and streams all
+// take one. It's meant to be set once, at accept or classification time
+// (eg. from SetConnContext or an admission policy), and waited on along with
+// the connection's own limiters for every chunk, so its burst has to fit
+// one (see SetMTU), a nil l removes it. TryWrite and the readiness helpers
+// don't know about it.
+func (lc LimitedConn) SetLimiter(l Limiter) {
+	lc.class.mu.Lock()
+	defer lc.class.mu.Unlock()
+
+	lc.class.custom = l
+}
+
+func (cc *connClass) getCustom() Limiter {
+	if cc == nil {
+		return nil
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	return cc.custom
+}
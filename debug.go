@@ -0,0 +1,109 @@
+package limlistener
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+)
+
+// DebugPath is where RegisterDebugHandler serves the listener state
+const DebugPath = "/debug/limlistener"
+
+// debugState is the dump served by the debug handler
+type debugState struct {
+	Labels     map[string]string
+	Goroutines int
+	Global     LimiterState
+	ReadGlobal LimiterState
+	Saturation float64
+	Shed       ShedStats
+	Accept     AcceptStats
+	DryRun     ThrottleStats
+	Shadow     ThrottleStats
+	IPRegistry debugRegistry
+	Protocols  map[string]ProtocolStats
+	Conns      []ConnInfo
+	Audit      []LimitChange
+}
+
+type debugRegistry struct {
+	RegistryStats
+	// only listed when asked for, there can be lots of them
+	Keys []debugRegistryEntry `json:",omitempty"`
+}
+
+type debugRegistryEntry struct {
+	Key string
+	// connections holding it
+	Refs    int
+	Limiter LimiterState
+}
+
+// DebugHandler serves a JSON dump of the listener internal state: its
+// labels, limiter states, registries, open connections, recent limit
+// changes and the number of goroutines. Per-IP limiters are only listed with ?entries=1.
+func (ll *LimitedListener) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := debugState{
+			Labels:     ll.Labels(),
+			Goroutines: runtime.NumGoroutine(),
+			Global:     ll.global.State(),
+			ReadGlobal: ll.readGlobal.State(),
+			Saturation: ll.Saturation(),
+			Shed:       ll.ShedStats(),
+			Accept:     ll.AcceptStats(),
+			DryRun:     ll.DryRunStats(),
+			Shadow:     ll.ShadowStats(),
+			IPRegistry: debugRegistry{RegistryStats: ll.IPRegistryStats()},
+			Protocols:  ll.ProtocolStats(),
+			Conns:      ll.Conns(nil),
+			Audit:      ll.AuditTrail(),
+		}
+		if r.URL.Query().Get("entries") == "1" {
+			ll.mu.Lock()
+			state.IPRegistry.Keys = ll.ipLimiters.entries()
+			ll.mu.Unlock()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(state); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// RegisterDebugHandler serves DebugHandler under /debug/limlistener of
+// mux, next to the net/http/pprof handlers when it's http.DefaultServeMux.
+// A nil mux registers it on http.DefaultServeMux. Nothing is registered
+// unless asked for.
+func (ll *LimitedListener) RegisterDebugHandler(mux *http.ServeMux) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	mux.Handle(DebugPath, ll.DebugHandler())
+}
+
+// entries lists the per-key limiters sorted by key
+func (r *registry) entries() []debugRegistryEntry {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]debugRegistryEntry, 0, len(r.limiters))
+	for key, kl := range r.limiters {
+		entries = append(entries, debugRegistryEntry{
+			Key:     key,
+			Refs:    kl.refs,
+			Limiter: kl.limiter.State(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
@@ -0,0 +1,404 @@
+package limlistener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// ErrDialRate is returned when a dial can't be fitted in the
+// destination's dial rate before the context deadline
+var ErrDialRate = errors.New("limlistener: dial rate exceeded")
+
+// LimitedDialer dials connections that get the same global and
+// per-connection throttling as the ones accepted by a LimitedListener,
+// plus optional per-destination bandwidth and dial rate limits
+type LimitedDialer struct {
+	mu        sync.Mutex
+	n_conns   int
+	dialer    *net.Dialer
+	global    *GlobalLimiter
+	connLimit int
+	mtu       int
+	// bandwidth limiters shared by all connections to the same destination
+	destLimiters   *registry
+	destMaxEntries int
+	destOnIdle     func(key string, stats KeyStats)
+	// new connections per second allowed towards each destination
+	dialRate    float64
+	dialBurst   int
+	dialBuckets map[string]*dialBucket
+	// dial buckets left by the last sweep
+	sweptBuckets int
+	// concurrency caps and crawl delays, nil if not polite
+	politeness *politeness
+}
+
+// NewWithDialer takes an existing net.Dialer and creates a new
+// LimitedDialer that throttles the connections it dials
+func NewWithDialer(d *net.Dialer) LimitedDialer {
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	return LimitedDialer{
+		dialer: d,
+		// no global limit until one is set
+		global:      newGlobalLimiter(rlimit.Inf, 0),
+		mtu:         defaultMTU,
+		dialBuckets: make(map[string]*dialBucket),
+	}
+}
+
+// SetLimits defines both new global and per-connection limits
+// for connections dialed from now on
+func (ld *LimitedDialer) SetLimits(globalLimit, connLimit int) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	ld.global.SetLimit(globalLimit)
+	ld.connLimit = connLimit
+}
+
+// SetMTU sets the size of the chunks writes of new connections are split
+// into, the burst of every limiter they share is raised to fit a whole chunk
+func (ld *LimitedDialer) SetMTU(mtu int) error {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	if mtu <= 0 {
+		return fmt.Errorf("limlistener: invalid MTU of %d bytes", mtu)
+	}
+	ld.mtu = mtu
+	ld.global.limiter.ensureBurst(mtu)
+	if ld.destLimiters != nil {
+		ld.destLimiters.ensureBurst(mtu)
+	}
+	return nil
+}
+
+// SetGlobalLimiter makes connections dialed from now on share global,
+// eg. the one of a LimitedListener so a proxy's total egress is capped
+// whichever side the bytes leave on
+func (ld *LimitedDialer) SetGlobalLimiter(global *GlobalLimiter) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	ld.global = global
+	global.limiter.ensureBurst(ld.mtu)
+}
+
+// GlobalLimiter returns the global budget new connections share
+func (ld *LimitedDialer) GlobalLimiter() *GlobalLimiter {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	return ld.global
+}
+
+// SetDestinationLimit caps the bandwidth shared by all connections dialed
+// towards the same destination. Destinations are keyed by the address given
+// to Dial before it's resolved, so whichever address family wins a dual-stack
+// (happy eyeballs) race the traffic is charged to the same limiter.
+func (ld *LimitedDialer) SetDestinationLimit(limit int) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	if ld.destLimiters == nil {
+		ld.destLimiters = newRegistry(limit, ld.mtu, ld.destMaxEntries)
+		ld.destLimiters.setOnIdle(ld.destOnIdle)
+		return
+	}
+	ld.destLimiters.setLimit(limit)
+}
+
+// SetDestinationLimitFor caps the bandwidth shared by all connections
+// dialed towards address (eg. "api.partner.com:443") on its own, instead
+// of the limit set by SetDestinationLimit. A negative limit puts it back on
+// that one.
+func (ld *LimitedDialer) SetDestinationLimitFor(address string, limit int) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	if ld.destLimiters == nil {
+		// no limit for the other destinations until one is set
+		ld.destLimiters = newRegistry(0, ld.mtu, ld.destMaxEntries)
+		ld.destLimiters.setOnIdle(ld.destOnIdle)
+	}
+	ld.destLimiters.setKeyLimit(destKey(address), limit)
+}
+
+// SetDestinationMaxEntries caps how many destinations have a limiter,
+// evicting the least recently used ones (see LimitedListener.SetIPMaxEntries)
+func (ld *LimitedDialer) SetDestinationMaxEntries(maxEntries int) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	ld.destMaxEntries = maxEntries
+	if ld.destLimiters != nil {
+		ld.destLimiters.setMaxEntries(maxEntries)
+	}
+}
+
+// OnKeyIdle calls fn with every destination whose limiter goes away
+// (see LimitedListener.OnKeyIdle)
+func (ld *LimitedDialer) OnKeyIdle(fn func(key string, stats KeyStats)) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	ld.destOnIdle = fn
+	if ld.destLimiters != nil {
+		ld.destLimiters.setOnIdle(fn)
+	}
+}
+
+// DestinationRegistryStats returns how many destinations have
+// a limiter and how many were evicted
+func (ld *LimitedDialer) DestinationRegistryStats() RegistryStats {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	return ld.destLimiters.stats()
+}
+
+// DestinationStats describes the limiter of every destination
+// that has one, by destination
+func (ld *LimitedDialer) DestinationStats() map[string]KeyStats {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	return ld.destLimiters.keyStats()
+}
+
+// SetDialRate caps how many new connections per second (with the given
+// burst) can be dialed towards each destination. A dial reserves its slot
+// once no matter how many parallel attempts it makes and failed dials
+// refund it. A rate of 0 disables it. Destinations whose bucket refilled
+// are forgotten, a new one starts out full all the same, so only the ones
+// dialed lately have a bucket.
+func (ld *LimitedDialer) SetDialRate(perSecond float64, burst int) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	ld.dialRate = perSecond
+	ld.dialBurst = burst
+	if perSecond <= 0 {
+		ld.dialBuckets = make(map[string]*dialBucket)
+		ld.sweptBuckets = 0
+		return
+	}
+	for _, b := range ld.dialBuckets {
+		b.set(perSecond, burst)
+	}
+}
+
+// Dial connects to the address on the named network
+func (ld *LimitedDialer) Dial(network, address string) (net.Conn, error) {
+	return ld.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address on the named network using
+// the provided context, the connection it returns is a LimitedConn
+func (ld *LimitedDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	key := destKey(address)
+
+	// reserve a dial slot towards the destination
+	ld.mu.Lock()
+	polite := ld.politeness
+	ld.mu.Unlock()
+	// wait for a turn towards the destination
+	slot, err := polite.acquire(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := ld.reserveDial(ctx, key)
+	if err != nil {
+		slot.release()
+		return nil, err
+	}
+	conn, err := ld.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		// no connection came out of it, give the slots back
+		if bucket != nil {
+			bucket.refund()
+		}
+		slot.release()
+		return nil, err
+	}
+
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	conn_id := ld.n_conns
+	ld.n_conns++
+	lconn := newLimitedConn(conn_id, conn, ld.global,
+		newLimiter(rlimit.Limit(ld.connLimit), ld.mtu), ld.mtu)
+	lconn.slot = slot
+	if ld.destLimiters != nil {
+		lconn.key = key
+		lconn.keyLimiter = ld.destLimiters.acquire(key)
+	}
+	return lconn, nil
+}
+
+// CloseConnection cleans up a dialed connection, should be used
+// instead of net.Conn.Close as it cleans up additional resources
+func (ld *LimitedDialer) CloseConnection(conn net.Conn) {
+	// type conversion
+	lconn := conn.(LimitedConn)
+	conn.Close()
+	if lconn.keyLimiter != nil {
+		ld.destLimiters.release(lconn.key, lconn.keyLimiter)
+	}
+	lconn.slot.release()
+}
+
+// reserveDial takes a dial slot for the destination, waiting for it
+// if needed. Returns a nil bucket when there's no dial rate.
+func (ld *LimitedDialer) reserveDial(ctx context.Context, key string) (*dialBucket, error) {
+	ld.mu.Lock()
+	if ld.dialRate <= 0 {
+		ld.mu.Unlock()
+		return nil, nil
+	}
+	b, ok := ld.dialBuckets[key]
+	if !ok {
+		ld.sweepDialBuckets()
+		b = newDialBucket(ld.dialRate, ld.dialBurst)
+		ld.dialBuckets[key] = b
+	}
+	ld.mu.Unlock()
+
+	delay := b.reserve()
+	if delay == 0 {
+		return b, nil
+	}
+	// would we be waiting past the deadline?
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		b.refund()
+		return nil, ErrDialRate
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return b, nil
+	case <-ctx.Done():
+		b.refund()
+		return nil, ctx.Err()
+	}
+}
+
+// minDialSweep is how many dial buckets there are before they're swept
+const minDialSweep = 64
+
+// sweepDialBuckets forgets the destinations whose bucket refilled, once
+// there are twice as many as the last sweep left so it's amortized over
+// the new ones, must be called with the lock held
+func (ld *LimitedDialer) sweepDialBuckets() {
+	if n := len(ld.dialBuckets); n < minDialSweep || n < 2*ld.sweptBuckets {
+		return
+	}
+	now := time.Now()
+	for key, b := range ld.dialBuckets {
+		// a dial still waiting on it took more than it had
+		if b.full(now) {
+			delete(ld.dialBuckets, key)
+		}
+	}
+	ld.sweptBuckets = len(ld.dialBuckets)
+}
+
+// dialBucket is a token bucket of dial slots, unlike rate.Limiter
+// reservations its slots can be given back after they were due
+type dialBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func newDialBucket(rate float64, burst int) *dialBucket {
+	return &dialBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// advance refills the bucket up to now, must be called with the lock held
+func (b *dialBucket) advance(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+}
+
+func (b *dialBucket) set(rate float64, burst int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	b.rate = rate
+	b.burst = burst
+}
+
+// full tells if the bucket refilled by now, as good as a new one
+func (b *dialBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(now)
+	return b.tokens >= float64(b.burst)
+}
+
+// reserve takes a slot and returns how long to wait before using it
+func (b *dialBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund gives a slot back
+func (b *dialBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	b.tokens++
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+}
+
+// destKey normalizes a dial address into a destination key
+func destKey(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return strings.ToLower(address)
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	// literal IPs get the same normalization as per-IP keys
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			host = ip4.String()
+		} else {
+			host = ip.String()
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
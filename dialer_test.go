@@ -0,0 +1,46 @@
+package limlistener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDialBucketsForgotten(t *testing.T) {
+	ld := NewWithDialer(nil)
+	ld.SetDialRate(100, 1)
+	ctx := context.Background()
+
+	for i := 0; i < 1000; i++ {
+		if _, err := ld.reserveDial(ctx, fmt.Sprintf("old-%d:80", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// refilled, as good as new
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 1000; i++ {
+		if _, err := ld.reserveDial(ctx, fmt.Sprintf("new-%d:80", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(ld.dialBuckets); n > 1000 {
+		t.Errorf("%d dial buckets kept for 1000 recent destinations", n)
+	}
+	if _, ok := ld.dialBuckets["new-999:80"]; !ok {
+		t.Error("bucket of a destination just dialed forgotten")
+	}
+
+	// the ones dialed lately are still held to their rate
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if _, err := ld.reserveDial(ctx, "new-999:80"); !errors.Is(err, ErrDialRate) {
+		t.Errorf("second dial within the rate = %v, want ErrDialRate", err)
+	}
+
+	ld.SetDialRate(0, 0)
+	if n := len(ld.dialBuckets); n != 0 {
+		t.Errorf("%d dial buckets kept without a dial rate", n)
+	}
+}
@@ -0,0 +1,76 @@
+package limlistener
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ThrottleStats count the writes a set of limits delayed, or would
+// have delayed when they aren't enforced
+type ThrottleStats struct {
+	// chunks that had to wait and the bytes within them
+	Chunks int64
+	Bytes  int64
+	// total time they waited
+	Delay time.Duration
+}
+
+// throttleCounter are ThrottleStats updated atomically
+type throttleCounter struct {
+	chunks int64
+	bytes  int64
+	delay  int64
+}
+
+func (tc *throttleCounter) add(n int, delay time.Duration) {
+	atomic.AddInt64(&tc.chunks, 1)
+	atomic.AddInt64(&tc.bytes, int64(n))
+	atomic.AddInt64(&tc.delay, int64(delay))
+}
+
+func (tc *throttleCounter) snapshot() ThrottleStats {
+	return ThrottleStats{
+		Chunks: atomic.LoadInt64(&tc.chunks),
+		Bytes:  atomic.LoadInt64(&tc.bytes),
+		Delay:  time.Duration(atomic.LoadInt64(&tc.delay)),
+	}
+}
+
+// SetDryRun switches every connection to observe-only mode: limits are
+// still accounted but writes are never delayed, what they would have been
+// delayed is reported by DryRunStats and each connection's Stats. Handy to
+// size limits out of real traffic before enforcing them.
+func (ll *LimitedListener) SetDryRun(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&ll.dryRun, v)
+}
+
+func (ll *LimitedListener) dryRunning() bool {
+	// dialed connections have no listener
+	return ll != nil && atomic.LoadInt32(&ll.dryRun) == 1
+}
+
+// DryRunStats returns how much writes of all connections would
+// have been delayed while in dry-run mode
+func (ll *LimitedListener) DryRunStats() ThrottleStats {
+	return ll.dryRunStats.snapshot()
+}
+
+// probeN accounts n bytes in every limiter the connection waits on
+// without waiting, returning how long the write would have waited
+func (lc LimitedConn) probeN(n int) time.Duration {
+	var delay time.Duration
+	if d := lc.global.window.probeN(n); d > delay {
+		delay = d
+	}
+	// limiters are waited on concurrently, the slowest decides
+	for _, l := range lc.limiters() {
+		if d := l.probeN(n); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
@@ -0,0 +1,72 @@
+package limlistener
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ErrNoSocket is returned when an option of the underlying socket can't be
+// set, either because the connection doesn't expose it (eg. a *tls.Conn)
+// or because the platform doesn't support it
+var ErrNoSocket = errors.New("limlistener: socket option not supported on this connection")
+
+// SetDSCP marks the packets of the connection with a DSCP code point
+// (eg. 46 for expedited forwarding) so network QoS downstream can honor
+// the same classification the limits do
+func (lc LimitedConn) SetDSCP(dscp int) error {
+	sc, ok := lc.conn.(syscall.Conn)
+	if !ok {
+		return ErrNoSocket
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	v6 := false
+	if addr, ok := lc.conn.LocalAddr().(*net.TCPAddr); ok {
+		v6 = addr.IP.To4() == nil
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		// the code point takes the upper 6 bits of the TOS/traffic class byte
+		serr = setTOS(fd, v6, dscp<<2)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// SetDSCP marks the connections of a class with a DSCP code point, an empty
+// class applies to the connections without one of their own class
+func (ll *LimitedListener) SetDSCP(class string, dscp int) {
+	ll.mu.Lock()
+	if ll.dscp == nil {
+		ll.dscp = make(map[string]int)
+	}
+	ll.dscp[class] = dscp
+	ll.mu.Unlock()
+
+	for _, conn := range ll.connections() {
+		ll.applyDSCP(*conn)
+	}
+}
+
+// applyDSCP marks a connection with the code point of its class,
+// marking is best effort so errors only go to the error handler
+func (ll *LimitedListener) applyDSCP(lc LimitedConn) {
+	name := lc.Class()
+
+	ll.mu.Lock()
+	dscp, ok := ll.dscp[name]
+	if !ok {
+		dscp, ok = ll.dscp[""]
+	}
+	ll.mu.Unlock()
+
+	if ok {
+		if err := lc.SetDSCP(dscp); err != nil {
+			ll.reportError(lc, err)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !darwin && !freebsd && !linux
+// +build !darwin,!freebsd,!linux
+
+package limlistener
+
+func setTOS(fd uintptr, v6 bool, tos int) error {
+	return ErrNoSocket
+}
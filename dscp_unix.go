@@ -0,0 +1,13 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package limlistener
+
+import "syscall"
+
+func setTOS(fd uintptr, v6 bool, tos int) error {
+	if v6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
@@ -0,0 +1,135 @@
+package limlistener
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// DumpState writes a human readable dump of the listener: its limits,
+// limiter states, classes, load shedding and every open connection, for
+// quick triage where the debug handler isn't reachable
+func (ll *LimitedListener) DumpState(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	globalLimit, connLimit := ll.Limits()
+	fmt.Fprintf(tw, "limlistener %s at %s\n", ll.Addr(), time.Now().Format(time.RFC3339))
+	if labels := ll.Labels(); len(labels) > 0 {
+		fmt.Fprintf(tw, "labels\t%s\n", formatLabels(labels))
+	}
+	fmt.Fprintf(tw, "limits\tglobal %s\tconn %s\n", formatLimit(globalLimit), formatLimit(connLimit))
+	fmt.Fprintf(tw, "global\t%s\n", formatState(ll.global.State()))
+	fmt.Fprintf(tw, "read global\t%s\n", formatState(ll.readGlobal.State()))
+	fmt.Fprintf(tw, "saturation\t%.2f\n", ll.Saturation())
+	shed := ll.ShedStats()
+	fmt.Fprintf(tw, "shed\trefused %d\tevicted %d\tdropped %d bytes\trejected %d writes\n",
+		shed.RefusedConns, shed.EvictedConns, shed.DroppedBytes, shed.RejectedWrites)
+	accept := ll.AcceptStats()
+	fmt.Fprintf(tw, "accept\taccepted %d\twait %s", accept.Accepted, accept.Wait.Mean())
+	if accept.BacklogKnown {
+		fmt.Fprintf(tw, "\tbacklog %d/%d", accept.Backlog, accept.BacklogMax)
+	}
+	fmt.Fprintln(tw)
+	ips := ll.IPRegistryStats()
+	fmt.Fprintf(tw, "ip registry\t%d entries\t%d evictions\n", ips.Entries, ips.Evictions)
+
+	ll.mu.Lock()
+	names := make([]string, 0, len(ll.classes))
+	for name := range ll.classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cl := ll.classes[name]
+		fmt.Fprintf(tw, "class %s\tconn %s\tlimit %s", name, formatLimit(cl.connLimit), formatLimit(cl.limit))
+		if cl.limiter != nil {
+			fmt.Fprintf(tw, "\ttokens %.0f", cl.limiter.State().Tokens)
+		}
+		fmt.Fprintln(tw)
+	}
+	ll.mu.Unlock()
+
+	conns := ll.Conns(nil)
+	fmt.Fprintf(tw, "\n%d connections\n", len(conns))
+	fmt.Fprintln(tw, "id\tremote\tclass\tlimit\ttokens\twritten\twait\tlast active")
+	now := time.Now()
+	for _, info := range conns {
+		class := info.Class
+		if class == "" {
+			class = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%.0f\t%d\t%s\t%s ago\n",
+			info.ID, info.RemoteAddr, class, formatRate(info.Limiter.Limit), info.Limiter.Tokens,
+			info.Stats.WireBytes, info.Stats.Wait.Mean(), now.Sub(info.Stats.LastActive).Round(time.Millisecond))
+	}
+	return tw.Flush()
+}
+
+// DumpOnSignal writes DumpState to w every time the process gets one of
+// sigs (SIGUSR1 on Unix when none are given, nothing elsewhere) until
+// stop is called
+func (ll *LimitedListener) DumpOnSignal(w io.Writer, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = defaultDumpSignals
+	}
+	if len(sigs) == 0 {
+		return func() {}
+	}
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+	go func() {
+		for {
+			select {
+			case <-ch:
+				if err := ll.DumpState(w); err != nil {
+					ll.reportError(nil, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+func formatLimit(limit int) string {
+	if limit <= 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%d B/s", limit)
+}
+
+func formatRate(limit float64) string {
+	if math.IsInf(limit, 1) || limit == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%.0f B/s", limit)
+}
+
+func formatState(s LimiterState) string {
+	return fmt.Sprintf("limit %s\tburst %d\ttokens %.0f", formatRate(s.Limit), s.Burst, s.Tokens)
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := ""
+	for i, k := range keys {
+		if i > 0 {
+			s += " "
+		}
+		s += k + "=" + labels[k]
+	}
+	return s
+}
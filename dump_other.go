@@ -0,0 +1,8 @@
+//go:build !darwin && !freebsd && !linux
+// +build !darwin,!freebsd,!linux
+
+package limlistener
+
+import "os"
+
+var defaultDumpSignals []os.Signal
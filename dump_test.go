@@ -0,0 +1,35 @@
+package limlistener
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+func TestDumpState(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetLimits(1<<20, 1<<16)
+	ll.SetLabels(map[string]string{"region": "eu", "az": "b"})
+	ll.SetClass("bulk", 1024, 0)
+	conn := acceptDrained(t, ll)
+	conn.Write(make([]byte, 100))
+
+	var buf bytes.Buffer
+	if err := ll.DumpState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dump := buf.String()
+	for _, want := range []string{
+		`labels +az=b region=eu\n`,
+		`limits +global 1048576 B/s +conn 65536 B/s\n`,
+		`class bulk +conn 1024 B/s +limit none\n`,
+		`\n1 connections\n`,
+		// the connection, by id
+		fmt.Sprintf(`\n%d +127\.0\.0\.1:\d+ +- +65536 B/s +\d+ +100 `, conn.id),
+	} {
+		if !regexp.MustCompile(want).MatchString(dump) {
+			t.Errorf("dump doesn't match %q:\n%s", want, dump)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package limlistener
+
+import (
+	"os"
+	"syscall"
+)
+
+var defaultDumpSignals = []os.Signal{syscall.SIGUSR1}
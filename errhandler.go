@@ -0,0 +1,44 @@
+package limlistener
+
+import "net"
+
+// ErrorHandler is told about failures happening in background helpers
+// (async write queues, Serve, Relay...) that have no caller to return them
+// to, conn is the connection involved
+type ErrorHandler func(conn net.Conn, err error)
+
+// SetErrorHandler routes failures of background helpers to fn
+func (ll *LimitedListener) SetErrorHandler(fn ErrorHandler) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.errorHandler = fn
+}
+
+// reportError hands err to the error handler, if there's one
+func (ll *LimitedListener) reportError(conn net.Conn, err error) bool {
+	// dialed connections have no listener
+	if ll == nil || err == nil {
+		return false
+	}
+	ll.mu.Lock()
+	fn := ll.errorHandler
+	ll.mu.Unlock()
+
+	if fn == nil {
+		return false
+	}
+	fn(conn, err)
+	return true
+}
+
+// reportConnError reports err through the listener of the first
+// of conns that's a LimitedConn accepted by one
+func reportConnError(err error, conns ...net.Conn) {
+	for _, conn := range conns {
+		if lc, ok := conn.(LimitedConn); ok && lc.listener != nil {
+			lc.listener.reportError(conn, err)
+			return
+		}
+	}
+}
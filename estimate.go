@@ -0,0 +1,56 @@
+package limlistener
+
+import "time"
+
+// EstimateWait tells how long until the limiters would let the connection
+// write n bytes, taking nothing from them, so retry and backoff logic can
+// come back once there's room instead of hot-looping against a saturated
+// budget. Other writers may take that room first, it's only an estimate.
+func (lc LimitedConn) EstimateWait(n int) time.Duration {
+	var wait time.Duration
+	if lc.global.window.enabled() {
+		wait = lc.global.window.estimate(n)
+	}
+	for _, l := range lc.bucketLimiters() {
+		if d := l.estimate(n); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// EstimateWait tells how long until the global budget would let n bytes
+// through, see LimitedConn.EstimateWait
+func (g *GlobalLimiter) EstimateWait(n int) time.Duration {
+	if g.window.enabled() {
+		return g.window.estimate(n)
+	}
+	return g.limiter.estimate(n)
+}
+
+// estimate is delay for limiters that may have no limit
+func (l *limiter) estimate(n int) time.Duration {
+	if l.unlimited() {
+		return 0
+	}
+	return l.delay(n)
+}
+
+// estimate tells how long until n bytes fit, over
+// however many windows they take
+func (w *window) estimate(n int) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.clock.Now()
+	w.roll(now)
+	if w.used+n <= w.limit {
+		return 0
+	}
+	// the next window starts afresh, any further ones are needed whole
+	wait := w.start.Add(w.interval).Sub(now)
+	if extra := n - w.limit; extra > 0 {
+		wait += time.Duration((extra+w.limit-1)/w.limit) * w.interval
+	}
+	return wait
+}
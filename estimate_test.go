@@ -0,0 +1,38 @@
+package limlistener
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimateWaitMatchesWrite(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetLimits(10000, 10000)
+	conn := acceptDrained(t, ll)
+
+	b := make([]byte, 1000)
+	for i := 0; i < 3; i++ {
+		estimate := conn.EstimateWait(len(b))
+		start := time.Now()
+		if _, err := conn.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		waited := time.Since(start)
+		// nothing's left in the buckets, 1000 bytes at 10000 bytes/sec
+		if estimate < 50*time.Millisecond {
+			t.Errorf("write %d: estimate of %v, the buckets are empty", i, estimate)
+		}
+		if d := waited - estimate; d < -30*time.Millisecond || d > 30*time.Millisecond {
+			t.Errorf("write %d: estimated %v, waited %v", i, estimate, waited)
+		}
+	}
+}
+
+func TestEstimateWaitUnlimited(t *testing.T) {
+	ll := newTestListener(t)
+	conn := acceptDrained(t, ll)
+
+	if estimate := conn.EstimateWait(1 << 20); estimate != 0 {
+		t.Errorf("estimate of %v without limits", estimate)
+	}
+}
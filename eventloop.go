@@ -0,0 +1,102 @@
+package limlistener
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Writable tells how many bytes the limiters would let the connection
+// write right now and, when that's less than a chunk, how long until
+// TryWrite can send a whole one, so event loops can fold the shaping budget into
+// their own scheduling (see TryWrite). Nothing is taken from the limiters.
+func (lc LimitedConn) Writable() (n int, wait time.Duration) {
+	available := math.Inf(1)
+	for _, l := range lc.bucketLimiters() {
+		if tokens := l.available(); tokens < available {
+			available = tokens
+		}
+	}
+	if lc.global.window.enabled() {
+		if left := float64(lc.global.window.available()); left < available {
+			available = left
+		}
+	}
+	n = math.MaxInt32
+	if available < float64(n) {
+		n = int(math.Max(available, 0))
+	}
+	chunk := lc.chunkSize()
+	if n >= chunk {
+		return n, 0
+	}
+	wait = lc.global.delay(chunk)
+	for _, l := range lc.bucketLimiters() {
+		if math.IsInf(l.available(), 1) {
+			continue
+		}
+		if d := l.delay(chunk); d > wait {
+			wait = d
+		}
+	}
+	// TryWrite takes the chunk that much ahead
+	wait -= tryWriteSlack
+	if wait < 0 {
+		wait = 0
+	}
+	return n, wait
+}
+
+// NotifyWritable calls fn, once, with how many bytes the connection may
+// write as soon as the limiters allow at least a chunk out, readiness
+// notification for event loops that don't run a goroutine per connection.
+// It's armed on a timer, fn runs on its own goroutine and should hand the
+// connection back to the event loop. The returned function disarms it.
+func (lc LimitedConn) NotifyWritable(fn func(n int)) (stop func()) {
+	wn := &writableNotifier{
+		lc: lc,
+		fn: fn,
+	}
+	wn.mu.Lock()
+	defer wn.mu.Unlock()
+
+	wn.timer = time.AfterFunc(0, wn.check)
+	return wn.stop
+}
+
+// writableNotifier checks the connection until it's writable
+type writableNotifier struct {
+	lc      LimitedConn
+	fn      func(n int)
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+func (wn *writableNotifier) check() {
+	n, wait := wn.lc.Writable()
+	wn.mu.Lock()
+	if wn.stopped {
+		wn.mu.Unlock()
+		return
+	}
+	if wait > 0 {
+		// not yet, try again when it should be
+		wn.timer = time.AfterFunc(wait, wn.check)
+		wn.mu.Unlock()
+		return
+	}
+	wn.stopped = true
+	wn.mu.Unlock()
+
+	defer contain("writable notification", nil, wn.lc)
+	wn.fn(n)
+}
+
+func (wn *writableNotifier) stop() {
+	wn.mu.Lock()
+	defer wn.mu.Unlock()
+
+	wn.stopped = true
+	wn.timer.Stop()
+}
@@ -0,0 +1,50 @@
+package limlistener
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWritable(t *testing.T) {
+	ll := newTestListener(t)
+	conn := acceptDrained(t, ll)
+	if n, wait := conn.Writable(); n < conn.chunkSize() || wait != 0 {
+		t.Errorf("unlimited Writable() = %d, %v, want at least a chunk right away", n, wait)
+	}
+
+	conn.SetLimit(10000)
+	conn.connLimiter.take(conn.chunkSize())
+	// the bucket is empty, a chunk takes about 100ms at 10000 bytes/sec
+	n, wait := conn.Writable()
+	if n >= conn.chunkSize() || wait < 50*time.Millisecond || wait > 200*time.Millisecond {
+		t.Errorf("Writable() = %d, %v, want less than a chunk and about 100ms", n, wait)
+	}
+}
+
+func TestNotifyWritable(t *testing.T) {
+	ll := newTestListener(t)
+	conn := acceptDrained(t, ll)
+	conn.SetLimit(10000)
+	conn.connLimiter.take(conn.chunkSize())
+
+	notified := make(chan int, 1)
+	start := time.Now()
+	conn.NotifyWritable(func(n int) { notified <- n })
+	select {
+	case n := <-notified:
+		if n < conn.chunkSize()-100 {
+			t.Errorf("notified with %d bytes writable, want about a chunk", n)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("notified after %v, before a chunk was writable", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("never notified")
+	}
+
+	// a disarmed notification doesn't fire
+	conn.connLimiter.take(conn.chunkSize())
+	stop := conn.NotifyWritable(func(int) { t.Error("disarmed notification fired") })
+	stop()
+	time.Sleep(200 * time.Millisecond)
+}
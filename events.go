@@ -0,0 +1,90 @@
+package limlistener
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// eventFamily is the family connection event logs are published under
+const eventFamily = "limlistener"
+
+// EventLog is what the listener needs of golang.org/x/net/trace.EventLog
+// to publish connection events to it, it can be used without depending on
+// x/net
+type EventLog interface {
+	Printf(format string, a ...interface{})
+	Errorf(format string, a ...interface{})
+	Finish()
+}
+
+// SetEventLogs publishes an event log for each connection accepted from
+// now on, created by newLog (eg. golang.org/x/net/trace.NewEventLog so it
+// shows up in /debug/events), with its limit changes and the waits on the
+// limiters that took notableWait or longer. A nil newLog stops it.
+func (ll *LimitedListener) SetEventLogs(newLog func(family, title string) EventLog, notableWait time.Duration) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.newEventLog = newLog
+	ll.notableWait = notableWait
+}
+
+// connEvents publishes the events of a connection
+type connEvents struct {
+	log         EventLog
+	notableWait time.Duration
+	// last limit logged
+	limit    int64
+	finished int32
+}
+
+// newConnEvents starts the event log of a connection,
+// must be called with the lock held
+func (ll *LimitedListener) newConnEvents(conn net.Conn, limit int) *connEvents {
+	if ll.newEventLog == nil {
+		return nil
+	}
+	ce := &connEvents{
+		log:         ll.newEventLog(eventFamily, addrString(conn.RemoteAddr())),
+		notableWait: ll.notableWait,
+		limit:       int64(limit),
+	}
+	ce.log.Printf("accepted, limit %d bytes/sec", limit)
+	return ce
+}
+
+// limitChanged logs the connection limit if it changed
+func (ce *connEvents) limitChanged(limit int) {
+	if ce == nil {
+		return
+	}
+	if old := atomic.SwapInt64(&ce.limit, int64(limit)); old != int64(limit) {
+		ce.log.Printf("limit %d -> %d bytes/sec", old, limit)
+	}
+}
+
+// waited logs a wait for n bytes if it was notable
+func (ce *connEvents) waited(n int, d time.Duration) {
+	if ce == nil || d < ce.notableWait {
+		return
+	}
+	ce.log.Printf("waited %v for %d bytes", d, n)
+}
+
+// failed logs a wait that gave up
+func (ce *connEvents) failed(n int, err error) {
+	if ce == nil {
+		return
+	}
+	ce.log.Errorf("wait for %d bytes: %v", n, err)
+}
+
+// finish closes the event log, only once
+func (ce *connEvents) finish() {
+	if ce == nil || !atomic.CompareAndSwapInt32(&ce.finished, 0, 1) {
+		return
+	}
+	ce.log.Printf("closed")
+	ce.log.Finish()
+}
@@ -0,0 +1,76 @@
+package limlistener
+
+import (
+	"context"
+	"sync/atomic"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// SetFastStart makes the first bytes written on each connection accepted
+// from now on go out at limit instead of the per-connection limit (0 for no
+// per-connection limit at all), eg. the first 5 MB at full speed and then 1
+// MB/s, for quick initial buffering followed by steady pacing. The global,
+// class and per-IP limits still apply. A bytes of 0 turns it off.
+func (ll *LimitedListener) SetFastStart(bytes, limit int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.fastStartBytes = bytes
+	ll.fastStartLimit = limit
+}
+
+// fastStart are the bytes of a connection left to go out
+// at the fast start limit
+type fastStart struct {
+	remaining int64
+	// nil for no per-connection limit
+	limiter *limiter
+}
+
+func newFastStart(bytes, limit, mtu int) *fastStart {
+	if bytes <= 0 {
+		return nil
+	}
+	fs := &fastStart{remaining: int64(bytes)}
+	if limit > 0 {
+		fs.limiter = newLimiter(rlimit.Limit(limit), mtu)
+	}
+	return fs
+}
+
+// take takes up to n of the fast start bytes, returning how many it took
+func (fs *fastStart) take(n int) int {
+	if fs == nil || n <= 0 {
+		return 0
+	}
+	for {
+		remaining := atomic.LoadInt64(&fs.remaining)
+		if remaining <= 0 {
+			return 0
+		}
+		took := int64(n)
+		if took > remaining {
+			took = remaining
+		}
+		if atomic.CompareAndSwapInt64(&fs.remaining, remaining, remaining-took) {
+			return int(took)
+		}
+	}
+}
+
+// refund gives back n fast start bytes that weren't written
+func (fs *fastStart) refund(n int) {
+	if fs != nil && n > 0 {
+		atomic.AddInt64(&fs.remaining, int64(n))
+	}
+}
+
+// waitN waits for n fast start bytes
+func (fs *fastStart) waitN(ctx context.Context, n int) error {
+	if fs.limiter == nil {
+		return nil
+	}
+	fs.limiter.ensureBurst(n)
+	return fs.limiter.WaitN(ctx, n)
+}
@@ -0,0 +1,75 @@
+package limlistener
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// FDPressureAction decides how the listener responds to running
+// out of file descriptors
+type FDPressureAction int
+
+const (
+	// FDPause only backs off accepting until descriptors free up
+	FDPause FDPressureAction = iota
+	// FDEvictIdle also closes the connection idle for the longest,
+	// as long as it's been idle for a while
+	FDEvictIdle
+)
+
+// FDPressureEvent reports Accept running out of file descriptors
+type FDPressureEvent struct {
+	Err error
+	// connection closed to make room, nil if none was
+	Evicted *ConnInfo
+}
+
+// SetFDPressure configures the response to Accept failing with EMFILE or
+// ENFILE: pausing (backing off, see SetAcceptBackoff) or evicting the
+// connection idle for the longest provided it's been idle for at least
+// minIdle, keeping the service alive under descriptor exhaustion. fn, if
+// not nil, is called on each occurrence.
+func (ll *LimitedListener) SetFDPressure(action FDPressureAction, minIdle time.Duration, fn func(FDPressureEvent)) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.fdAction = action
+	ll.fdMinIdle = minIdle
+	ll.onFDPressure = fn
+}
+
+// fdExhausted tells if an accept error is about running out of descriptors
+func fdExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// relieveFDPressure applies the configured response to
+// running out of descriptors
+func (ll *LimitedListener) relieveFDPressure(err error) {
+	ll.mu.Lock()
+	action, minIdle, hook := ll.fdAction, ll.fdMinIdle, ll.onFDPressure
+	ll.mu.Unlock()
+
+	event := FDPressureEvent{Err: err}
+	if action == FDEvictIdle {
+		var idlest *LimitedConn
+		var lastActive time.Time
+		for _, conn := range ll.connections() {
+			stats := conn.Stats()
+			if idlest == nil || stats.LastActive.Before(lastActive) {
+				idlest, lastActive = conn, stats.LastActive
+			}
+		}
+		if idlest != nil && time.Since(lastActive) >= minIdle {
+			info := idlest.Info()
+			ll.evict(*idlest)
+			atomic.AddInt64(&ll.shed.evictedConns, 1)
+			event.Evicted = &info
+		}
+	}
+	if hook != nil {
+		hook(event)
+	}
+}
@@ -0,0 +1,63 @@
+package limlistener
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFDPressureEvictsIdlest(t *testing.T) {
+	l := &flakyListener{Listener: listen(t)}
+	ll := NewWithListener(l)
+	defer ll.Close()
+	ll.SetAcceptBackoff(time.Millisecond, time.Millisecond)
+	var events []FDPressureEvent
+	ll.SetFDPressure(FDEvictIdle, 50*time.Millisecond, func(e FDPressureEvent) {
+		events = append(events, e)
+	})
+	accept := func() (LimitedConn, net.Conn) {
+		t.Helper()
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		conn, err := ll.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn.(LimitedConn), client
+	}
+
+	idle, idleClient := accept()
+	time.Sleep(100 * time.Millisecond)
+	busy, _ := accept()
+	busy.Write([]byte("busy"))
+
+	// running out of descriptors closes the one idle for the longest
+	l.failures = 1
+	accept()
+	if len(events) != 1 || events[0].Evicted == nil || events[0].Evicted.ID != idle.Info().ID {
+		t.Fatalf("events = %+v, want the idle connection evicted", events)
+	}
+	if !errors.Is(events[0].Err, syscall.EMFILE) {
+		t.Errorf("event error %v, want EMFILE", events[0].Err)
+	}
+	idleClient.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := idleClient.Read(make([]byte, 1)); err == nil {
+		t.Error("evicted connection still open")
+	}
+	if n := len(ll.Conns(nil)); n != 2 {
+		t.Errorf("listener tracks %d connections, want 2", n)
+	}
+
+	// the others haven't been idle for long enough
+	l.failures = 1
+	accept()
+	if len(events) != 2 || events[1].Evicted != nil {
+		t.Errorf("events = %+v, want nothing evicted", events[1:])
+	}
+}
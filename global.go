@@ -0,0 +1,89 @@
+package limlistener
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// GlobalLimiter is the bandwidth budget shared by a set of connections,
+// the same one can be handed to several listeners and dialers so their
+// combined egress is capped regardless of the direction bytes leave on
+type GlobalLimiter struct {
+	mu sync.Mutex
+	// configured limit in bytes/sec
+	limit   int
+	limiter *limiter
+	window  *window
+	// connections currently in a write
+	writers int32
+}
+
+// NewGlobalLimiter creates a global budget of limit bytes/sec
+func NewGlobalLimiter(limit int) *GlobalLimiter {
+	return newGlobalLimiter(rlimit.Limit(limit), limit)
+}
+
+func newGlobalLimiter(rate rlimit.Limit, limit int) *GlobalLimiter {
+	return &GlobalLimiter{
+		limit:   limit,
+		limiter: newLimiter(rate, defaultMTU),
+		window:  newWindow(systemClock{}),
+	}
+}
+
+// SetLimit sets a new global limit in bytes/sec
+func (g *GlobalLimiter) SetLimit(limit int) {
+	g.mu.Lock()
+	g.limit = limit
+	g.mu.Unlock()
+
+	g.limiter.SetLimit(rlimit.Limit(limit))
+}
+
+// Limit returns the configured global limit
+func (g *GlobalLimiter) Limit() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.limit
+}
+
+// State returns the current state of the global token bucket
+func (g *GlobalLimiter) State() LimiterState {
+	return g.limiter.State()
+}
+
+// SetWindow switches the budget to interval accounting: no more than limit
+// bytes are sent in each consecutive interval (eg. 100 MB every 10s) and
+// the rate set by SetLimit stops applying. A limit of 0 goes back to the rate.
+func (g *GlobalLimiter) SetWindow(limit int, interval time.Duration) {
+	g.window.set(limit, interval)
+}
+
+// waitN blocks until the budget allows n more bytes out
+func (g *GlobalLimiter) waitN(ctx context.Context, n int) error {
+	if g.window.enabled() {
+		return g.window.waitN(ctx, n)
+	}
+	return g.limiter.WaitN(ctx, n)
+}
+
+// delay tells how long it'd take for n bytes to be allowed out
+func (g *GlobalLimiter) delay(n int) time.Duration {
+	if g.window.enabled() {
+		return g.window.delay(n)
+	}
+	return g.limiter.delay(n)
+}
+
+// take charges n bytes to the budget without waiting
+func (g *GlobalLimiter) take(n int) {
+	if g.window.enabled() {
+		g.window.take(n)
+		return
+	}
+	g.limiter.take(n)
+}
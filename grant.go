@@ -0,0 +1,103 @@
+package limlistener
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// SetMaxGrant lets writes spanning several chunks (eg. io.CopyBuffer with
+// a 1 MB buffer) reserve up to max bytes worth of whole chunks from the
+// limiters at once instead of making a reservation per chunk, each grant
+// still being written a chunk at a time. The bursts of the limiters are
+// raised to fit a grant, so up to max bytes can leave at once after an
+// idle spell. Grants never exceed the smallest burst of the limiters a
+// connection waits on, 0 goes back to a reservation per chunk.
+func (ll *LimitedListener) SetMaxGrant(max int) {
+	ll.mu.Lock()
+	if max > 0 {
+		ll.global.limiter.ensureBurst(max)
+		for _, cl := range ll.classes {
+			if cl.limiter != nil {
+				cl.limiter.ensureBurst(max)
+			}
+		}
+		if ll.ipLimiters != nil {
+			ll.ipLimiters.ensureBurst(max)
+		}
+	}
+	atomic.StoreInt32(&ll.maxGrant, int32(max))
+	ll.mu.Unlock()
+
+	if max > 0 {
+		for _, conn := range ll.connections() {
+			conn.connLimiter.ensureBurst(max)
+		}
+	}
+}
+
+// getMaxGrant returns the largest grant writes can reserve, 0 if none
+func (ll *LimitedListener) getMaxGrant() int {
+	// dialed connections have no listener
+	if ll == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&ll.maxGrant))
+}
+
+// grantChunks returns how many chunks of size bytes the next reservation
+// of a write with left bytes to go takes, 1 unless grants allow more
+func (lc LimitedConn) grantChunks(left, size int) int {
+	max := lc.listener.getMaxGrant()
+	if max <= 0 || left <= size || lc.chunking != ChunkFixed || lc.micro != nil {
+		return 1
+	}
+	// application limiters might not fit a grant
+	if lc.class.getCustom() != nil {
+		return 1
+	}
+	// every token bucket waited on has to fit the whole grant
+	if burst := lc.connLimiter.Burst(); burst < max {
+		max = burst
+	}
+	if !lc.global.window.enabled() {
+		if burst := lc.global.limiter.Burst(); burst < max {
+			max = burst
+		}
+	}
+	if _, limiter := lc.class.get(); limiter != nil {
+		if burst := limiter.Burst(); burst < max {
+			max = burst
+		}
+	}
+	if lc.keyLimiter != nil {
+		if burst := lc.keyLimiter.Burst(); burst < max {
+			max = burst
+		}
+	}
+	chunks := max / (size + lc.recordOverhead)
+	if need := (left + size - 1) / size; chunks > need {
+		chunks = need
+	}
+	if chunks < 1 {
+		return 1
+	}
+	return chunks
+}
+
+// sendChunks writes a granted s a chunk of size bytes at a time
+func (lc LimitedConn) sendChunks(ctx context.Context, s []byte, size int) (int, error) {
+	n := 0
+	for len(s) > 0 {
+		p := s
+		if len(p) > size {
+			p = s[:size]
+		}
+		w, err := lc.send(ctx, p, lc.recordOverhead)
+		n += w
+		if err != nil {
+			return n, err
+		}
+		s = s[len(p):]
+	}
+	return n, nil
+}
@@ -0,0 +1,105 @@
+package limlistener
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+// ErrHandoffQueued is returned when detaching a connection in async write
+// mode, its queued writes are paced by the listener it's leaving
+var ErrHandoffQueued = errors.New("limlistener: connections in async write mode can't be handed off")
+
+// Handoff is a connection detached from its listener on its way to another
+// one, see Detach
+type Handoff struct {
+	lc LimitedConn
+	// set once attached
+	attached int32
+}
+
+// Detach takes a connection away from the listener so it can be attached
+// to another one (eg. moving it from an "unauthenticated" listener's budget
+// to its tenant's once it's authenticated). The shared limiters it held are
+// given back and what it did so far stays in the listener's totals, it's
+// reported as CloseDetached. The connection itself stays open, the value
+// handed in shouldn't be used anymore.
+func (ll *LimitedListener) Detach(conn net.Conn) (*Handoff, error) {
+	lconn := conn.(LimitedConn)
+	if lconn.listener != ll {
+		return nil, errors.New("limlistener: connection wasn't accepted by this listener")
+	}
+	if lconn.queue != nil {
+		return nil, ErrHandoffQueued
+	}
+	if !ll.forget(lconn, CloseDetached) {
+		return nil, errors.New("limlistener: connection was closed or detached already")
+	}
+	return &Handoff{lc: lconn}, nil
+}
+
+// Attach adopts a detached connection, which gets a new id and the limits,
+// classes and shared limiters of this listener like an accepted one does.
+// Its own counters carry on (see LimitedConn.Stats) while the listener's
+// views (Snapshot, Rollup) only count what it does from now on. Attaching
+// to a closed listener fails with net.ErrClosed and the handoff can still
+// be attached to another one. A TLS connection yet to complete its
+// handshake does it on its first read or write (see SetTLSClassifier).
+func (ll *LimitedListener) Attach(h *Handoff) (LimitedConn, error) {
+	if ll.isShutdown() {
+		return LimitedConn{}, net.ErrClosed
+	}
+	if !atomic.CompareAndSwapInt32(&h.attached, 0, 1) {
+		return LimitedConn{}, errors.New("limlistener: handoff already attached")
+	}
+	old := h.lc
+	if old.closing.is() {
+		return LimitedConn{}, net.ErrClosed
+	}
+	lconn := ll.newConn(old.conn)
+	// what belongs to the connection rather than the listener
+	lconn.stats = old.stats
+	lconn.ctx = old.ctx
+	lconn.closing = old.closing
+	lconn.credit = old.credit
+	lconn.peek = old.peek
+	lconn.protocol = old.protocol
+	lconn.accepted = old.accepted
+	baseline := old.Stats()
+	lconn.baseline = &baseline
+	ll.deferTLS(&lconn)
+	ll.startQueue(&lconn)
+
+	ll.mu.Lock()
+	if ll.shutdown {
+		ll.mu.Unlock()
+		ll.release(lconn)
+		if lconn.queue != nil {
+			lconn.queue.close()
+		}
+		// closed while attaching, it can go elsewhere
+		atomic.StoreInt32(&h.attached, 0)
+		return LimitedConn{}, net.ErrClosed
+	}
+	ll.conns[lconn.id] = &lconn
+	ll.mu.Unlock()
+	ll.applyDSCP(lconn)
+	lconn.tuneBuffers()
+	ll.applyRules()
+	return lconn, nil
+}
+
+// listenerStats returns the counters of the connection since it joined
+// its listener, for the listener's totals
+func (lc LimitedConn) listenerStats() ConnStats {
+	stats := lc.Stats()
+	if b := lc.baseline; b != nil {
+		stats.WireBytes -= b.WireBytes
+		stats.LogicalBytes -= b.LogicalBytes
+		stats.DroppedBytes -= b.DroppedBytes
+		stats.RejectedWrites -= b.RejectedWrites
+		stats.Wait.Count -= b.Wait.Count
+		stats.Wait.Sum -= b.Wait.Sum
+	}
+	return stats
+}
@@ -0,0 +1,83 @@
+package limlistener
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAttachClosedListener(t *testing.T) {
+	from := newTestListener(t)
+	conn, client := acceptPair(t, from)
+	h, err := from.Detach(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := newTestListener(t)
+	closed.Close()
+	if _, err := closed.Attach(h); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Attach() to a closed listener = %v, want net.ErrClosed", err)
+	}
+
+	// the handoff wasn't used up, nor its connection closed
+	to := newTestListener(t)
+	attached, err := to.Attach(h)
+	if err != nil {
+		t.Fatalf("Attach() after a failed one = %v", err)
+	}
+	defer to.CloseConnection(attached)
+	go attached.Write([]byte("hello"))
+	b := make([]byte, 5)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, b); err != nil || string(b) != "hello" {
+		t.Errorf("read %q, %v through the attached connection", b, err)
+	}
+	if _, err := to.Attach(h); err == nil {
+		t.Error("handoff attached twice")
+	}
+}
+
+func TestAttachDefersHandshake(t *testing.T) {
+	serverConfig, _ := testTLSConfig(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no classifier, the handshake is left to the next listener
+	from := NewWithTLSListener(l, serverConfig)
+	defer from.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := from.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := from.Detach(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := newTestListener(t)
+	to.SetTLSClassifier(ClassifyBySNI(nil, "default"))
+	// the client hasn't sent anything yet
+	attached, err := to.Attach(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	if _, err := attached.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read a connection failing its handshake")
+	}
+	to.CloseConnection(attached)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.Copy(ioutil.Discard, client); err != nil {
+		t.Errorf("client reading the closed connection: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package limlistener
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// histogramBounds are the upper bounds of the latency histogram
+// buckets, a last bucket counts everything above them
+var histogramBounds = [...]time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram is a latency distribution
+type Histogram struct {
+	// upper bound of each bucket, the last one of Counts has none
+	Bounds []time.Duration
+	// observations in each bucket, one more than Bounds
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+}
+
+// Mean returns the average observation
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// histogram is a Histogram updated atomically
+type histogram struct {
+	counts [len(histogramBounds) + 1]int64
+	count  int64
+	sum    int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(histogramBounds) && d > histogramBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	counts := make([]int64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	return Histogram{
+		Bounds: histogramBounds[:],
+		Counts: counts,
+		Count:  atomic.LoadInt64(&h.count),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+}
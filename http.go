@@ -0,0 +1,69 @@
+package limlistener
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// LimitRequests throttles the response body of every request served by
+// next to limit bytes/sec. Served over an HTTP/2 connection accepted by a
+// LimitedListener each stream gets its own limit while the connection limit
+// caps their aggregate, so one big download can't starve the other streams.
+func LimitRequests(next http.Handler, limit int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&limitedResponseWriter{
+			ResponseWriter: w,
+			r:              r,
+			limiter:        newLimiter(rlimit.Limit(limit), defaultMTU),
+		}, r)
+	})
+}
+
+// limitedResponseWriter waits on its request limiter before writing
+// each MTU worth of the response body, flushing only when the handler does
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	limiter *limiter
+}
+
+func (w *limitedResponseWriter) Write(b []byte) (int, error) {
+	// a stream reset by the client stops waiting
+	return writeChunks(w.r.Context(), w.limiter, b, w.ResponseWriter.Write)
+}
+
+// Flush passes through to the underlying writer when it can flush
+func (w *limitedResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes through to the underlying writer when it can be hijacked
+// (eg. websocket upgrades), the hijacked connection is only limited by the
+// connection limits from then on
+func (w *limitedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Push passes through to the underlying writer when it supports HTTP/2
+// server push, pushed responses go through the middleware on their own
+func (w *limitedResponseWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (w *limitedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,93 @@
+package limlistener
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flushCounter is a recorder counting the flushes that reach it
+type flushCounter struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (f *flushCounter) Flush() {
+	f.flushes++
+	f.ResponseRecorder.Flush()
+}
+
+func TestLimitRequestsFlushesWithHandler(t *testing.T) {
+	h := LimitRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 3024))
+		w.(http.Flusher).Flush()
+	}), 10000)
+	w := &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+
+	start := time.Now()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	// a full bucket and then 2000 bytes at 10000 bytes/sec
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("3024 bytes took %v, want about 200ms", elapsed)
+	}
+	if w.Body.Len() != 3024 {
+		t.Errorf("%d bytes written, want 3024", w.Body.Len())
+	}
+	if w.flushes != 1 {
+		t.Errorf("flushed %d times, want only the handler's", w.flushes)
+	}
+}
+
+func TestLimitRequestsHijack(t *testing.T) {
+	srv := httptest.NewServer(LimitRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "no hijacker", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := h.Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\nhello")
+		rw.Flush()
+	}), 10000))
+	defer srv.Close()
+
+	c, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want the upgrade", resp.StatusCode)
+	}
+}
+
+func TestLimitRequestsPushUnsupported(t *testing.T) {
+	h := LimitRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := w.(http.Pusher)
+		if !ok {
+			t.Error("no pusher")
+			return
+		}
+		if err := p.Push("/style.css", nil); err != http.ErrNotSupported {
+			t.Errorf("Push = %v, want http.ErrNotSupported over a writer without push", err)
+		}
+		if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok || u.Unwrap() == nil {
+			t.Error("can't be unwrapped")
+		}
+	}), 10000)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", strings.NewReader("")))
+}
@@ -0,0 +1,69 @@
+package limlistener
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SetInactiveAfter stops counting connections that haven't read or written
+// anything for d towards the connection count rules, so the limits follow
+// the connections actually using bandwidth; they count again as soon as
+// they write. A d of 0 counts every open connection.
+func (ll *LimitedListener) SetInactiveAfter(d time.Duration) {
+	ll.mu.Lock()
+	atomic.StoreInt64(ll.inactiveAfter, int64(d))
+	if ll.inactiveStop != nil {
+		close(ll.inactiveStop)
+		ll.inactiveStop = nil
+	}
+	if d > 0 {
+		ll.inactiveStop = make(chan struct{})
+		go ll.watchInactive(d, ll.inactiveStop)
+	}
+	ll.mu.Unlock()
+
+	ll.applyRules()
+}
+
+// watchInactive re-evaluates the rules as connections go inactive
+func (ll *LimitedListener) watchInactive(d time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(d / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		ll.applyRules()
+	}
+}
+
+// activeConns counts the connections the rules go by,
+// must be called with the lock held
+func (ll *LimitedListener) activeConns() int {
+	d := atomic.LoadInt64(ll.inactiveAfter)
+	if d <= 0 {
+		return len(ll.conns)
+	}
+	cutoff := time.Now().UnixNano() - d
+	active := 0
+	for _, conn := range ll.conns {
+		if atomic.LoadInt64(&conn.stats.lastActive) >= cutoff {
+			active++
+		}
+	}
+	return active
+}
+
+// resumed tells if a connection last active at last
+// (unix nanos) was inactive until now
+func (ll *LimitedListener) resumed(last int64) bool {
+	// dialed connections have no listener
+	if ll == nil {
+		return false
+	}
+	d := atomic.LoadInt64(ll.inactiveAfter)
+	return d > 0 && time.Now().UnixNano()-last > d
+}
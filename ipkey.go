@@ -0,0 +1,67 @@
+package limlistener
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// IPv6 clients usually get a whole /64, group them by it by default
+	defaultIPv6Prefix = 64
+)
+
+// addrIP extracts the IP of a connection address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	// drop any IPv6 zone
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host)
+}
+
+// addrString formats a connection address, some transports
+// (eg. named pipes) don't always have one
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// ipKey returns the key a client address is limited under: IPv4 and
+// IPv4-mapped IPv6 addresses map to the same plain IPv4 key while IPv6
+// addresses are grouped by their first v6Prefix bits
+func ipKey(addr net.Addr, v6Prefix int) string {
+	ip := addrIP(addr)
+	if ip == nil {
+		if addr == nil {
+			return ""
+		}
+		// not an IP network (eg. unix sockets), use the address as-is
+		return addr.String()
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	if v6Prefix <= 0 || v6Prefix > 128 {
+		v6Prefix = 128
+	}
+	masked := ip.Mask(net.CIDRMask(v6Prefix, 128))
+	return masked.String() + "/" + strconv.Itoa(v6Prefix)
+}
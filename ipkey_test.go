@@ -0,0 +1,228 @@
+package limlistener
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIPKey(t *testing.T) {
+	tests := []struct {
+		addr   net.Addr
+		prefix int
+		want   string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}, 64, "192.0.2.1"},
+		// IPv4-mapped IPv6, as seen on dual-stack listeners
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 2}, 64, "192.0.2.1"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8:1:2:3:4:5:6"), Port: 3}, 64, "2001:db8:1:2::/64"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8:1:2:ffff::1"), Port: 4}, 64, "2001:db8:1:2::/64"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8:1:2:3:4:5:6"), Port: 5}, 48, "2001:db8:1::/48"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8:1:2:3:4:5:6"), Port: 6}, 128, "2001:db8:1:2:3:4:5:6/128"},
+		// out of range prefixes limit each address on its own
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 7}, 0, "2001:db8::1/128"},
+		{&net.UDPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 8}, 64, "10.0.0.1"},
+		{&net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, 64, "/tmp/sock"},
+		{nil, 64, ""},
+	}
+	for _, tt := range tests {
+		if got := ipKey(tt.addr, tt.prefix); got != tt.want {
+			t.Errorf("ipKey(%v, %d) = %q, want %q", tt.addr, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// addrListener hands out one end of a pipe per address, each
+// pretending to come from it
+type addrListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+}
+
+func newAddrListener(t *testing.T, addrs ...string) *addrListener {
+	l := &addrListener{
+		conns: make(chan net.Conn, len(addrs)),
+		done:  make(chan struct{}),
+	}
+	for _, addr := range addrs {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		l.conns <- addrConn{server, &net.TCPAddr{IP: net.ParseIP(addr), Port: 4242}}
+	}
+	return l
+}
+
+func (l *addrListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *addrListener) Close() error {
+	close(l.done)
+	return nil
+}
+
+func (l *addrListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv6loopback}
+}
+
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestIPLimitAcrossAddressFamilies(t *testing.T) {
+	// a client alternating between its IPv4 address, the same one mapped
+	// to IPv6 and addresses within its IPv6 /64 only gets two buckets
+	l := newAddrListener(t,
+		"192.0.2.1",
+		"::ffff:192.0.2.1",
+		"2001:db8:1:2::1",
+		"2001:db8:1:2::2",
+		"2001:db8:1:2:aaaa::3",
+	)
+	ll := NewWithListener(l)
+	defer ll.Close()
+	ll.SetIPLimit(64 * 1024)
+
+	var conns []LimitedConn
+	for i := 0; i < 5; i++ {
+		conn, err := ll.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ll.CloseConnection(conn)
+		conns = append(conns, conn.(LimitedConn))
+	}
+	if conns[0].keyLimiter != conns[1].keyLimiter {
+		t.Error("IPv4 and IPv4-mapped IPv6 connections don't share a limiter")
+	}
+	for _, c := range conns[3:] {
+		if c.keyLimiter != conns[2].keyLimiter {
+			t.Errorf("%v doesn't share the limiter of its /64", c.RemoteAddr())
+		}
+	}
+	if conns[0].keyLimiter == conns[2].keyLimiter {
+		t.Error("IPv4 and IPv6 clients share a limiter")
+	}
+	if stats := ll.IPRegistryStats(); stats.Entries != 2 {
+		t.Errorf("registry has %d entries, want 2", stats.Entries)
+	}
+}
+
+func TestIPLimitDualStackListener(t *testing.T) {
+	l, err := net.Listen("tcp", "[::]:0")
+	if err != nil {
+		t.Skip("no IPv6:", err)
+	}
+	ll := NewWithListener(l)
+	defer ll.Close()
+	ll.SetIPLimit(64 * 1024)
+	port := l.Addr().(*net.TCPAddr).Port
+
+	var keys []string
+	for _, host := range []string{"127.0.0.1", "::ffff:127.0.0.1"} {
+		c, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			t.Skip("not a dual-stack listener:", err)
+		}
+		defer c.Close()
+		conn, err := ll.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ll.CloseConnection(conn)
+		keys = append(keys, conn.(LimitedConn).key)
+	}
+	if keys[0] != "127.0.0.1" || keys[1] != keys[0] {
+		t.Errorf("keys = %q, want both 127.0.0.1", keys)
+	}
+}
+
+func TestAddrString(t *testing.T) {
+	if s := addrString(nil); s != "" {
+		t.Errorf("addrString(nil) = %q, want empty", s)
+	}
+	addr := &net.UnixAddr{Name: `\\.\pipe\app`, Net: "pipe"}
+	if s := addrString(addr); s != addr.String() {
+		t.Errorf("addrString(%v) = %q", addr, s)
+	}
+}
+
+// titleLog records the title of the event logs created
+type titleLog struct {
+	titles chan string
+}
+
+func (l *titleLog) newLog(family, title string) EventLog {
+	l.titles <- title
+	return l
+}
+
+func (l *titleLog) Printf(format string, a ...interface{}) {}
+func (l *titleLog) Errorf(format string, a ...interface{}) {}
+func (l *titleLog) Finish()                                {}
+
+func TestNoRemoteAddr(t *testing.T) {
+	// pipe-like transports may not know their peer's address
+	l := &addrListener{
+		conns: make(chan net.Conn, 2),
+		done:  make(chan struct{}),
+	}
+	for i := 0; i < 2; i++ {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go io.Copy(ioutil.Discard, client)
+		l.conns <- addrConn{server, nil}
+	}
+	ll := NewWithListener(l)
+	defer ll.Close()
+	ll.SetIPLimit(64 * 1024)
+	events := &titleLog{titles: make(chan string, 2)}
+	ll.SetEventLogs(events.newLog, time.Second)
+
+	conn, err := ll.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title := <-events.titles; title != "" {
+		t.Errorf("event log titled %q, want empty", title)
+	}
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if infos := ll.Conns(nil); len(infos) != 1 || infos[0].RemoteAddr != nil {
+		t.Errorf("Conns() = %+v, want one connection without a remote address", infos)
+	}
+	ll.CloseConnection(conn)
+
+	// a panicking handler is still reported
+	ll.SetErrorHandler(func(net.Conn, error) {})
+	done := make(chan struct{})
+	go ll.Serve(func(net.Conn) {
+		defer close(done)
+		panic("handler")
+	})
+	<-done
+	deadline := time.Now().Add(time.Second)
+	for ll.PanicStats().Count == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("panic not reported")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if remote := ll.PanicStats().Last.Remote; remote != "" {
+		t.Errorf("panic reported for %q, want no remote address", remote)
+	}
+}
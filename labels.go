@@ -0,0 +1,27 @@
+package limlistener
+
+// SetLabels attaches static labels (eg. region, service, tier) to the
+// listener, for whatever exports its state to tell deployments apart
+func (ll *LimitedListener) SetLabels(labels map[string]string) {
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.labels = copied
+}
+
+// Labels returns the labels attached to the listener
+func (ll *LimitedListener) Labels() map[string]string {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	labels := make(map[string]string, len(ll.labels))
+	for k, v := range ll.labels {
+		labels[k] = v
+	}
+	return labels
+}
@@ -0,0 +1,309 @@
+// Package limfs exposes a LimitedListener as a virtual filesystem, Plan 9
+// style: its limits and connections are directories of small text files,
+// reading them returns the current state and writing to the limit files
+// changes it.
+//
+//	global/limit        global limit in bytes/sec (rw)
+//	global/state        global token bucket
+//	conn/limit          per-connection limit in bytes/sec (rw)
+//	saturation          share of recent samples the global limiter was saturated
+//	shed                connections and bytes shed
+//	labels              static labels
+//	conns/<id>/limit    limit of a connection in bytes/sec (rw)
+//	conns/<id>/class    class of a connection
+//	conns/<id>/remote   remote address of a connection
+//	conns/<id>/local    local address of a connection
+//	conns/<id>/stats    counters of a connection
+//
+// FS implements io/fs.FS so it can be walked, served with http.FS or
+// mounted by a 9P or FUSE server supplying the transport, writes go
+// through WriteFile.
+package limfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lrascao/limlistener"
+)
+
+// Actor is recorded in the audit trail for the limit changes written
+const Actor = "limfs"
+
+// FS is the filesystem view of a listener
+type FS struct {
+	ll *limlistener.LimitedListener
+}
+
+// New returns the filesystem view of ll
+func New(ll *limlistener.LimitedListener) *FS {
+	return &FS{ll: ll}
+}
+
+// node is a file or directory of the tree, generated when opened
+type node struct {
+	name     string
+	content  []byte
+	children []string
+	writable bool
+}
+
+func (n *node) dir() bool {
+	return n.content == nil
+}
+
+// Open opens the named file for reading, its content is a snapshot
+// taken when opened
+func (f *FS) Open(name string) (fs.File, error) {
+	n, err := f.lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if n.dir() {
+		d := &dir{info: f.info(n)}
+		for _, child := range n.children {
+			cn, err := f.lookup(join(name, child))
+			if err != nil {
+				// gone since it was listed
+				continue
+			}
+			d.entries = append(d.entries, f.info(cn))
+		}
+		return d, nil
+	}
+	return &file{info: f.info(n), r: bytes.NewReader(n.content)}, nil
+}
+
+// WriteFile writes data to the named limit file, setting the limit to the
+// number it holds
+func (f *FS) WriteFile(name string, data []byte) error {
+	n, err := f.lookup(name)
+	if err == nil && !n.writable {
+		err = fs.ErrPermission
+	}
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || limit < 0 {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+
+	globalLimit, connLimit := f.ll.Limits()
+	switch parts := strings.Split(name, "/"); {
+	case name == "global/limit":
+		f.ll.SetLimitsBy(Actor, limit, connLimit)
+	case name == "conn/limit":
+		f.ll.SetLimitsBy(Actor, globalLimit, limit)
+	case len(parts) == 3 && parts[0] == "conns":
+		conn := f.conn(parts[1])
+		if conn == nil {
+			return &fs.PathError{Op: "write", Path: name, Err: fs.ErrNotExist}
+		}
+		conn.SetLimitBy(Actor, limit)
+	}
+	return nil
+}
+
+// lookup generates the named node
+func (f *FS) lookup(name string) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, fs.ErrInvalid
+	}
+	switch name {
+	case ".":
+		return &node{name: ".", children: []string{"conn", "conns", "global", "labels", "saturation", "shed"}}, nil
+	case "global":
+		return &node{name: name, children: []string{"limit", "state"}}, nil
+	case "global/limit":
+		globalLimit, _ := f.ll.Limits()
+		return &node{name: "limit", content: line(globalLimit), writable: true}, nil
+	case "global/state":
+		return &node{name: "state", content: state(f.ll.GlobalLimiter().State())}, nil
+	case "conn":
+		return &node{name: name, children: []string{"limit"}}, nil
+	case "conn/limit":
+		_, connLimit := f.ll.Limits()
+		return &node{name: "limit", content: line(connLimit), writable: true}, nil
+	case "saturation":
+		return &node{name: name, content: line(strconv.FormatFloat(f.ll.Saturation(), 'f', 3, 64))}, nil
+	case "shed":
+		shed := f.ll.ShedStats()
+		return &node{name: name, content: pairs(
+			"refused", shed.RefusedConns,
+			"evicted", shed.EvictedConns,
+			"dropped", shed.DroppedBytes,
+			"rejected", shed.RejectedWrites,
+		)}, nil
+	case "labels":
+		labels := f.ll.Labels()
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var b bytes.Buffer
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s %s\n", k, labels[k])
+		}
+		return &node{name: name, content: b.Bytes()}, nil
+	case "conns":
+		n := &node{name: name, children: []string{}}
+		for _, info := range f.ll.Conns(nil) {
+			n.children = append(n.children, strconv.Itoa(info.ID))
+		}
+		return n, nil
+	}
+
+	parts := strings.Split(name, "/")
+	if parts[0] != "conns" || len(parts) > 3 {
+		return nil, fs.ErrNotExist
+	}
+	conn := f.conn(parts[1])
+	if conn == nil {
+		return nil, fs.ErrNotExist
+	}
+	if len(parts) == 2 {
+		return &node{name: parts[1], children: []string{"class", "limit", "local", "remote", "stats"}}, nil
+	}
+	info := conn.Info()
+	switch parts[2] {
+	case "limit":
+		return &node{name: "limit", content: line(int64(info.Limiter.Limit)), writable: true}, nil
+	case "class":
+		return &node{name: "class", content: line(info.Class)}, nil
+	case "local":
+		return &node{name: "local", content: line(info.LocalAddr)}, nil
+	case "remote":
+		return &node{name: "remote", content: line(info.RemoteAddr)}, nil
+	case "stats":
+		return &node{name: "stats", content: pairs(
+			"wire", info.Stats.WireBytes,
+			"logical", info.Stats.LogicalBytes,
+			"dropped", info.Stats.DroppedBytes,
+			"rejected", info.Stats.RejectedWrites,
+			"wait", info.Stats.Wait.Sum,
+			"write", info.Stats.Write.Sum,
+			"lastactive", info.Stats.LastActive.Format(time.RFC3339Nano),
+		)}, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+// conn finds the open connection with the given id
+func (f *FS) conn(id string) *limlistener.LimitedConn {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil
+	}
+	var found *limlistener.LimitedConn
+	f.ll.Conns(func(conn *limlistener.LimitedConn) bool {
+		if conn.ID() == n {
+			found = conn
+		}
+		return false
+	})
+	return found
+}
+
+func (f *FS) info(n *node) *info {
+	mode := fs.FileMode(0444)
+	switch {
+	case n.dir():
+		mode = fs.ModeDir | 0555
+	case n.writable:
+		mode = 0644
+	}
+	return &info{name: n.name, size: int64(len(n.content)), mode: mode}
+}
+
+func join(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}
+
+func line(v interface{}) []byte {
+	return []byte(fmt.Sprintln(v))
+}
+
+// pairs formats "key value" lines
+func pairs(kv ...interface{}) []byte {
+	var b bytes.Buffer
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, "%v %v\n", kv[i], kv[i+1])
+	}
+	return b.Bytes()
+}
+
+func state(s limlistener.LimiterState) []byte {
+	return pairs(
+		"limit", s.Limit,
+		"burst", s.Burst,
+		"tokens", strconv.FormatFloat(s.Tokens, 'f', 0, 64),
+		"lastevent", s.LastEvent.Format(time.RFC3339Nano),
+	)
+}
+
+// info describes a node, both as fs.FileInfo and fs.DirEntry
+type info struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (i *info) Name() string               { return i.name }
+func (i *info) Size() int64                { return i.size }
+func (i *info) Mode() fs.FileMode          { return i.mode }
+func (i *info) ModTime() time.Time         { return time.Time{} }
+func (i *info) IsDir() bool                { return i.mode.IsDir() }
+func (i *info) Sys() interface{}           { return nil }
+func (i *info) Type() fs.FileMode          { return i.mode.Type() }
+func (i *info) Info() (fs.FileInfo, error) { return i, nil }
+
+type file struct {
+	info *info
+	r    *bytes.Reader
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Read(b []byte) (int, error) { return f.r.Read(b) }
+func (f *file) Close() error               { return nil }
+
+type dir struct {
+	info    *info
+	entries []*info
+	off     int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	left := d.entries[d.off:]
+	if n > 0 && len(left) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(left) {
+		left = left[:n]
+	}
+	d.off += len(left)
+	entries := make([]fs.DirEntry, len(left))
+	for i, e := range left {
+		entries[i] = e
+	}
+	return entries, nil
+}
@@ -0,0 +1,56 @@
+package limlistener
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrConnLimitAboveGlobal is returned when strict limits refuse a
+// per-connection limit above the global one
+var ErrConnLimitAboveGlobal = errors.New("limlistener: conn limit exceeds the global limit")
+
+// LimitsCheck decides what SetLimits does with a per-connection limit
+// above the global one, which leaves the global limit as the real cap
+type LimitsCheck int
+
+const (
+	// LimitsLoose applies the limits as given
+	LimitsLoose LimitsCheck = iota
+	// LimitsStrict refuses them, SetLimits hands the error to the
+	// error handler and TrySetLimits returns it
+	LimitsStrict
+	// LimitsClamp brings the per-connection limit down to the global one
+	LimitsClamp
+)
+
+// SetLimitsCheck sets what SetLimits does with a per-connection limit
+// above the global one from now on
+func (ll *LimitedListener) SetLimitsCheck(check LimitsCheck) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.limitsCheck = check
+}
+
+// TrySetLimits is SetLimits returning the error strict limits
+// refuse them with, in which case nothing changes
+func (ll *LimitedListener) TrySetLimits(globalLimit, connLimit int) error {
+	return ll.trySetLimitsBy("", globalLimit, connLimit)
+}
+
+// apply returns the per-connection limit to use
+// along with the global one, if it's allowed
+func (c LimitsCheck) apply(globalLimit, connLimit int) (int, error) {
+	// a global limit of 0 caps nothing
+	if globalLimit <= 0 || connLimit <= globalLimit {
+		return connLimit, nil
+	}
+	switch c {
+	case LimitsStrict:
+		return 0, fmt.Errorf("%w: %d bytes/sec per connection would never be reached under %d bytes/sec overall",
+			ErrConnLimitAboveGlobal, connLimit, globalLimit)
+	case LimitsClamp:
+		return globalLimit, nil
+	}
+	return connLimit, nil
+}
@@ -0,0 +1,303 @@
+package limlistener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// LimiterState is a point in time view of a token bucket
+type LimiterState struct {
+	// configured rate in bytes/sec, math.Inf when unlimited
+	Limit float64
+	// configured bucket size
+	Burst int
+	// tokens currently available (the burst while unlimited), negative
+	// when writers are queued up waiting for tokens that have already
+	// been promised to them
+	Tokens float64
+	// last time tokens were taken from the bucket
+	LastEvent time.Time
+}
+
+// MarshalJSON encodes an unlimited rate as a null limit,
+// JSON has no infinity
+func (s LimiterState) MarshalJSON() ([]byte, error) {
+	// the alias has no MarshalJSON of its own
+	type state LimiterState
+	v := struct {
+		state
+		Limit *float64
+	}{state: state(s)}
+	if !math.IsInf(s.Limit, 1) {
+		v.Limit = &s.Limit
+	}
+	return json.Marshal(v)
+}
+
+// ErrBurstExceeded is returned when waiting for more tokens than a
+// limiter's burst, which could never be granted
+var ErrBurstExceeded = errors.New("limlistener: wait exceeds limiter burst")
+
+// limiter is a rate.Limiter that also keeps track of its token bucket
+// so its state can be inspected, the shadow bucket replays the same
+// arithmetic rate.Limiter does on every reservation. Like rate.Limiter's,
+// it's frozen while the limit is rate.Inf: nothing is taken from it or
+// given back and it doesn't refill, picking up from there once the limit
+// is finite again (eg. a fresh global limiter starts out empty when its
+// first limit is set).
+type limiter struct {
+	lim *rlimit.Limiter
+
+	mu        sync.Mutex
+	tokens    float64
+	last      time.Time
+	lastEvent time.Time
+}
+
+func newLimiter(limit rlimit.Limit, burst int) *limiter {
+	l := &limiter{
+		lim:  rlimit.NewLimiter(limit, burst),
+		last: time.Now(),
+	}
+	// rate.Limiter starts out empty and fills up on first use
+	// at a finite rate, never under rate.Inf
+	if limit != rlimit.Inf {
+		l.tokens = float64(burst)
+	}
+	return l
+}
+
+// advance refills the shadow bucket up to now,
+// must be called with the lock held
+func (l *limiter) advance(now time.Time) {
+	limit, burst := l.lim.Limit(), float64(l.lim.Burst())
+	if limit == rlimit.Inf {
+		l.last = now
+		return
+	}
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * float64(limit)
+		l.last = now
+	}
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+}
+
+// took accounts n tokens taken at now, must be called with the lock held
+func (l *limiter) took(now time.Time, n int) {
+	l.advance(now)
+	if l.lim.Limit() != rlimit.Inf {
+		l.tokens -= float64(n)
+	}
+	l.lastEvent = now
+}
+
+// gave accounts n tokens given back at now, must be called with the lock held
+func (l *limiter) gave(now time.Time, n int) {
+	l.advance(now)
+	if l.lim.Limit() != rlimit.Inf {
+		l.tokens += float64(n)
+		l.advance(now)
+	}
+}
+
+// WaitN blocks until n tokens are available
+func (l *limiter) WaitN(ctx context.Context, n int) error {
+	if burst := l.lim.Burst(); n > burst && l.lim.Limit() != rlimit.Inf {
+		return fmt.Errorf("%w: %d bytes with a burst of %d, raise the burst or lower the MTU", ErrBurstExceeded, n, burst)
+	}
+	l.mu.Lock()
+	l.took(time.Now(), n)
+	l.mu.Unlock()
+
+	err := l.lim.WaitN(ctx, n)
+	if err != nil {
+		// the reservation was given back
+		l.mu.Lock()
+		l.gave(time.Now(), n)
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// probeN takes n tokens only if they are available right away,
+// otherwise it returns how long WaitN would have waited for them
+func (l *limiter) probeN(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	limit := l.lim.Limit()
+	if limit == 0 {
+		return 0
+	}
+	r := l.lim.ReserveN(now, n)
+	if !r.OK() {
+		// more than the burst, it would have failed
+		return time.Duration(float64(n) / float64(limit) * float64(time.Second))
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay
+	}
+	l.took(now, n)
+	return 0
+}
+
+// bucketWait are n tokens to be taken from a limiter
+type bucketWait struct {
+	limiter *limiter
+	n       int
+}
+
+// tryTake takes n tokens only if they are available right away
+func (l *limiter) tryTake(n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if !l.lim.AllowN(now, n) {
+		return false
+	}
+	l.took(now, n)
+	return true
+}
+
+// reserveNow takes n tokens only if they are available within slack
+// (going into debt for them), otherwise it returns how long WaitN would
+// have waited for them
+func (l *limiter) reserveNow(now time.Time, n int, slack time.Duration) (*rlimit.Reservation, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r := l.lim.ReserveN(now, n)
+	if !r.OK() {
+		// more than the burst, it can never be granted
+		return nil, rlimit.InfDuration
+	}
+	if delay := r.DelayFrom(now); delay > slack {
+		r.CancelAt(now)
+		return nil, delay
+	}
+	l.took(now, n)
+	return r, 0
+}
+
+// cancel gives back the n tokens of a reservation made at now
+func (l *limiter) cancel(now time.Time, r *rlimit.Reservation, n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.CancelAt(now)
+	// canceling a reservation made at a finite limit gives
+	// nothing back once unlimited, like rate.Limiter
+	l.gave(now, n)
+}
+
+// unlimited tells if the limiter holds nothing back, a limit
+// of 0 (never set) doesn't either
+func (l *limiter) unlimited() bool {
+	limit := l.Limit()
+	return limit == rlimit.Inf || limit == 0
+}
+
+// available tells how many tokens can be taken right now
+func (l *limiter) available() float64 {
+	if l.unlimited() {
+		return math.Inf(1)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.advance(time.Now())
+	return l.tokens
+}
+
+// delay tells how long it'd take for n tokens to be available
+func (l *limiter) delay(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.advance(time.Now())
+	limit := l.lim.Limit()
+	// 0 is no limit either, see unlimited
+	if limit == rlimit.Inf || limit == 0 || l.tokens >= float64(n) {
+		return 0
+	}
+	return time.Duration((float64(n) - l.tokens) / float64(limit) * float64(time.Second))
+}
+
+// take takes n tokens without waiting for them,
+// going into debt if they aren't there yet
+func (l *limiter) take(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.lim.ReserveN(now, n)
+	l.took(now, n)
+}
+
+// SetLimit sets a new rate
+func (l *limiter) SetLimit(limit rlimit.Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// tokens accrued so far are accounted at the old rate
+	l.advance(time.Now())
+	l.lim.SetLimit(limit)
+}
+
+// SetBurst sets a new bucket size
+func (l *limiter) SetBurst(burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.advance(time.Now())
+	l.lim.SetBurst(burst)
+}
+
+// ensureBurst raises the bucket size so that n tokens can be granted at once
+func (l *limiter) ensureBurst(n int) {
+	if l.Burst() < n {
+		l.SetBurst(n)
+	}
+}
+
+// Limit returns the configured rate
+func (l *limiter) Limit() rlimit.Limit {
+	return l.lim.Limit()
+}
+
+// Burst returns the configured bucket size
+func (l *limiter) Burst() int {
+	return l.lim.Burst()
+}
+
+// State returns the current bucket state
+func (l *limiter) State() LimiterState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.advance(time.Now())
+	limit, tokens := float64(l.lim.Limit()), l.tokens
+	if l.lim.Limit() == rlimit.Inf {
+		// everything is available, whatever the frozen bucket holds
+		limit = math.Inf(1)
+		tokens = float64(l.lim.Burst())
+	}
+	return LimiterState{
+		Limit:     limit,
+		Burst:     l.lim.Burst(),
+		Tokens:    tokens,
+		LastEvent: l.lastEvent,
+	}
+}
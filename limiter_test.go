@@ -0,0 +1,93 @@
+package limlistener
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// realTokens returns the tokens the rate.Limiter of l holds, out of how
+// long a reservation of its whole burst would have to wait
+func realTokens(l *limiter) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.lim.Limit() == rlimit.Inf {
+		return float64(l.lim.Burst())
+	}
+	now := time.Now()
+	r := l.lim.ReserveN(now, l.lim.Burst())
+	delay := r.DelayFrom(now)
+	r.CancelAt(now)
+	return float64(l.lim.Burst()) - delay.Seconds()*float64(l.lim.Limit())
+}
+
+func TestLimiterStateMatchesRateLimiter(t *testing.T) {
+	const rate = 10000
+	l := newLimiter(rlimit.Inf, 1024)
+	steps := []struct {
+		name string
+		do   func()
+	}{
+		{"unlimited", func() {}},
+		// a limiter only ever unlimited has nothing in its bucket
+		{"first limit", func() { l.SetLimit(rate) }},
+		{"refill", func() { time.Sleep(20 * time.Millisecond) }},
+		{"take", func() { l.tryTake(100) }},
+		{"debt", func() { l.take(500) }},
+		{"back to unlimited", func() { l.SetLimit(rlimit.Inf) }},
+		// nothing is taken while unlimited
+		{"take unlimited", func() { l.take(800) }},
+		{"wait unlimited", func() { l.WaitN(context.Background(), 800) }},
+		{"limit again", func() {
+			time.Sleep(10 * time.Millisecond)
+			l.SetLimit(rate)
+		}},
+		{"wait", func() { l.WaitN(context.Background(), 200) }},
+		{"bigger burst", func() { l.SetBurst(4096) }},
+		{"refill bigger", func() { time.Sleep(30 * time.Millisecond) }},
+		{"reserve and cancel", func() {
+			now := time.Now()
+			if r, _ := l.reserveNow(now, 300, time.Second); r != nil {
+				l.cancel(now, r, 300)
+			}
+		}},
+		{"smaller burst", func() { l.SetBurst(512) }},
+		{"other limit", func() { l.SetLimit(2 * rate) }},
+	}
+	for _, step := range steps {
+		step.do()
+		state := l.State()
+		real := realTokens(l)
+		// both are looked at a few microseconds apart
+		if math.Abs(state.Tokens-real) > 5 {
+			t.Errorf("%s: State() has %.1f tokens, rate.Limiter %.1f", step.name, state.Tokens, real)
+		}
+	}
+}
+
+func TestFreshConnStateAfterSetLimits(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetLimits(10000, 10000)
+	conn := acceptDrained(t, ll)
+
+	// the global limiter was never limited before, it starts out empty
+	for name, l := range map[string]*limiter{
+		"global": ll.GlobalLimiter().limiter,
+		"conn":   conn.connLimiter,
+	} {
+		if state, real := l.State(), realTokens(l); math.Abs(state.Tokens-real) > 5 {
+			t.Errorf("%s: State() has %.1f tokens, rate.Limiter %.1f", name, state.Tokens, real)
+		}
+	}
+	n, wait, err := conn.TryWrite(make([]byte, 8000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n > 0 && wait == 0 {
+		t.Errorf("TryWrite sent %d bytes without a wait out of an empty global bucket", n)
+	}
+}
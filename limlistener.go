@@ -2,8 +2,12 @@ package limlistener
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	rlimit "golang.org/x/time/rate"
@@ -19,23 +23,210 @@ const (
 type LimitedConn struct {
 	// connection id
 	id int
+	// the listener that accepted this connection
+	listener *LimitedListener
 	// the underlying connection
-	conn          net.Conn
-	globalLimiter *rlimit.Limiter
-	connLimiter   *rlimit.Limiter
-	mtu           int
+	conn        net.Conn
+	global      *GlobalLimiter
+	connLimiter *limiter
+	// limiter shared with all connections with the same key
+	// (client IP when accepted, destination when dialed)
+	keyLimiter *limiter
+	key        string
+	mtu        *connMTU
+	// bytes charged on top of each chunk (eg. TLS record overhead)
+	recordOverhead int
+	chunking       ChunkingStrategy
+	// chunks a write takes before yielding, 0 for never
+	yieldChunks int
+	// spreads granted chunks over the interval between grants, nil if off
+	micro *microPacer
+	// ALPN protocol the connection traffic is accounted to, nil if not TLS
+	protocol *connProtocol
+	// published connection events, nil if not published
+	events *connEvents
+	// one-time extra tokens granted on top of the limit
+	credit *burstCredit
+	// application context set with SetContext
+	ctx *connContext
+	// closed state shared by the copies of the connection
+	closing *connClosing
+	// first bytes going out at their own limit, nil if none
+	fastStart *fastStart
+	// first bytes going out without waiting, nil if none
+	short *shortConn
+	// when it was accepted and its limit steps since then
+	accepted time.Time
+	schedule *limitSchedule
+	// pending writes when running in async write mode
+	queue *writeQueue
+	// mirrors written bytes when the connection is tapped
+	tap   *connTap
+	stats *connStats
+	// its counters when it was attached to the listener, nil if accepted
+	baseline *ConnStats
+	class    *connClass
+	// pending TLS handshake, classifying the connection once done
+	handshake *tlsHandshake
+	// buffers the first bytes read so they can be peeked at
+	peek *peeker
+	// per-connection shadow limit
+	shadow *shadowLimiter
+	// high priority writes waiting
+	prio *priorityGate
+	// two-rate limits replacing the per-connection one
+	twoRate *twoRateState
+	// paces to the measured path rate when enabled
+	pacer *pathPacer
+	// ingress limiter
+	readLimiter *limiter
+	// control sub-channel limiter
+	control *limiter
+	// limiters to give back to the pool, nil if not pooled
+	pooled *pooledLimiters
+	// reads wait on the same limiters as writes
+	chargeReads bool
+	// dialed connection's turn towards its destination, nil if not polite
+	slot *hostSlot
+	// set once the connection is exempted from every limit,
+	// nil if it can't be (see exemptACME)
+	exempt *exemption
 }
 
 // LimitedListener satisfies the net.Listener interface
 // and adds both global and per-connection bandwidth throttling
 // functionality
 type LimitedListener struct {
-	n_conns       int
-	listener      net.Listener
-	globalLimiter *rlimit.Limiter
-	connLimit     int
-	mtu           int
-	conns         []*LimitedConn
+	mu sync.Mutex
+	// connections accepted so far, the next connection id
+	n_conns   int
+	listener  net.Listener
+	global    *GlobalLimiter
+	connLimit int
+	mtu       int
+	// open connections by id
+	conns   map[int]*LimitedConn
+	clock   Clock
+	budget  *Budget
+	tap     *Tap
+	capture *Capture
+	// spare limiters of closed connections, nil if not pooling
+	limiterPool *limiterPool
+	// connections can be exempted from the limits (ie. ACME challenges)
+	exemptions bool
+	// accepted connections are told about their rate
+	advertiseRate  bool
+	onRateAssigned func(conn LimitedConn, rates Rates)
+	// limits set by SetLimits, connection count rules might override them
+	baseGlobalLimit int
+	baseConnLimit   int
+	limitsSet       bool
+	// what SetLimits does with a conn limit above the global one
+	limitsCheck LimitsCheck
+	// largest grant a write reserves at once, 0 for a chunk at a time
+	maxGrant int32
+	// what Close does with the connections left, and the
+	// cascading Close waiting for their handlers to be done
+	closeMode  CloseMode
+	closeGrace time.Duration
+	drain      *connsDrain
+	// Close was called
+	shutdown bool
+	// connection count rules and the index of the one that holds
+	rules      []ConnCountRule
+	activeRule int
+	// admission policy and the channel stopping its reviews
+	policy     AdmissionPolicy
+	policyStop chan struct{}
+	// derives the context of accepted connections
+	connContext func(context.Context, net.Conn) context.Context
+	// estimated per-chunk overhead charged to new connections
+	recordOverhead int
+	chunking       ChunkingStrategy
+	microPieces    int
+	yieldEvery     int
+	// limit classes and the classifiers of new connections
+	classes             map[string]*limitClass
+	tlsClassifier       TLSClassifier
+	tlsHandshakeTimeout time.Duration
+	sniffer             Sniffer
+	sniffN              int
+	// per client IP limiters and the IPv6 prefix length clients are grouped by
+	ipLimiters   *registry
+	ipv6Prefix   int
+	ipMaxEntries int
+	ipOnIdle     func(key string, stats KeyStats)
+	// async write mode settings for new connections
+	queueSize   int
+	queuePolicy WriteQueuePolicy
+	closeFlush  time.Duration
+	// latest limit changes
+	audit auditTrail
+	// observe-only mode and what it would have delayed
+	dryRun      int32
+	dryRunStats *throttleCounter
+	// runtime/trace tasks and regions around writes
+	trace int32
+	// limits evaluated but not enforced, and what they would have delayed
+	shadowGlobal    shadowLimiter
+	shadowConnLimit int
+	shadowStats     *throttleCounter
+	// two-rate limits by class
+	twoRates map[string]TwoRate
+	// DSCP code points by class
+	dscp map[string]int
+	// round trip time estimate kernel buffers are sized with
+	bufferRTT time.Duration
+	// experimental pacing to the measured path rate
+	pathPacing int32
+	// ingress limits
+	readGlobal    *limiter
+	readConnLimit int
+	// per-connection limit of the control sub-channel
+	controlConnLimit int
+	// retrying of temporary accept errors
+	minAcceptBackoff time.Duration
+	maxAcceptBackoff time.Duration
+	onAcceptError    func(err error, backoff time.Duration)
+	// response to running out of file descriptors
+	fdAction     FDPressureAction
+	fdMinIdle    time.Duration
+	onFDPressure func(FDPressureEvent)
+	// told about failures of background helpers
+	errorHandler ErrorHandler
+	// demand turned away
+	shed *shedCounters
+	// accept latency
+	accept *acceptCounters
+	// panics contained by the helpers
+	panics *panicCounters
+	// close events, nil until asked for
+	closed *closeNotifier
+	// counters of the connections closed so far, see Snapshot
+	closedStats RollupStats
+	// global saturation measure and load shedding, nil if not measured
+	saturation *saturationMonitor
+	// traffic of TLS connections by ALPN protocol
+	protocols *protocolStats
+	// common tick granted chunks are flushed on, nil if not coalescing
+	flush *flushTicker
+	// first bytes of new connections and the limit they go out at
+	fastStartBytes int
+	fastStartLimit int
+	// first bytes of new connections going out without waiting
+	shortBytes int
+	// connections idle this long don't count towards the rules,
+	// allocated on its own so it's 64-bit aligned
+	inactiveAfter *int64
+	inactiveStop  chan struct{}
+	// window limit changes are smoothed over, allocated
+	// on its own so it's 64-bit aligned
+	transition *int64
+	// static labels exports are tagged with
+	labels map[string]string
+	// creates the event logs of new connections
+	newEventLog func(family, title string) EventLog
+	notableWait time.Duration
 }
 
 // NewWithListener takes an existing net.Listener and creates a new
@@ -45,44 +236,460 @@ func NewWithListener(l net.Listener) LimitedListener {
 	return LimitedListener{
 		n_conns:  0,
 		listener: l,
+		conns:    make(map[int]*LimitedConn),
 		mtu:      defaultMTU,
+		clock:    systemClock{},
+		// stalled TLS clients give up their first read or write
+		tlsHandshakeTimeout: defaultTLSHandshakeTimeout,
+		// no global limit until one is set
+		global:     newGlobalLimiter(rlimit.Inf, 0),
+		activeRule: -1,
+		ipv6Prefix: defaultIPv6Prefix,
+		// temporary accept errors are retried like http.Server does
+		minAcceptBackoff: defaultMinAcceptBackoff,
+		maxAcceptBackoff: defaultMaxAcceptBackoff,
+		// allocated on their own so their counters are 64-bit aligned
+		readGlobal:    newLimiter(rlimit.Inf, defaultMTU),
+		dryRunStats:   &throttleCounter{},
+		shadowStats:   &throttleCounter{},
+		shed:          &shedCounters{},
+		accept:        &acceptCounters{},
+		panics:        &panicCounters{},
+		protocols:     newProtocolStats(),
+		inactiveAfter: new(int64),
+		transition:    new(int64),
 	}
 }
 
-// SetLimits defines both new global and per-connection limits
+// SetLimits defines both new global and per-connection limits,
+// recorded in the audit trail
 func (ll *LimitedListener) SetLimits(globalLimit, connLimit int) {
-	// had we already created a rate limiter?
-	if ll.globalLimiter == nil {
-		ll.globalLimiter = rlimit.NewLimiter(rlimit.Limit(globalLimit), ll.mtu)
-	}
-	// set the global limiter
-	ll.globalLimiter.SetLimit(rlimit.Limit(globalLimit))
-	// keep memory of the new connection limit
-	ll.connLimit = connLimit
-	// update each running connection's rate limiter
-	for _, conn := range ll.conns {
-		conn.SetLimit(connLimit)
+	ll.SetLimitsBy("", globalLimit, connLimit)
+}
+
+// Limits returns the global and per-connection limits set by SetLimits,
+// connection count rules might be overriding them
+func (ll *LimitedListener) Limits() (globalLimit, connLimit int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	return ll.baseGlobalLimit, ll.baseConnLimit
+}
+
+func (ll *LimitedListener) setLimits(globalLimit, connLimit int) {
+	// keep memory of the new limits, connection count rules
+	// might be overriding them
+	ll.mu.Lock()
+	ll.baseGlobalLimit = globalLimit
+	ll.baseConnLimit = connLimit
+	ll.limitsSet = true
+	ll.mu.Unlock()
+
+	ll.applyLimits()
+}
+
+// SetMTU sets the size of the chunks writes of new connections are split
+// into, the burst of every limiter they share (global, class and per-IP) is
+// raised to fit a whole chunk so waits can always be granted
+func (ll *LimitedListener) SetMTU(mtu int) error {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	if mtu <= ll.recordOverhead {
+		return fmt.Errorf("limlistener: MTU of %d bytes doesn't fit the %d bytes per-chunk overhead", mtu, ll.recordOverhead)
+	}
+	ll.mtu = mtu
+	ll.global.limiter.ensureBurst(mtu)
+	for _, cl := range ll.classes {
+		if cl.limiter != nil {
+			cl.limiter.ensureBurst(mtu)
+		}
+	}
+	if ll.ipLimiters != nil {
+		ll.ipLimiters.ensureBurst(mtu)
+	}
+	return nil
+}
+
+// SetIPLimit caps the bandwidth shared by all connections coming from the
+// same client IP, IPv4-mapped IPv6 addresses count as their IPv4 address and
+// IPv6 clients are grouped by prefix (see SetIPv6Prefix). Connections
+// accepted before the first call are not IP limited.
+func (ll *LimitedListener) SetIPLimit(limit int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	if ll.ipLimiters == nil {
+		ll.ipLimiters = newRegistry(limit, ll.mtu, ll.ipMaxEntries)
+		ll.ipLimiters.setOnIdle(ll.ipOnIdle)
+		return
+	}
+	ll.ipLimiters.setLimit(limit)
+}
+
+// SetIPMaxEntries caps how many client IPs have a limiter, so churn or
+// spoofed addresses can't grow them without bound. IPs without open
+// connections keep their limiter (and so their bucket state) until the
+// least recently used ones are evicted to make room. IPs with open
+// connections are never evicted, while all of them have some the cap is
+// exceeded. 0 for no cap, then limiters go away along with the last
+// connection of their IP.
+func (ll *LimitedListener) SetIPMaxEntries(maxEntries int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.ipMaxEntries = maxEntries
+	if ll.ipLimiters != nil {
+		ll.ipLimiters.setMaxEntries(maxEntries)
+	}
+}
+
+// OnKeyIdle calls fn with every client IP whose limiter goes away, its last
+// connection closed (or, with SetIPMaxEntries, evicted to make room) so its
+// last known usage can be persisted or firewall rules adjusted along the
+// same lifecycle. It's called from the goroutine accepting or closing the
+// connection that made the key go away and must not call back into the
+// listener.
+func (ll *LimitedListener) OnKeyIdle(fn func(key string, stats KeyStats)) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.ipOnIdle = fn
+	if ll.ipLimiters != nil {
+		ll.ipLimiters.setOnIdle(fn)
+	}
+}
+
+// IPRegistryStats returns how many client IPs have a limiter
+// and how many were evicted
+func (ll *LimitedListener) IPRegistryStats() RegistryStats {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	return ll.ipLimiters.stats()
+}
+
+// SetIPv6Prefix sets how many leading bits of an IPv6 client address make
+// up its per-IP key (64 by default, 128 limits each address on its own)
+// so a client can't dodge its limit by hopping addresses within its prefix
+func (ll *LimitedListener) SetIPv6Prefix(bits int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.ipv6Prefix = bits
+}
+
+// SetGlobalLimiter makes connections accepted from now on share global,
+// which can also be given to other listeners and dialers to cap their
+// combined bandwidth. SetLimits then sets the limit of the shared budget.
+func (ll *LimitedListener) SetGlobalLimiter(global *GlobalLimiter) {
+	ll.mu.Lock()
+	ll.global = global
+	// a shared budget must fit the chunks of everyone using it
+	global.limiter.ensureBurst(ll.mtu)
+	budget, clock := ll.budget, ll.clock
+	ll.mu.Unlock()
+
+	if budget != nil {
+		budget.attach(global.limiter, global.Limit(), clock)
+	}
+}
+
+// GlobalLimiter returns the global budget new connections share
+func (ll *LimitedListener) GlobalLimiter() *GlobalLimiter {
+	return ll.getGlobal()
+}
+
+func (ll *LimitedListener) getGlobal() *GlobalLimiter {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	return ll.global
+}
+
+// SetGlobalWindow switches the global limit to interval accounting: no more
+// than limit bytes are sent across all connections in each consecutive
+// interval (eg. 100 MB every 10s), the global rate set by SetLimits stops
+// applying. A limit of 0 goes back to the global rate limiter.
+func (ll *LimitedListener) SetGlobalWindow(limit int, interval time.Duration) {
+	ll.getGlobal().SetWindow(limit, interval)
+}
+
+// SetClock replaces the time source used by interval based accounting,
+// it can be called at any time
+func (ll *LimitedListener) SetClock(clock Clock) {
+	ll.mu.Lock()
+	ll.clock = clock
+	global := ll.global
+	budget := ll.budget
+	ll.mu.Unlock()
+
+	global.window.setClock(clock)
+	if budget != nil {
+		budget.attach(global.limiter, global.Limit(), clock)
+	}
+}
+
+func (ll *LimitedListener) getClock() Clock {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	return ll.clock
+}
+
+// SetBudget starts accounting all traffic against a monthly egress
+// budget, allowing it to tighten the global limit. A nil budget stops it.
+func (ll *LimitedListener) SetBudget(budget *Budget) {
+	if budget != nil {
+		global := ll.getGlobal()
+		budget.attach(global.limiter, global.Limit(), ll.getClock())
+	}
+	ll.mu.Lock()
+	ll.budget = budget
+	ll.mu.Unlock()
+}
+
+func (ll *LimitedListener) getBudget() *Budget {
+	// dialed connections have no listener
+	if ll == nil {
+		return nil
+	}
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	return ll.budget
+}
+
+// SetTap mirrors the traffic of new connections (or a sample of them)
+// into tap, a nil tap stops mirroring new connections
+func (ll *LimitedListener) SetTap(tap *Tap) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.tap = tap
+}
+
+// SetCapture records the throttled traffic of all connections, including
+// the ones already running, into capture. A nil capture stops recording.
+func (ll *LimitedListener) SetCapture(capture *Capture) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.capture = capture
+}
+
+func (ll *LimitedListener) getCapture() *Capture {
+	// dialed connections have no listener
+	if ll == nil {
+		return nil
 	}
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	return ll.capture
+}
+
+// SetWriteQueue switches new connections to async write mode: Write
+// enqueues into a per-connection buffer of up to size bytes that is drained
+// by a pacing goroutine, policy decides what happens when it's full.
+// A size of 0 goes back to synchronous writes.
+func (ll *LimitedListener) SetWriteQueue(size int, policy WriteQueuePolicy) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.queueSize = size
+	ll.queuePolicy = policy
+}
+
+// SetCloseFlush makes closing new connections in async write mode flush
+// their queued writes for up to timeout instead of discarding them, 0
+// discards them. See LimitedConn.SetCloseFlush to change it per connection.
+func (ll *LimitedListener) SetCloseFlush(timeout time.Duration) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.closeFlush = timeout
 }
 
 // Accept creates a new limited connection that will throttle the
 // bandwidth both at a connection level and at aggregate that will depend
 // on how many connections are open
 func (ll *LimitedListener) Accept() (net.Conn, error) {
-	conn, err := ll.listener.Accept()
+	var backoff time.Duration
+	for {
+		start := time.Now()
+		conn, err := ll.listener.Accept()
+		if err != nil {
+			if ll.isShutdown() {
+				return nil, net.ErrClosed
+			}
+			if ll.retryAccept(err, &backoff) {
+				continue
+			}
+			return nil, err
+		}
+		ll.accept.observe(start)
+		backoff = 0
+		// the global limiter is saturated, refuse it
+		if ll.shedding() {
+			atomic.AddInt64(&ll.shed.refusedConns, 1)
+			conn.Close()
+			continue
+		}
+		lconn := ll.newConn(conn)
+		if fn := ll.getConnContext(); fn != nil {
+			lconn.SetContext(fn(context.Background(), lconn))
+		}
+		// TLS connections are classified before anything is written,
+		// without waiting on their handshake here
+		ll.deferTLS(&lconn)
+		// does the admission policy let it in?
+		if policy := ll.getPolicy(); policy != nil {
+			if !ll.decide(lconn, policy.Admit(lconn)) {
+				atomic.AddInt64(&ll.shed.refusedConns, 1)
+				ll.release(lconn)
+				abort(conn)
+				continue
+			}
+		}
+		ll.startQueue(&lconn)
+		// keep a pointer to the limited connection, unless
+		// the listener was closed in the meantime
+		ll.mu.Lock()
+		if ll.shutdown {
+			ll.mu.Unlock()
+			ll.release(lconn)
+			lconn.Close()
+			return nil, net.ErrClosed
+		}
+		ll.conns[lconn.id] = &lconn
+		ll.mu.Unlock()
+		ll.applyDSCP(lconn)
+		lconn.tuneBuffers()
+		ll.applyRules()
+		// pending handshakes advertise once done
+		if lconn.handshake == nil {
+			if err := ll.advertise(lconn); err != nil {
+				ll.CloseConnection(lconn)
+				continue
+			}
+		}
+		return lconn, nil
+	}
+}
+
+// newLimitedConn creates a connection with what every one of them has,
+// whoever makes it (listener, dialer, WrapConn or Stream), the rest is
+// for them to add
+func newLimitedConn(id int, conn net.Conn, global *GlobalLimiter, connLimiter *limiter, mtu int) LimitedConn {
+	return LimitedConn{
+		id:          id,
+		conn:        conn,
+		global:      global,
+		connLimiter: connLimiter,
+		mtu:         newConnMTU(mtu),
+		stats:       newConnStats(),
+		prio:        newPriorityGate(),
+		credit:      &burstCredit{},
+		ctx:         newConnContext(context.Background()),
+		closing:     newConnClosing(),
+		class:       &connClass{},
+	}
+}
+
+// newConn wraps an accepted connection
+func (ll *LimitedListener) newConn(conn net.Conn) LimitedConn {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
 	// each connection gets the global limiter and a per-connection one
 	conn_id := ll.n_conns
 	ll.n_conns++
-	lconn := LimitedConn{
-		id:            conn_id,
-		conn:          conn,
-		globalLimiter: ll.globalLimiter,
-		connLimiter:   rlimit.NewLimiter(rlimit.Limit(ll.connLimit), ll.mtu),
-		mtu:           ll.mtu,
+	lconn := newLimitedConn(conn_id, conn, ll.global,
+		ll.limiterPool.get(rlimit.Limit(ll.connLimit), ll.mtu), ll.mtu)
+	lconn.listener = ll
+	lconn.peek = newPeeker(conn)
+	lconn.shadow = &shadowLimiter{}
+	lconn.twoRate = &twoRateState{}
+	lconn.pacer = newPathPacer(ll.mtu)
+	lconn.readLimiter = ll.limiterPool.get(readLimit(ll.readConnLimit), ll.mtu)
+	lconn.control = ll.limiterPool.get(readLimit(ll.controlConnLimit), ll.mtu)
+	if ll.exemptions {
+		lconn.exempt = &exemption{}
+	}
+	if max := ll.getMaxGrant(); max > 0 {
+		lconn.connLimiter.ensureBurst(max)
 	}
-	// keep a pointer to the limited connection
-	ll.conns = append(ll.conns, &lconn)
-	return lconn, err
+	if ll.limiterPool != nil {
+		lconn.pooled = &pooledLimiters{
+			pool:     ll.limiterPool,
+			limiters: [3]*limiter{lconn.connLimiter, lconn.readLimiter, lconn.control},
+		}
+	}
+	lconn.shadow.set(ll.shadowConnLimit, ll.mtu)
+	if tr, ok := ll.twoRates[""]; ok {
+		lconn.twoRate.set(newTwoRateMeter(tr, ll.mtu))
+	}
+	if ll.sniffer != nil && ll.sniffN > 0 {
+		lconn.peek.sniffer = ll.sniffer
+		lconn.peek.sniffN = ll.sniffN
+	}
+	lconn.protocol = newConnProtocol(conn)
+	lconn.events = ll.newConnEvents(conn, ll.connLimit)
+	lconn.fastStart = newFastStart(ll.fastStartBytes, ll.fastStartLimit, ll.mtu)
+	lconn.short = newShortConn(ll.shortBytes)
+	lconn.accepted = ll.clock.Now()
+	lconn.schedule = &limitSchedule{}
+	lconn.tap = newConnTap(ll.tap)
+	lconn.recordOverhead = ll.recordOverhead
+	lconn.chunking = ll.chunking
+	lconn.yieldChunks = ll.yieldEvery
+	lconn.micro = newMicroPacer(ll.microPieces)
+	if ll.ipLimiters != nil {
+		lconn.key = ipKey(conn.RemoteAddr(), ll.ipv6Prefix)
+		lconn.keyLimiter = ll.ipLimiters.acquire(lconn.key)
+	}
+	return lconn
+}
+
+// startQueue puts a new connection in async write mode when
+// the listener says so
+func (ll *LimitedListener) startQueue(lconn *LimitedConn) {
+	ll.mu.Lock()
+	size, policy := ll.queueSize, ll.queuePolicy
+	flush := ll.closeFlush
+	ll.mu.Unlock()
+
+	if size > 0 {
+		lconn.queue = newWriteQueue(size, policy)
+		lconn.queue.flushTimeout = flush
+		go lconn.queue.drain(*lconn)
+	}
+}
+
+// release gives back the shared resources held by a connection
+func (ll *LimitedListener) release(lconn LimitedConn) {
+	if lconn.keyLimiter != nil {
+		ll.ipLimiters.release(lconn.key, lconn.keyLimiter)
+	}
+	lconn.events.finish()
+	lconn.schedule.replace(nil)
+}
+
+// connections returns a snapshot of the open connections
+func (ll *LimitedListener) connections() []*LimitedConn {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	conns := make([]*LimitedConn, 0, len(ll.conns))
+	for _, conn := range ll.conns {
+		conns = append(conns, conn)
+	}
+	// in accepting order
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].id < conns[j].id
+	})
+	return conns
 }
 
 // CloseConnection cleans up a specific connection, should be used
@@ -91,35 +698,217 @@ func (ll *LimitedListener) CloseConnection(conn net.Conn) {
 	// type conversion
 	lconn := conn.(LimitedConn)
 	conn.Close()
-	for i, c := range ll.conns {
-		if c.id == lconn.id {
-			// remove the entry from the slice
-			ll.conns[i] = ll.conns[len(ll.conns)-1]
-			ll.conns = ll.conns[:len(ll.conns)-1]
-			break
-		}
+	if ll.forget(lconn, CloseNormal) {
+		// the handler is done with it, unlike when the
+		// listener closes it under its feet
+		lconn.pooled.release()
 	}
-	ll.n_conns--
+}
+
+// forget releases a closed connection and stops tracking it,
+// false if it wasn't tracked anymore
+func (ll *LimitedListener) forget(lconn LimitedConn, reason CloseReason) bool {
+	ll.mu.Lock()
+	_, ok := ll.conns[lconn.id]
+	notifier := ll.closed
+	if ok {
+		delete(ll.conns, lconn.id)
+		// what it did stays in the totals
+		ll.closedStats.add(lconn.listenerStats())
+		ll.drain.forgot(len(ll.conns))
+	}
+	ll.mu.Unlock()
+	// only once, for connections that made it in: the handler
+	// closing one a cascading Close already did is harmless
+	if !ok {
+		return false
+	}
+	ll.release(lconn)
+	notifier.push(ConnClosedEvent{
+		ID:         lconn.id,
+		RemoteAddr: lconn.conn.RemoteAddr(),
+		Reason:     reason,
+		Time:       time.Now(),
+		Stats:      lconn.Stats(),
+	})
+	ll.applyRules()
+	return true
 }
 
 func (lc LimitedConn) waitN(ctx context.Context, n int) error {
-	// wait concurrently for both limiters to allow progress
+	// bulk data makes way for high priority writes
+	if !highPriority(ctx) {
+		if err := lc.prio.yield(ctx); err != nil {
+			return waitErr(ctx, err)
+		}
+	}
+	defer lc.traceRegion(ctx, "limlistener.wait")()
+	// exempted connections (ie. ACME challenges) go unthrottled
+	if lc.exempt.is() {
+		return nil
+	}
+	lc.probeShadow(n)
+	// in dry-run mode only account what the wait would have been
+	if lc.listener.dryRunning() {
+		if delay := lc.probeN(n); delay > 0 {
+			lc.stats.dryRun.add(n, delay)
+			lc.listener.dryRunStats.add(n, delay)
+		}
+		return nil
+	}
+	// control chunks are only limited by their own limiter
+	if controlWrite(ctx) {
+		return lc.waitControl(ctx, n)
+	}
+	// the first bytes of short connections go right away
+	if lc.short.take(n) {
+		lc.chargeShort(n)
+		lc.stats.wait.observe(0)
+		return nil
+	}
+	// burst credit and then fast start take what they
+	// can of the per-connection wait
+	covered := lc.credit.take(n)
+	fastN := lc.fastStart.take(n - covered)
+	plan := waitPlan{
+		n:       n,
+		covered: covered,
+		fastN:   fastN,
+		connN:   n - covered - fastN,
+		meter:   lc.twoRate.get(),
+		window:  lc.global.window.enabled(),
+		pacing:  lc.pacer != nil && lc.listener.pathPacingEnabled(),
+		custom:  lc.class.getCustom(),
+	}
+
+	// plain token buckets first take what they can grant right away,
+	// most writes don't have to wait and so don't allocate
+	var buckets [4]bucketWait
+	nb := 0
+	if plan.meter == nil {
+		if !plan.window {
+			buckets[nb] = bucketWait{lc.global.limiter, n}
+			nb++
+		}
+		if plan.connN > 0 {
+			buckets[nb] = bucketWait{lc.connLimiter, plan.connN}
+			nb++
+		}
+	}
+	if _, limiter := lc.class.get(); limiter != nil {
+		buckets[nb] = bucketWait{limiter, n}
+		nb++
+	}
+	if lc.keyLimiter != nil {
+		buckets[nb] = bucketWait{lc.keyLimiter, n}
+		nb++
+	}
+	pending := buckets[:0]
+	for _, b := range buckets[:nb] {
+		// unlimited ones hold nothing back, whatever their burst
+		if b.limiter.unlimited() {
+			continue
+		}
+		if !b.limiter.tryTake(b.n) {
+			pending = append(pending, b)
+		}
+	}
+
+	start := time.Now()
+	var err error
+	switch {
+	case len(pending) == 0 && plan.bucketsOnly():
+	case len(pending) == 1 && plan.bucketsOnly():
+		// a single bucket to wait on needs no goroutines
+		err = pending[0].limiter.WaitN(ctx, pending[0].n)
+	default:
+		err = lc.waitRest(ctx, plan, pending)
+	}
+	if err != nil {
+		err = waitErr(ctx, err)
+		lc.events.failed(n, err)
+		// the credit and fast start bytes weren't used
+		lc.GrantBurst(covered)
+		lc.fastStart.refund(fastN)
+		return err
+	}
+	waited := time.Since(start)
+	lc.stats.wait.observe(waited)
+	lc.events.waited(n, waited)
+	lc.accountProtocol(0, waited)
+	return nil
+}
+
+// waitPlan is what a wait has to go through besides the token buckets
+type waitPlan struct {
+	n int
+	// bytes covered by burst credit, fast start and the connection limit
+	covered, fastN, connN int
+	meter                 *twoRateMeter
+	window                bool
+	pacing                bool
+	custom                Limiter
+}
+
+// bucketsOnly tells if the token buckets are all there is to wait on
+func (p waitPlan) bucketsOnly() bool {
+	return p.meter == nil && !p.window && p.fastN == 0 && !p.pacing && p.custom == nil
+}
+
+// waitRest waits concurrently for everything in the plan to allow
+// progress, pending are the token buckets that couldn't grant right away
+func (lc LimitedConn) waitRest(ctx context.Context, p waitPlan, pending []bucketWait) error {
+	n, fastN, connN, meter := p.n, p.fastN, p.connN, p.meter
+	var waiters []func(context.Context, int) error
+	if meter != nil {
+		// two-rate limits replace both the connection and global waits,
+		// what the rest covers still waits on the global one
+		if connN > 0 {
+			waiters = append(waiters, func(ctx context.Context, _ int) error {
+				return meter.waitN(ctx, connN, lc.global)
+			})
+		}
+		if n > connN {
+			waiters = append(waiters, func(ctx context.Context, _ int) error {
+				return lc.global.waitN(ctx, n-connN)
+			})
+		}
+	} else if p.window {
+		waiters = append(waiters, lc.global.waitN)
+	}
+	for _, b := range pending {
+		b := b
+		waiters = append(waiters, func(ctx context.Context, _ int) error {
+			return b.limiter.WaitN(ctx, b.n)
+		})
+	}
+	if p.custom != nil {
+		waiters = append(waiters, p.custom.WaitN)
+	}
+	if fastN > 0 {
+		waiters = append(waiters, func(ctx context.Context, _ int) error {
+			return lc.fastStart.waitN(ctx, fastN)
+		})
+	}
+	if p.pacing {
+		waiters = append(waiters, func(ctx context.Context, n int) error {
+			return lc.pacer.waitN(ctx, n, lc.conn)
+		})
+	}
+
 	var wg sync.WaitGroup
-	done := make(chan error, 2)
+	done := make(chan error, len(waiters))
 
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		err := lc.connLimiter.WaitN(ctx, n)
-		done <- err
-	}()
-	go func() {
-		defer wg.Done()
-		err := lc.globalLimiter.WaitN(ctx, n)
-		done <- err
-	}()
+	wg.Add(len(waiters))
+	for _, wait := range waiters {
+		go func(wait func(context.Context, int) error) {
+			defer wg.Done()
+			err := wait(ctx, n)
+			done <- err
+		}(wait)
+	}
 
-	// wait on both goroutines to be done
+	// wait on all goroutines to be done
 	// then close the channel so ranging stops
 	go func() {
 		wg.Wait()
@@ -137,28 +926,78 @@ func (lc LimitedConn) waitN(ctx context.Context, n int) error {
 
 // Write asks permission for both global and per-connection rate limiters
 // before pushing down MTU worth of bytes down the pipe.
+// In async write mode it only enqueues b and returns right away.
+// Writes the token bucket limiters grant right away don't allocate, see
+// TestWriteAllocs.
 func (lc LimitedConn) Write(b []byte) (n int, err error) {
+	if lc.queue != nil {
+		return lc.push(b)
+	}
+	return lc.write(b)
+}
+
+func (lc LimitedConn) write(b []byte) (n int, err error) {
+	// closing the connection or the write deadline
+	// ends any wait on the limiters
+	ctx, cancel := lc.closing.writeContext()
+	defer cancel()
+	return lc.writeContext(ctx, b)
+}
+
+func (lc LimitedConn) writeContext(ctx context.Context, b []byte) (n int, err error) {
+	if err := lc.finishHandshake(); err != nil {
+		return 0, err
+	}
 	n = 0
-	ctx := context.Background()
+	lc.stats.writeStarted()
+	defer lc.stats.writeDone()
+	ctx, end := lc.traceTask(ctx, "limlistener.Write")
+	defer end()
+	if lc.chunking == ChunkWhole {
+		return lc.writeWhole(ctx, b)
+	}
+	if ft := lc.listener.flushTicker(); ft != nil && !controlWrite(ctx) {
+		return lc.writeCoalesced(ctx, b, ft)
+	}
+	atomic.AddInt32(&lc.global.writers, 1)
+	defer atomic.AddInt32(&lc.global.writers, -1)
+	yields := yieldCounter{every: lc.yieldEvery(ctx)}
 	// while there's still something to write
 	for len(b) > 0 {
+		if yields.due() {
+			lc.yield(lc.chunkSize())
+		}
 		var s []byte
-		// pop the first MTU bytes
+		// pop the first chunk of bytes (or as many as a grant takes),
+		// any per-chunk overhead is charged within it
+		size := lc.chunkSize() - lc.recordOverhead
+		granted := lc.grantChunks(len(b), size)
+		yields.sent(granted)
 		s = b
-		if len(b) > lc.mtu {
-			s = b[:lc.mtu]
+		if len(b) > size*granted {
+			s = b[:size*granted]
 		}
-		// move slice past MTU
+		// move slice past the chunk
 		b = b[len(s):]
 
 		// get permission to write it
-		err = lc.waitN(ctx, len(s))
+		start := time.Now()
+		overhead := (len(s) + size - 1) / size * lc.recordOverhead
+		err = lc.waitN(ctx, len(s)+overhead)
 		if err != nil {
+			lc.shedWait(err, len(s)+len(b))
 			return 0, err
 		}
 
 		// push it down the pipe
-		w, err := lc.conn.Write(s)
+		var w int
+		if granted > 1 {
+			w, err = lc.sendChunks(ctx, s, size)
+		} else if lc.micro != nil {
+			w, err = lc.sendPaced(ctx, s, lc.recordOverhead, lc.micro.grant(time.Since(start)))
+		} else {
+			w, err = lc.send(ctx, s, lc.recordOverhead)
+		}
 		if err != nil {
 			return 0, err
 		}
@@ -168,35 +1007,190 @@ func (lc LimitedConn) Write(b []byte) (n int, err error) {
 	return n, err
 }
 
+// send pushes s down the pipe accounting for it, overhead are the
+// extra bytes charged to the budget on top of the payload
+func (lc LimitedConn) send(ctx context.Context, s []byte, overhead int) (int, error) {
+	defer lc.traceRegion(ctx, "limlistener.send")()
+	start := time.Now()
+	w, err := lc.conn.Write(s)
+	lc.stats.write.observe(time.Since(start))
+	atomic.AddInt64(&lc.stats.wireBytes, int64(w))
+	lc.accountProtocol(w, 0)
+	// an inactive connection counts again
+	if last := lc.stats.touch(); lc.listener.resumed(last) {
+		lc.listener.applyRules()
+	}
+	if budget := lc.listener.getBudget(); budget != nil && w > 0 {
+		budget.charge(w + overhead)
+	}
+	if lc.tap != nil && w > 0 {
+		lc.tap.mirror(lc.id, s[:w])
+	}
+	if capture := lc.listener.getCapture(); capture != nil && w > 0 {
+		capture.Record(lc.id, s[:w])
+	}
+	return w, err
+}
+
+// SetLimit sets a new per-connection limit, recorded in the audit trail
 func (lc *LimitedConn) SetLimit(limit int) {
+	lc.SetLimitBy("", limit)
+}
+
+func (lc *LimitedConn) setLimit(limit int) {
 	// had we already created a rate limiter?
 	if lc.connLimiter == nil {
-		lc.connLimiter = rlimit.NewLimiter(rlimit.Limit(limit), lc.mtu)
+		lc.connLimiter = newLimiter(rlimit.Limit(limit), lc.mtu.get())
 	}
 	// set the new limit
-	lc.connLimiter.SetLimit(rlimit.Limit(limit))
+	lc.connLimiter.setLimitSmoothed(rlimit.Limit(limit), lc.listener.limitTransition(), lc.mtu.get())
+	lc.events.limitChanged(limit)
+	lc.tuneBuffers()
 }
 
-// Close calls to net.Listener.Close()
-func (ll LimitedListener) Close() error {
-	return ll.listener.Close()
+// LimiterState returns the current state of the connection token bucket
+func (lc LimitedConn) LimiterState() LimiterState {
+	return lc.connLimiter.State()
+}
+
+// Close calls to net.Listener.Close(), it also stops any admission
+// policy reviews, saturation sampling, flush ticks, inactivity checks
+// and close events. The connections still open are left alone unless
+// SetCloseMode says otherwise. Closing it again returns net.ErrClosed.
+func (ll *LimitedListener) Close() error {
+	ll.mu.Lock()
+	if ll.shutdown {
+		ll.mu.Unlock()
+		return net.ErrClosed
+	}
+	ll.shutdown = true
+	ll.mu.Unlock()
+
+	// stop accepting first, then see to the connections left
+	err := ll.listener.Close()
+	ll.cascadeClose()
+
+	ll.mu.Lock()
+	if ll.policyStop != nil {
+		close(ll.policyStop)
+		ll.policyStop = nil
+	}
+	if ll.saturation != nil {
+		close(ll.saturation.stop)
+		ll.saturation = nil
+	}
+	if ll.flush != nil {
+		close(ll.flush.stop)
+		ll.flush = nil
+	}
+	if ll.inactiveStop != nil {
+		close(ll.inactiveStop)
+		ll.inactiveStop = nil
+	}
+	if ll.closed != nil {
+		close(ll.closed.stop)
+		ll.closed = nil
+	}
+	ll.mu.Unlock()
+	return err
 }
 
 // Addr calls to net.Listener.Addr()
-func (ll LimitedListener) Addr() net.Addr {
+func (ll *LimitedListener) Addr() net.Addr {
 	return ll.listener.Addr()
 }
 
-// Read calls to net.Conn.Read()
+// ID returns the connection id
+func (lc LimitedConn) ID() int {
+	return lc.id
+}
+
+// Read calls to net.Conn.Read(), returning first
+// whatever was peeked at
 func (lc LimitedConn) Read(b []byte) (n int, err error) {
-	return lc.conn.Read(b)
+	if err := lc.finishHandshake(); err != nil {
+		return 0, err
+	}
+	if lc.peek == nil {
+		if lc.chargeReads {
+			n, err = lc.chargedRead(b)
+		} else {
+			n, err = lc.conn.Read(b)
+		}
+	} else {
+		lc.runSniffer()
+		if lc.readThrottled() {
+			n, err = lc.throttledRead(b)
+		} else {
+			n, err = lc.peek.read(b)
+		}
+	}
+	if n > 0 {
+		lc.stats.touch()
+	}
+	return n, err
 }
 
-// Close calls to net.Conn.Close()
+// Close calls to net.Conn.Close(), any writes still queued in async
+// write mode are discarded (or flushed first, see SetCloseFlush) and writes
+// waiting on the limiters fail right away. Closing it again, from any copy,
+// returns net.ErrClosed.
 func (lc LimitedConn) Close() error {
+	if !lc.closing.start() {
+		return net.ErrClosed
+	}
+	if lc.queue != nil {
+		if timeout := lc.queue.getFlushTimeout(); timeout > 0 {
+			return lc.closeWithTimeout(timeout)
+		}
+		lc.queue.close()
+	}
+	lc.closing.cancel()
 	return lc.conn.Close()
 }
 
+// CloseWithTimeout closes the connection after flushing its queued writes
+// for up to timeout (in async write mode), failing with ErrFlushTimeout if
+// they couldn't all go out by then. The connection is closed either way.
+func (lc LimitedConn) CloseWithTimeout(timeout time.Duration) error {
+	if !lc.closing.start() {
+		return net.ErrClosed
+	}
+	return lc.closeWithTimeout(timeout)
+}
+
+func (lc LimitedConn) closeWithTimeout(timeout time.Duration) error {
+	var err error
+	if lc.queue != nil {
+		err = lc.queue.flush(timeout)
+	}
+	// the flush is over, the waits left are for nothing
+	lc.closing.cancel()
+	if cerr := lc.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// SetCloseFlush makes Close flush the connection's queued writes for up to
+// timeout instead of discarding them, 0 discards them. Only applies in
+// async write mode.
+func (lc LimitedConn) SetCloseFlush(timeout time.Duration) {
+	if lc.queue != nil {
+		lc.queue.setFlushTimeout(timeout)
+	}
+}
+
+// CloseWrite shuts down the writing side of the underlying connection
+// when it supports half-close (eg. *net.TCPConn)
+func (lc LimitedConn) CloseWrite() error {
+	cw, ok := lc.conn.(closeWriter)
+	if !ok {
+		return errors.New("limlistener: connection does not support half-close")
+	}
+	return cw.CloseWrite()
+}
+
 // LocalAddr calls to net.Conn.LocalAddr()
 func (lc LimitedConn) LocalAddr() net.Addr {
 	return lc.conn.LocalAddr()
@@ -207,17 +1201,23 @@ func (lc LimitedConn) RemoteAddr() net.Addr {
 	return lc.conn.RemoteAddr()
 }
 
-// SetDeadline calls to net.Conn.SetDeadline()
+// SetDeadline calls to net.Conn.SetDeadline(), reads and writes waiting
+// on the limiters give up at t as well
 func (lc LimitedConn) SetDeadline(t time.Time) error {
+	lc.closing.setDeadlines(true, true, t)
 	return lc.conn.SetDeadline(t)
 }
 
-// SetReadDeadline calls to net.Conn.SetReadDeadline()
+// SetReadDeadline calls to net.Conn.SetReadDeadline(), reads waiting on
+// the read limiters give up at t as well
 func (lc LimitedConn) SetReadDeadline(t time.Time) error {
+	lc.closing.setDeadlines(true, false, t)
 	return lc.conn.SetReadDeadline(t)
 }
 
-// SetWriteDeadline calls to net.Conn.SetWriteDeadline()
+// SetWriteDeadline calls to net.Conn.SetWriteDeadline(), writes waiting
+// on the limiters give up at t as well
 func (lc LimitedConn) SetWriteDeadline(t time.Time) error {
+	lc.closing.setDeadlines(false, true, t)
 	return lc.conn.SetWriteDeadline(t)
 }
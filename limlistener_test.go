@@ -0,0 +1,257 @@
+package limlistener
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestListener returns a listener on a loopback port,
+// closed when the test is done
+func newTestListener(t testing.TB) *LimitedListener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll := NewWithListener(l)
+	t.Cleanup(func() { ll.Close() })
+	return &ll
+}
+
+// acceptPair dials ll and returns the accepted connection along with
+// the client end, both closed when the test is done
+func acceptPair(t testing.TB, ll *LimitedListener) (LimitedConn, net.Conn) {
+	t.Helper()
+	dialed := make(chan net.Conn, 1)
+	go func() {
+		c, err := net.Dial("tcp", ll.Addr().String())
+		if err != nil {
+			dialed <- nil
+			return
+		}
+		dialed <- c
+	}()
+	conn, err := ll.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := <-dialed
+	if client == nil {
+		t.Fatal("dial failed")
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		client.Close()
+	})
+	return conn.(LimitedConn), client
+}
+
+// acceptDrained is acceptPair with whatever is written to the
+// connection being read and discarded by the client
+func acceptDrained(t testing.TB, ll *LimitedListener) LimitedConn {
+	t.Helper()
+	conn, client := acceptPair(t, ll)
+	go io.Copy(ioutil.Discard, client)
+	return conn
+}
+
+// pipeListener accepts in-memory connections, as many as asked for
+// without running out of file descriptors
+type pipeListener struct {
+	mu      sync.Mutex
+	clients []net.Conn
+	closed  bool
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, net.ErrClosed
+	}
+	server, client := net.Pipe()
+	l.clients = append(l.clients, client)
+	return server, nil
+}
+
+func (l *pipeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for _, c := range l.clients {
+		c.Close()
+	}
+	l.clients = nil
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "pipe"}
+}
+
+func TestSetWriteQueueWhileAccepting(t *testing.T) {
+	ll := newTestListener(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ll.SetWriteQueue(i%2*4096, QueueBlock)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		conn := acceptDrained(t, ll)
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		ll.CloseConnection(conn)
+	}
+	<-done
+}
+
+func TestSetCloseFlushWhileAccepting(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetWriteQueue(4096, QueueBlock)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ll.SetCloseFlush(time.Duration(i%2) * time.Second)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		conn := acceptDrained(t, ll)
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		ll.CloseConnection(conn)
+	}
+	<-done
+}
+
+func TestSetClockWhileAccepting(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetBudget(NewBudget(0.09, 1000))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ll.SetClock(NewScaledClock(time.Now(), float64(i+1)))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		conn := acceptDrained(t, ll)
+		conn.SetLimit(1 << 20)
+		ll.CloseConnection(conn)
+	}
+	<-done
+}
+
+func BenchmarkAcceptClose(b *testing.B) {
+	for _, open := range []int{0, 1000, 50000} {
+		b.Run(fmt.Sprintf("open=%d", open), func(b *testing.B) {
+			ll := NewWithListener(&pipeListener{})
+			defer ll.Close()
+			// connections staying open the whole time
+			for i := 0; i < open; i++ {
+				if _, err := ll.Accept(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				conn, err := ll.Accept()
+				if err != nil {
+					b.Fatal(err)
+				}
+				ll.CloseConnection(conn)
+			}
+		})
+	}
+}
+
+// classifyAll is an admission policy putting every connection in a class
+type classifyAll string
+
+func (c classifyAll) Admit(LimitedConn) Decision {
+	return Decision{Class: string(c)}
+}
+
+func (c classifyAll) Review(LimitedConn) Decision {
+	return Decision{}
+}
+
+// writeConfigs are the configurations the zero allocations fast path has
+// to hold for, limits high enough for every write to be granted right away
+var writeConfigs = []struct {
+	name  string
+	setup func(ll *LimitedListener)
+	// bytes per Write
+	size int
+}{
+	{"unlimited", func(ll *LimitedListener) {}, 512},
+	{"limits", func(ll *LimitedListener) {
+		ll.SetLimits(1<<30, 1<<30)
+	}, 512},
+	{"class and ip limits", func(ll *LimitedListener) {
+		ll.SetLimits(1<<30, 1<<30)
+		ll.SetIPLimit(1 << 30)
+		ll.SetClass("bulk", 1<<30, 1<<30)
+		ll.SetAdmissionPolicy(classifyAll("bulk"), 0)
+	}, 512},
+	{"grants", func(ll *LimitedListener) {
+		ll.SetMaxGrant(64 << 10)
+	}, 16 << 10},
+}
+
+func TestWriteAllocs(t *testing.T) {
+	for _, cfg := range writeConfigs {
+		t.Run(cfg.name, func(t *testing.T) {
+			ll := newTestListener(t)
+			cfg.setup(ll)
+			conn := acceptDrained(t, ll)
+			b := make([]byte, cfg.size)
+			var werr error
+			allocs := testing.AllocsPerRun(1000, func() {
+				if _, err := conn.Write(b); err != nil {
+					werr = err
+				}
+			})
+			if werr != nil {
+				t.Fatal(werr)
+			}
+			if allocs > 0 {
+				t.Errorf("%.1f allocs/Write, want none", allocs)
+			}
+		})
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	for _, cfg := range writeConfigs {
+		b.Run(cfg.name, func(b *testing.B) {
+			ll := newTestListener(b)
+			cfg.setup(ll)
+			conn := acceptDrained(b, ll)
+			p := make([]byte, cfg.size)
+			b.SetBytes(int64(len(p)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write(p); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+// Package limsim reproduces field conditions on a pipe pair: a Scenario
+// describes each direction's rate, latency, jitter and loss on its own (a
+// fast downlink over a slow uplink, say) along with a schedule of changes to
+// them over time, and Run applies it in between the two ends of a pipe. It's
+// meant for exercising limited listeners and their clients against
+// conditions seen in the field, declared once in Go or JSON:
+//
+//	{
+//		"name": "congested uplink",
+//		"a_to_b": {"rate": 1250000, "latency": "20ms"},
+//		"b_to_a": {"rate": 62500, "latency": "20ms", "jitter": "10ms", "loss": 0.01},
+//		"steps": [
+//			{"at": "10s", "b_to_a": {"rate": 12500, "latency": "200ms", "loss": 0.05}},
+//			{"at": "30s", "b_to_a": {"rate": 62500, "latency": "20ms"}}
+//		]
+//	}
+//
+// Pipes are reliable streams, as TCP ones are, so a lost chunk isn't
+// dropped: it's retransmitted, arriving RetransmitDelay later and holding up
+// everything behind it.
+package limsim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+
+	"github.com/lrascao/limlistener"
+)
+
+// chunkSize is how much a link serializes at once, about a packet
+const chunkSize = 1500
+
+// inFlight is how many chunks a link holds before backpressuring the writer
+const inFlight = 4096
+
+// DefaultRetransmitDelay is how late a lost chunk arrives
+// when the direction doesn't say
+const DefaultRetransmitDelay = 200 * time.Millisecond
+
+// Duration is a time.Duration reading "50ms" style strings out of JSON,
+// as well as nanoseconds
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration string or nanoseconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v)
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("limsim: invalid duration %s", b)
+	}
+	return nil
+}
+
+// Direction are the conditions bytes going one way are subject to
+type Direction struct {
+	// 0 for no limit
+	Rate limlistener.BytesPerSecond `json:"rate,omitempty"`
+	// one way delay, plus up to Jitter more at random
+	Latency Duration `json:"latency,omitempty"`
+	Jitter  Duration `json:"jitter,omitempty"`
+	// probability of a chunk being lost, and how late it arrives then
+	Loss            float64  `json:"loss,omitempty"`
+	RetransmitDelay Duration `json:"retransmit_delay,omitempty"`
+}
+
+// Step changes the conditions At a time into the scenario,
+// a nil direction is left as it is
+type Step struct {
+	At   Duration   `json:"at"`
+	AtoB *Direction `json:"a_to_b,omitempty"`
+	BtoA *Direction `json:"b_to_a,omitempty"`
+}
+
+// Scenario describes the conditions in between the two ends of a pipe,
+// each direction on its own, and how they change over time
+type Scenario struct {
+	Name string    `json:"name,omitempty"`
+	AtoB Direction `json:"a_to_b"`
+	BtoA Direction `json:"b_to_a"`
+	// in order of At
+	Steps []Step `json:"steps,omitempty"`
+	// seeds jitter and loss so runs are reproducible, 0 for a random one
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// Load reads a scenario out of JSON and validates it
+func Load(r io.Reader) (Scenario, error) {
+	var s Scenario
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return Scenario{}, err
+	}
+	if err := s.Validate(); err != nil {
+		return Scenario{}, err
+	}
+	return s, nil
+}
+
+// Validate checks the scenario makes sense
+func (s Scenario) Validate() error {
+	check := func(where string, d Direction) error {
+		switch {
+		case d.Rate < 0:
+			return fmt.Errorf("limsim: %s: negative rate %d", where, d.Rate)
+		case d.Latency < 0 || d.Jitter < 0 || d.RetransmitDelay < 0:
+			return fmt.Errorf("limsim: %s: negative delay", where)
+		case d.Loss < 0 || d.Loss > 1:
+			return fmt.Errorf("limsim: %s: loss of %v isn't a probability", where, d.Loss)
+		}
+		return nil
+	}
+	if err := check("a_to_b", s.AtoB); err != nil {
+		return err
+	}
+	if err := check("b_to_a", s.BtoA); err != nil {
+		return err
+	}
+	for i, step := range s.Steps {
+		where := fmt.Sprintf("step %d", i)
+		if i > 0 && step.At < s.Steps[i-1].At {
+			return fmt.Errorf("limsim: %s: at %v is before the previous step", where, time.Duration(step.At))
+		}
+		if step.AtoB != nil {
+			if err := check(where+" a_to_b", *step.AtoB); err != nil {
+				return err
+			}
+		}
+		if step.BtoA != nil {
+			if err := check(where+" b_to_a", *step.BtoA); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Pipe is a running scenario, what's written to A comes out of B subject
+// to the AtoB conditions and the other way around
+type Pipe struct {
+	A, B net.Conn
+
+	atob, btoa *link
+	start      time.Time
+	cancel     context.CancelFunc
+	closers    []net.Conn
+	wg         sync.WaitGroup
+	once       sync.Once
+}
+
+// Run starts the scenario on a new pipe pair, its schedule
+// starts ticking right away
+func Run(s Scenario) (*Pipe, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	seed := s.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a, aInner := net.Pipe()
+	b, bInner := net.Pipe()
+	p := &Pipe{
+		A:       a,
+		B:       b,
+		atob:    newLink(s.AtoB, seed),
+		btoa:    newLink(s.BtoA, seed+1),
+		start:   time.Now(),
+		cancel:  cancel,
+		closers: []net.Conn{a, aInner, b, bInner},
+	}
+	p.wg.Add(3)
+	go p.atob.run(ctx, &p.wg, aInner, bInner)
+	go p.btoa.run(ctx, &p.wg, bInner, aInner)
+	go p.schedule(ctx, s.Steps)
+	return p, nil
+}
+
+// Conditions returns the conditions each direction is subject to now
+func (p *Pipe) Conditions() (atob, btoa Direction) {
+	return p.atob.get(), p.btoa.get()
+}
+
+// Elapsed returns how long the scenario has been running for
+func (p *Pipe) Elapsed() time.Duration {
+	return time.Since(p.start)
+}
+
+// Close stops the scenario and closes both ends, bytes still in
+// flight are lost
+func (p *Pipe) Close() error {
+	p.once.Do(func() {
+		p.cancel()
+		for _, c := range p.closers {
+			c.Close()
+		}
+		p.wg.Wait()
+	})
+	return nil
+}
+
+// schedule applies the steps at their time
+func (p *Pipe) schedule(ctx context.Context, steps []Step) {
+	defer p.wg.Done()
+
+	// validated to be in order
+	for _, step := range steps {
+		t := time.NewTimer(time.Until(p.start.Add(time.Duration(step.At))))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		if step.AtoB != nil {
+			p.atob.set(*step.AtoB)
+		}
+		if step.BtoA != nil {
+			p.btoa.set(*step.BtoA)
+		}
+	}
+}
+
+// link carries the bytes of one direction
+type link struct {
+	mu   sync.Mutex
+	cond Direction
+	// serialization at the link's rate
+	limiter *rlimit.Limiter
+	// only used by the goroutine reading
+	rand *rand.Rand
+}
+
+// chunk is a piece of the stream and when it's due at the other end
+type chunk struct {
+	data []byte
+	due  time.Time
+}
+
+func newLink(cond Direction, seed int64) *link {
+	l := &link{
+		limiter: rlimit.NewLimiter(rlimit.Inf, chunkSize),
+		rand:    rand.New(rand.NewSource(seed)),
+	}
+	l.set(cond)
+	return l
+}
+
+func (l *link) get() Direction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.cond
+}
+
+func (l *link) set(cond Direction) {
+	l.mu.Lock()
+	l.cond = cond
+	l.mu.Unlock()
+
+	if cond.Rate > 0 {
+		l.limiter.SetLimit(rlimit.Limit(cond.Rate))
+	} else {
+		l.limiter.SetLimit(rlimit.Inf)
+	}
+}
+
+// run moves bytes from src to dst until src is done, closing dst after
+// the last of them so the other end sees it too
+func (l *link) run(ctx context.Context, wg *sync.WaitGroup, src, dst net.Conn) {
+	defer wg.Done()
+
+	chunks := make(chan chunk, inFlight)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.deliver(ctx, chunks, dst)
+	}()
+
+	var last time.Time
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			// serialization first, then the trip across
+			if l.limiter.WaitN(ctx, n) != nil {
+				break
+			}
+			due := time.Now().Add(l.delay())
+			// a stream arrives in order, late chunks hold up the rest
+			if due.Before(last) {
+				due = last
+			}
+			last = due
+			select {
+			case chunks <- chunk{data: append([]byte(nil), buf[:n]...), due: due}:
+			case <-done:
+				// dst went away, nowhere to put them
+			case <-ctx.Done():
+			}
+		}
+		if err != nil || ctx.Err() != nil {
+			break
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+	close(chunks)
+	<-done
+	dst.Close()
+}
+
+// delay returns how long the next chunk takes to cross
+func (l *link) delay() time.Duration {
+	cond := l.get()
+	d := time.Duration(cond.Latency)
+	if cond.Jitter > 0 {
+		d += time.Duration(l.rand.Int63n(int64(cond.Jitter)))
+	}
+	if cond.Loss > 0 && l.rand.Float64() < cond.Loss {
+		retransmit := time.Duration(cond.RetransmitDelay)
+		if retransmit == 0 {
+			retransmit = DefaultRetransmitDelay
+		}
+		d += retransmit
+	}
+	return d
+}
+
+// deliver writes every chunk to dst once it's due
+func (l *link) deliver(ctx context.Context, chunks <-chan chunk, dst net.Conn) {
+	for c := range chunks {
+		if wait := time.Until(c.due); wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+		if _, err := dst.Write(c.data); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package limlistener
+
+import (
+	"errors"
+	"net"
+)
+
+// lingerer are connections whose close behaviour can be tuned
+// (eg. *net.TCPConn)
+type lingerer interface {
+	SetLinger(sec int) error
+}
+
+// SetLinger sets how the underlying connection behaves on close with data
+// still unsent or unacknowledged, see net.TCPConn.SetLinger
+func (lc LimitedConn) SetLinger(sec int) error {
+	l, ok := lc.conn.(lingerer)
+	if !ok {
+		return errors.New("limlistener: connection does not support linger")
+	}
+	return l.SetLinger(sec)
+}
+
+// AbortiveClose closes the connection right away, discarding anything
+// queued and, when the underlying connection supports it, resetting it
+// (TCP RST) instead of waiting for the unsent data to drain at the limited
+// rate, for connections that are evicted or not welcome anymore
+func (lc LimitedConn) AbortiveClose() error {
+	if !lc.closing.start() {
+		return net.ErrClosed
+	}
+	if lc.queue != nil {
+		lc.queue.close()
+	}
+	lc.closing.cancel()
+	return abort(lc.conn)
+}
+
+// abort resets conn when it supports it and closes it
+func abort(conn net.Conn) error {
+	if l, ok := conn.(lingerer); ok {
+		// best effort, it's closed anyway
+		_ = l.SetLinger(0)
+	}
+	return conn.Close()
+}
+
+// AbortConnection cleans up a specific connection like CloseConnection
+// does, abortively closing it (see LimitedConn.AbortiveClose)
+func (ll *LimitedListener) AbortConnection(conn net.Conn) {
+	lconn := conn.(LimitedConn)
+	lconn.AbortiveClose()
+	if ll.forget(lconn, CloseAborted) {
+		lconn.pooled.release()
+	}
+}
@@ -0,0 +1,39 @@
+package limlistener
+
+import (
+	"context"
+	"fmt"
+)
+
+// WriteMessage writes b as a single message: its whole size is reserved
+// at once on every limiter (waiting for the buckets to have room for all of
+// it) and then written in a single call, so its boundaries are never
+// interleaved with other writers of a shared downstream. The message plus
+// any per-chunk overhead must fit the smallest burst of the limiters that
+// have a limit (see SetMTU).
+// It's always synchronous, even in async write mode.
+func (lc LimitedConn) WriteMessage(b []byte) (int, error) {
+	if err := lc.finishHandshake(); err != nil {
+		return 0, err
+	}
+	lc.stats.writeStarted()
+	defer lc.stats.writeDone()
+	ctx, end := lc.traceTask(context.Background(), "limlistener.WriteMessage")
+	defer end()
+	size := len(b) + lc.recordOverhead
+	for _, l := range lc.limiters() {
+		// unlimited ones aren't waited on
+		if burst := l.Burst(); size > burst && !l.unlimited() {
+			return 0, fmt.Errorf("%w: message of %d bytes with a burst of %d", ErrBurstExceeded, size, burst)
+		}
+	}
+	if err := lc.waitN(ctx, size); err != nil {
+		lc.shedWait(err, len(b))
+		return 0, err
+	}
+	w, err := lc.send(ctx, b, lc.recordOverhead)
+	if err != nil {
+		return 0, err
+	}
+	return w, nil
+}
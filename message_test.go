@@ -0,0 +1,69 @@
+package limlistener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// readMessage reads n bytes out of c in the background
+func readMessage(c net.Conn, n int) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(c, make([]byte, n))
+		done <- err
+	}()
+	return done
+}
+
+func TestWriteMessageUnlimited(t *testing.T) {
+	ll := newTestListener(t)
+	conn, client := acceptPair(t, ll)
+
+	read := readMessage(client, 4096)
+	if n, err := conn.WriteMessage(make([]byte, 4096)); err != nil || n != 4096 {
+		t.Fatalf("WriteMessage = %d, %v on an unlimited listener", n, err)
+	}
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteMessageWrapped(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := WrapConn(server, ConnOptions{})
+	defer conn.Close()
+
+	read := readMessage(client, 4096)
+	if n, err := conn.WriteMessage(make([]byte, 4096)); err != nil || n != 4096 {
+		t.Fatalf("WriteMessage = %d, %v on an unlimited wrapped connection", n, err)
+	}
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteMessageBurst(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetLimits(1<<20, 1<<20)
+	conn, client := acceptPair(t, ll)
+
+	// a limited bucket can't ever fit it
+	if _, err := conn.WriteMessage(make([]byte, 4096)); !errors.Is(err, ErrBurstExceeded) {
+		t.Fatalf("WriteMessage over the burst = %v, want ErrBurstExceeded", err)
+	}
+	if err := ll.SetMTU(4096); err != nil {
+		t.Fatal(err)
+	}
+	// new connections get a per-connection bucket that fits it
+	conn, client = acceptPair(t, ll)
+	read := readMessage(client, 4096)
+	if n, err := conn.WriteMessage(make([]byte, 4096)); err != nil || n != 4096 {
+		t.Fatalf("WriteMessage = %d, %v with the burst raised", n, err)
+	}
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,98 @@
+package limlistener
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// waits shorter than this didn't really hold the chunk back
+	microPacingMinWait = time.Millisecond
+	// weight of the latest interval between grants in the average
+	microPacingAlpha = 0.25
+)
+
+// SetMicroPacing splits every chunk granted to new connections into pieces
+// smaller writes spread over the interval grants usually come at, so at low
+// limits (eg. 8 KB/s with a 1 KB MTU) bytes trickle out instead of pulsing
+// every 125ms. Chunks granted right away are written at once. A value below
+// 2 turns it off.
+func (ll *LimitedListener) SetMicroPacing(pieces int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.microPieces = pieces
+}
+
+// microPacer keeps an exponentially weighted average of the
+// interval between the grants of a connection
+type microPacer struct {
+	mu       sync.Mutex
+	pieces   int
+	last     time.Time
+	interval time.Duration
+}
+
+func newMicroPacer(pieces int) *microPacer {
+	if pieces < 2 {
+		return nil
+	}
+	return &microPacer{pieces: pieces}
+}
+
+// grant records a chunk being granted after waiting for it, returning how
+// far apart its pieces have to be written, 0 to write it at once
+func (mp *microPacer) grant(waited time.Duration) time.Duration {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	now := time.Now()
+	last := mp.last
+	mp.last = now
+	// the limiters didn't hold it back, nothing to smooth
+	if waited < microPacingMinWait || last.IsZero() {
+		return 0
+	}
+	d := now.Sub(last)
+	if mp.interval == 0 {
+		mp.interval = d
+	} else {
+		mp.interval += time.Duration(microPacingAlpha * float64(d-mp.interval))
+	}
+	return mp.interval / time.Duration(mp.pieces)
+}
+
+// sendPaced writes a granted chunk in pieces step apart
+func (lc LimitedConn) sendPaced(ctx context.Context, s []byte, overhead int, step time.Duration) (int, error) {
+	if step <= 0 {
+		return lc.send(ctx, s, overhead)
+	}
+	size := (len(s) + lc.micro.pieces - 1) / lc.micro.pieces
+	n := 0
+	for len(s) > 0 {
+		p := s
+		if len(p) > size {
+			p = p[:size]
+		}
+		s = s[len(p):]
+		// the overhead is charged with the first piece
+		w, err := lc.send(ctx, p, overhead)
+		overhead = 0
+		n += w
+		if err != nil {
+			return n, err
+		}
+		if len(s) == 0 {
+			break
+		}
+		timer := time.NewTimer(step)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return n, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return n, nil
+}
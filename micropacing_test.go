@@ -0,0 +1,56 @@
+package limlistener
+
+import (
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMicroPacerInterval(t *testing.T) {
+	if mp := newMicroPacer(1); mp != nil {
+		t.Error("micro-pacing a chunk in a single piece")
+	}
+	mp := newMicroPacer(4)
+	if step := mp.grant(100 * time.Millisecond); step != 0 {
+		t.Errorf("first grant paced %v apart, want written at once", step)
+	}
+	// grants 100ms apart, in 4 pieces
+	mp.last = time.Now().Add(-100 * time.Millisecond)
+	if step := mp.grant(100 * time.Millisecond); step < 25*time.Millisecond || step > 27*time.Millisecond {
+		t.Errorf("pieces paced %v apart, want 25ms", step)
+	}
+	// a grant 200ms later moves the average a quarter of the way there
+	mp.last = time.Now().Add(-200 * time.Millisecond)
+	if step := mp.grant(200 * time.Millisecond); step < 31*time.Millisecond || step > 33*time.Millisecond {
+		t.Errorf("pieces paced %v apart, want 31.25ms", step)
+	}
+	// one the limiters didn't hold back goes out at once
+	if step := mp.grant(0); step != 0 {
+		t.Errorf("chunk granted right away paced %v apart, want written at once", step)
+	}
+}
+
+func TestMicroPacingSplitsChunks(t *testing.T) {
+	l := &countingListener{Listener: listen(t)}
+	ll := NewWithListener(l)
+	defer ll.Close()
+	ll.SetMicroPacing(4)
+	conn, client := acceptPair(t, &ll)
+	// a chunk every 125ms
+	conn.SetLimit(8 * ll.mtu)
+	go io.Copy(ioutil.Discard, client)
+
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 3*ll.mtu)); err != nil {
+		t.Fatal(err)
+	}
+	// the first chunk right away, the next two in pieces
+	if writes := atomic.LoadInt32(&l.writes); writes != 9 {
+		t.Errorf("3 chunks written in %d writes, want 9", writes)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > time.Second {
+		t.Errorf("3 chunks took %v, want about 450ms", elapsed)
+	}
+}
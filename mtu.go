@@ -0,0 +1,46 @@
+package limlistener
+
+import (
+	"fmt"
+	"sync"
+)
+
+// connMTU is the chunk size of a connection, shared by
+// all the copies of its LimitedConn
+type connMTU struct {
+	mu  sync.Mutex
+	mtu int
+}
+
+func newConnMTU(mtu int) *connMTU {
+	return &connMTU{mtu: mtu}
+}
+
+func (cm *connMTU) get() int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	return cm.mtu
+}
+
+// MTU returns the size of the chunks the connection writes are split into
+func (lc LimitedConn) MTU() int {
+	return lc.mtu.get()
+}
+
+// SetMTU sets a chunk size for this connection alone (eg. smaller for
+// a low-rate control channel, larger for bulk data), the bursts of the
+// limiters it waits on are raised to fit a whole chunk
+func (lc LimitedConn) SetMTU(mtu int) error {
+	if mtu <= lc.recordOverhead {
+		return fmt.Errorf("limlistener: MTU of %d bytes doesn't fit the %d bytes per-chunk overhead", mtu, lc.recordOverhead)
+	}
+	for _, l := range lc.limiters() {
+		l.ensureBurst(mtu)
+	}
+	lc.mtu.mu.Lock()
+	defer lc.mtu.mu.Unlock()
+
+	lc.mtu.mtu = mtu
+	return nil
+}
@@ -0,0 +1,28 @@
+package limlistener
+
+import (
+	"net"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// Stream wraps a logical stream multiplexed over the connection (eg. a
+// yamux or smux stream, both satisfy net.Conn) as a LimitedConn of its own
+// throttled to limit bytes/sec, a limit of 0 leaves the stream unthrottled.
+// The muxer is expected to run on top of the LimitedConn itself, that way
+// the streams share the connection, class and global limits and framing
+// overhead is accounted too, while each stream only adds its own limiter.
+func (lc LimitedConn) Stream(stream net.Conn, limit int) LimitedConn {
+	streamLimit := rlimit.Limit(limit)
+	if limit <= 0 {
+		streamLimit = rlimit.Inf
+	}
+	mtu := lc.MTU()
+	// streams are reported under their parent connection, which
+	// already waits on the global limiter
+	s := newLimitedConn(lc.id, stream, newGlobalLimiter(rlimit.Inf, 0), newLimiter(streamLimit, mtu), mtu)
+	s.chunking = lc.chunking
+	// streams start out with the context of their connection
+	s.ctx = newConnContext(lc.Context())
+	return s
+}
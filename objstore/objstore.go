@@ -0,0 +1,147 @@
+// Package objstore throttles uploads to object storage (S3, GCS, MinIO
+// and the like) through a LimitedDialer: the parts of a multipart upload go
+// out over parallel connections that all come out of the dialer's global
+// budget, so a big upload can't saturate the uplink however many parts it
+// runs at once.
+//
+// The storage SDK does the talking, it only has to be given the client:
+//
+//	ld := limlistener.NewWithDialer(nil)
+//	ld.SetLimits(20<<20, 0)
+//	cfg.HTTPClient = objstore.HTTPClient(&ld) // aws.Config
+//
+//	parts, err := objstore.Upload(ctx, file, size, objstore.MinPartSize, 8,
+//		func(ctx context.Context, number int, body io.ReadSeeker, size int64) (string, error) {
+//			out, err := s3Client.UploadPart(ctx, &s3.UploadPartInput{
+//				Bucket:        &bucket,
+//				Key:           &key,
+//				UploadId:      upload.UploadId,
+//				PartNumber:    int32(number),
+//				Body:          body,
+//				ContentLength: size,
+//			})
+//			if err != nil {
+//				return "", err
+//			}
+//			return *out.ETag, nil
+//		})
+package objstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/lrascao/limlistener"
+)
+
+const (
+	// smallest part S3 (and the GCS XML API) accept, but for the last one
+	MinPartSize = 5 << 20
+	// most parts an upload can have
+	MaxParts = 10000
+)
+
+// HTTPClient returns a client dialing through ld, to hand to the storage
+// SDK (eg. aws.Config.HTTPClient or GCS option.WithHTTPClient): each part
+// being uploaded at once gets a connection of its own and they all share
+// ld's limits
+func HTTPClient(ld *limlistener.LimitedDialer) *http.Client {
+	return &http.Client{Transport: ld.Transport(nil)}
+}
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	// numbered from 1
+	Number int
+	Size   int64
+	ETag   string
+}
+
+// UploadPartFunc uploads a part of size bytes (eg. with s3.UploadPart),
+// body can be seeked back to its start for signing or retrying
+type UploadPartFunc func(ctx context.Context, number int, body io.ReadSeeker, size int64) (etag string, err error)
+
+// Upload splits the size bytes of r into parts of partSize bytes (the last
+// one possibly smaller) and uploads them with upload, concurrency of them
+// at once. The parts are returned in order, ready to complete the upload
+// with, the first failure cancels the ones in flight.
+//
+// Running more parts at once than the dialer's global limit allows at its
+// per-connection limit only splits the same budget further.
+func Upload(ctx context.Context, r io.ReaderAt, size, partSize int64, concurrency int, upload UploadPartFunc) ([]Part, error) {
+	if partSize <= 0 || (partSize < MinPartSize && size > partSize) {
+		return nil, fmt.Errorf("objstore: parts of %d bytes are smaller than the %d bytes minimum", partSize, MinPartSize)
+	}
+	count := int((size + partSize - 1) / partSize)
+	if count == 0 {
+		count = 1
+	}
+	if count > MaxParts {
+		return nil, fmt.Errorf("objstore: %d bytes make %d parts of %d bytes, more than the %d allowed", size, count, partSize, MaxParts)
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make([]Part, count)
+	numbers := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var failure error
+	for i := 0; i < concurrency && i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range numbers {
+				part, err := uploadPart(ctx, r, size, partSize, number, upload)
+				if err != nil {
+					once.Do(func() {
+						failure = err
+						cancel()
+					})
+					continue
+				}
+				parts[number-1] = part
+			}
+		}()
+	}
+	for number := 1; number <= count && ctx.Err() == nil; number++ {
+		select {
+		case numbers <- number:
+		case <-ctx.Done():
+		}
+	}
+	close(numbers)
+	wg.Wait()
+
+	if failure != nil {
+		return nil, failure
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// uploadPart uploads the part number of r
+func uploadPart(ctx context.Context, r io.ReaderAt, size, partSize int64, number int, upload UploadPartFunc) (Part, error) {
+	if err := ctx.Err(); err != nil {
+		return Part{}, err
+	}
+	offset := int64(number-1) * partSize
+	n := partSize
+	if offset+n > size {
+		n = size - offset
+	}
+	etag, err := upload(ctx, number, io.NewSectionReader(r, offset, n), n)
+	if err != nil {
+		return Part{}, fmt.Errorf("objstore: part %d: %w", number, err)
+	}
+	return Part{Number: number, Size: n, ETag: etag}, nil
+}
@@ -0,0 +1,219 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lrascao/limlistener"
+)
+
+// fakeStore is an object store taking the parts of an upload as
+// PUT ?partNumber=N requests, each answered with the MD5 of the part as
+// its ETag like S3 does
+type fakeStore struct {
+	mu    sync.Mutex
+	parts map[int][]byte
+	// open requests and the most there were at once
+	inFlight, maxInFlight int
+	// parts to fail with a 500
+	fail map[int]bool
+}
+
+func newFakeStore(t *testing.T) (*fakeStore, *httptest.Server) {
+	fs := &fakeStore{parts: make(map[int][]byte), fail: make(map[int]bool)}
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+	return fs, srv
+}
+
+func (fs *fakeStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if r.Method != http.MethodPut || err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	fs.mu.Lock()
+	fs.inFlight++
+	if fs.inFlight > fs.maxInFlight {
+		fs.maxInFlight = fs.inFlight
+	}
+	fail := fs.fail[number]
+	fs.mu.Unlock()
+	defer func() {
+		fs.mu.Lock()
+		fs.inFlight--
+		fs.mu.Unlock()
+	}()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	if fail {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	fs.mu.Lock()
+	fs.parts[number] = body
+	fs.mu.Unlock()
+	sum := md5.Sum(body)
+	w.Header().Set("ETag", hex.EncodeToString(sum[:]))
+}
+
+// uploader returns an UploadPartFunc putting parts to the store at url
+func uploader(client *http.Client, url string) UploadPartFunc {
+	return func(ctx context.Context, number int, body io.ReadSeeker, size int64) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+			url+"/bucket/key?uploadId=1&partNumber="+strconv.Itoa(number), body)
+		if err != nil {
+			return "", err
+		}
+		req.ContentLength = size
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", errors.New(resp.Status)
+		}
+		return resp.Header.Get("ETag"), nil
+	}
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestUpload(t *testing.T) {
+	fs, srv := newFakeStore(t)
+	ld := limlistener.NewWithDialer(nil)
+	client := HTTPClient(&ld)
+
+	data := testData(2*MinPartSize + 1000)
+	parts, err := Upload(context.Background(), bytes.NewReader(data), int64(len(data)),
+		MinPartSize, 2, uploader(client, srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("%d parts, want 3", len(parts))
+	}
+	for i, part := range parts {
+		if part.Number != i+1 {
+			t.Errorf("part %d numbered %d", i, part.Number)
+		}
+		offset := int64(i) * MinPartSize
+		want := data[offset:]
+		if len(want) > MinPartSize {
+			want = want[:MinPartSize]
+		}
+		if part.Size != int64(len(want)) {
+			t.Errorf("part %d of %d bytes, want %d", part.Number, part.Size, len(want))
+		}
+		if !bytes.Equal(fs.parts[part.Number], want) {
+			t.Errorf("part %d stored something else", part.Number)
+		}
+		sum := md5.Sum(want)
+		if part.ETag != hex.EncodeToString(sum[:]) {
+			t.Errorf("part %d ETag %q isn't the store's", part.Number, part.ETag)
+		}
+	}
+	if fs.maxInFlight > 2 {
+		t.Errorf("%d parts uploaded at once, want at most 2", fs.maxInFlight)
+	}
+}
+
+func TestUploadSharesDialerBudget(t *testing.T) {
+	_, srv := newFakeStore(t)
+	ld := limlistener.NewWithDialer(nil)
+	// the parts together, however many at once
+	ld.SetLimits(10<<20, 0)
+	// chunks big enough for timer granularity not to matter
+	if err := ld.SetMTU(64 << 10); err != nil {
+		t.Fatal(err)
+	}
+	client := HTTPClient(&ld)
+
+	data := testData(3 * MinPartSize)
+	start := time.Now()
+	_, err := Upload(context.Background(), bytes.NewReader(data), int64(len(data)),
+		MinPartSize, 3, uploader(client, srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 15 MB at 10 MB/s
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 3*time.Second {
+		t.Errorf("uploading 15 MB took %v, want about 1.5s", elapsed)
+	}
+}
+
+func TestUploadFailureCancels(t *testing.T) {
+	fs, srv := newFakeStore(t)
+	fs.fail[2] = true
+	ld := limlistener.NewWithDialer(nil)
+	client := HTTPClient(&ld)
+
+	data := testData(4 * MinPartSize)
+	_, err := Upload(context.Background(), bytes.NewReader(data), int64(len(data)),
+		MinPartSize, 1, uploader(client, srv.URL))
+	if err == nil {
+		t.Fatal("upload with a failed part succeeded")
+	}
+	// one at a time, nothing gets started past the failure
+	if _, ok := fs.parts[3]; ok {
+		t.Error("parts uploaded after the failure")
+	}
+}
+
+func TestUploadSmallObject(t *testing.T) {
+	fs, srv := newFakeStore(t)
+	ld := limlistener.NewWithDialer(nil)
+	client := HTTPClient(&ld)
+
+	// a single part can be smaller than the minimum
+	data := testData(1000)
+	parts, err := Upload(context.Background(), bytes.NewReader(data), int64(len(data)),
+		MinPartSize, 4, uploader(client, srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || parts[0].Size != 1000 || !bytes.Equal(fs.parts[1], data) {
+		t.Errorf("parts = %+v, want the whole object in one", parts)
+	}
+}
+
+func TestUploadPartSizes(t *testing.T) {
+	upload := func(context.Context, int, io.ReadSeeker, int64) (string, error) {
+		return "", nil
+	}
+	tests := []struct {
+		size, partSize int64
+	}{
+		// parts under the minimum
+		{2 * MinPartSize, MinPartSize - 1},
+		{10, 0},
+		// too many parts
+		{(MaxParts + 1) * MinPartSize, MinPartSize},
+	}
+	for _, tt := range tests {
+		if _, err := Upload(context.Background(), bytes.NewReader(nil), tt.size, tt.partSize, 1, upload); err == nil {
+			t.Errorf("Upload(%d bytes, parts of %d) succeeded", tt.size, tt.partSize)
+		}
+	}
+}
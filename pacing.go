@@ -0,0 +1,85 @@
+package limlistener
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+const (
+	// how often the path rate gets measured again
+	pathSampleInterval = 100 * time.Millisecond
+	// pacing slightly above the measured rate lets the estimate grow
+	// when the path has more capacity, like BBR probing does
+	pathPacingGain = 1.25
+)
+
+// pathPacer paces a connection to the delivery rate measured on its
+// socket, on top of whatever static limits apply
+type pathPacer struct {
+	mu      sync.Mutex
+	limiter *limiter
+	sampled time.Time
+}
+
+func newPathPacer(burst int) *pathPacer {
+	return &pathPacer{
+		limiter: newLimiter(rlimit.Inf, burst),
+	}
+}
+
+// waitN measures the path again if it's time to and waits for n bytes
+// at the measured rate
+func (p *pathPacer) waitN(ctx context.Context, n int, conn interface{}) error {
+	p.mu.Lock()
+	if now := time.Now(); now.Sub(p.sampled) >= pathSampleInterval {
+		p.sampled = now
+		if rate, ok := measurePath(conn); ok {
+			p.limiter.SetLimit(rlimit.Limit(rate * pathPacingGain))
+		}
+	}
+	p.mu.Unlock()
+
+	p.limiter.ensureBurst(n)
+	return p.limiter.WaitN(ctx, n)
+}
+
+// measurePath estimates the delivery rate in bytes/sec of a
+// connection's socket, where the platform makes it possible
+func measurePath(conn interface{}) (float64, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var rate float64
+	ok = false
+	raw.Control(func(fd uintptr) {
+		rate, ok = socketRate(fd)
+	})
+	return rate, ok
+}
+
+// SetPathPacing is an experimental mode pacing writes of every connection to
+// the delivery rate measured on its socket (out of TCP_INFO where available,
+// Linux only for now) instead of a static limit, acting as a "don't exceed
+// path capacity" governor. Static limits still apply on top.
+func (ll *LimitedListener) SetPathPacing(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&ll.pathPacing, v)
+}
+
+func (ll *LimitedListener) pathPacingEnabled() bool {
+	// dialed connections have no listener
+	return ll != nil && atomic.LoadInt32(&ll.pathPacing) == 1
+}
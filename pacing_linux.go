@@ -0,0 +1,23 @@
+//go:build linux && !386
+// +build linux,!386
+
+package limlistener
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// socketRate estimates the delivery rate out of the congestion window
+// and smoothed round trip time the kernel keeps for the socket
+func socketRate(fd uintptr) (float64, bool) {
+	var info syscall.TCPInfo
+	size := uint32(unsafe.Sizeof(info))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+		uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 || info.Rtt == 0 {
+		return 0, false
+	}
+	// rtt is in microseconds
+	return float64(info.Snd_cwnd) * float64(info.Snd_mss) / (float64(info.Rtt) / 1e6), true
+}
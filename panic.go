@@ -0,0 +1,107 @@
+package limlistener
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// PanicReport describes a panic contained in a helper goroutine
+type PanicReport struct {
+	Time time.Time
+	// helper it happened in (eg. "serve", "relay", "write queue")
+	Helper string
+	// remote address of the connection involved
+	Remote string
+	Value  string
+	Stack  string
+}
+
+// PanicStats count the panics contained by a listener's helpers (Serve,
+// Relay, async write queues and writable notifications)
+type PanicStats struct {
+	Count int64
+	// the last one, nil if none
+	Last *PanicReport
+}
+
+// panicCounters are PanicStats guarded by a lock
+type panicCounters struct {
+	mu    sync.Mutex
+	count int64
+	last  *PanicReport
+}
+
+// PanicStats returns the panics contained so far
+func (ll *LimitedListener) PanicStats() PanicStats {
+	ll.panics.mu.Lock()
+	defer ll.panics.mu.Unlock()
+
+	return PanicStats{
+		Count: ll.panics.count,
+		Last:  ll.panics.last,
+	}
+}
+
+// contain is deferred by the helper goroutines running on behalf of
+// connections: a panic is recovered, conns are closed and it's recorded by
+// the listener of the first one accepted by one, so a misbehaving
+// connection (or handler) can't take the process down. onPanic, if not
+// nil, gets it as an error.
+func contain(helper string, onPanic func(error), conns ...net.Conn) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	buf := make([]byte, 64<<10)
+	buf = buf[:runtime.Stack(buf, false)]
+	report := &PanicReport{
+		Time:   time.Now(),
+		Helper: helper,
+		Value:  fmt.Sprint(v),
+		Stack:  string(buf),
+	}
+	var ll *LimitedListener
+	for _, conn := range conns {
+		if report.Remote == "" {
+			report.Remote = addrString(conn.RemoteAddr())
+		}
+		if lc, ok := listenerConn(conn); ok && ll == nil {
+			ll = lc.listener
+		}
+		conn.Close()
+	}
+
+	err := fmt.Errorf("limlistener: panic in %s for %s: %s\n%s", helper, report.Remote, report.Value, report.Stack)
+	if onPanic != nil {
+		onPanic(err)
+	}
+	if ll != nil {
+		ll.panics.mu.Lock()
+		ll.panics.count++
+		ll.panics.last = report
+		ll.panics.mu.Unlock()
+	}
+	// logged unless there's an error handler to take it
+	if len(conns) == 0 || !ll.reportError(conns[0], err) {
+		log.Print(err)
+	}
+}
+
+// listenerConn returns the LimitedConn behind conn if it was accepted
+// by a listener
+func listenerConn(conn net.Conn) (LimitedConn, bool) {
+	var lc LimitedConn
+	switch c := conn.(type) {
+	case LimitedConn:
+		lc = c
+	case releasingConn:
+		lc = c.LimitedConn
+	default:
+		return LimitedConn{}, false
+	}
+	return lc, lc.listener != nil
+}
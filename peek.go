@@ -0,0 +1,148 @@
+package limlistener
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+)
+
+// Sniffer decides the class of a connection out of the first bytes
+// the client sent, empty leaves the connection unclassified
+type Sniffer func(peek []byte) string
+
+// peeker buffers the first bytes read from a connection so
+// they can be looked at without consuming them
+type peeker struct {
+	mu   sync.Mutex
+	conn net.Conn
+	// nil until something gets peeked
+	r *bufio.Reader
+	// pending sniffer and how many bytes it wants to look at
+	sniffer Sniffer
+	sniffN  int
+}
+
+func newPeeker(conn net.Conn) *peeker {
+	return &peeker{conn: conn}
+}
+
+// reader returns the buffered reader, creating it with at least size bytes,
+// must be called with the lock held
+func (p *peeker) reader(size int) *bufio.Reader {
+	if p.r == nil {
+		p.r = bufio.NewReaderSize(p.conn, size)
+	}
+	return p.r
+}
+
+func (p *peeker) read(b []byte) (int, error) {
+	p.mu.Lock()
+	r := p.r
+	p.mu.Unlock()
+
+	if r == nil {
+		return p.conn.Read(b)
+	}
+	return r.Read(b)
+}
+
+// buffered returns how many bytes were read from the
+// connection but not yet consumed
+func (p *peeker) buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.r == nil {
+		return 0
+	}
+	return p.r.Buffered()
+}
+
+func (p *peeker) peek(n int) ([]byte, error) {
+	p.mu.Lock()
+	r := p.reader(n)
+	p.mu.Unlock()
+
+	return r.Peek(n)
+}
+
+// sniff runs the pending sniffer, if any, on whatever the client
+// sent first (up to sniffN bytes)
+func (p *peeker) sniff() (string, bool) {
+	p.mu.Lock()
+	sniffer := p.sniffer
+	p.sniffer = nil
+	if sniffer == nil {
+		p.mu.Unlock()
+		return "", false
+	}
+	r := p.reader(p.sniffN)
+	n := p.sniffN
+	p.mu.Unlock()
+
+	// wait for the first bytes, then look at as many as arrived
+	if _, err := r.Peek(1); err != nil {
+		return "", false
+	}
+	if b := r.Buffered(); b < n {
+		n = b
+	}
+	data, _ := r.Peek(n)
+	return sniffer(data), true
+}
+
+// SetSniffer classifies connections accepted from now on with fn, looking at
+// up to n of the first bytes the client sends before the application reads
+// them, so eg. TLS and plaintext or different HTTP verbs get their class
+// limits. Sniffing happens on the first Read (or Peek), the sniffed bytes are
+// still returned by Read and are never throttled.
+func (ll *LimitedListener) SetSniffer(n int, fn Sniffer) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.sniffer = fn
+	ll.sniffN = n
+}
+
+// Peek returns the next n bytes the client sent without consuming them,
+// blocking until they arrive. The first Peek decides the size of the
+// buffer, bufio.ErrBufferFull is returned when a later one needs more.
+func (lc LimitedConn) Peek(n int) ([]byte, error) {
+	lc.runSniffer()
+	return lc.peek.peek(n)
+}
+
+// runSniffer classifies the connection if it's waiting on a sniffer
+func (lc LimitedConn) runSniffer() {
+	if lc.peek == nil {
+		return
+	}
+	if class, ok := lc.peek.sniff(); ok && class != "" {
+		lc.listener.classify(lc, class)
+	}
+}
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "),
+	[]byte("DELETE "), []byte("CONNECT "), []byte("OPTIONS "),
+	[]byte("TRACE "), []byte("PATCH "), []byte("PRI * HTTP/2"),
+}
+
+// SniffProtocol is a sniffer telling apart "tls" (a TLS handshake record),
+// "http" (an HTTP/1.x request line or the HTTP/2 preface) and "ssh"
+// (an SSH version banner), anything else is left unclassified
+func SniffProtocol(peek []byte) string {
+	switch {
+	case len(peek) >= 3 && peek[0] == 0x16 && peek[1] == 0x03:
+		return "tls"
+	case bytes.HasPrefix(peek, []byte("SSH-")):
+		return "ssh"
+	}
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(peek, method) {
+			return "http"
+		}
+	}
+	return ""
+}
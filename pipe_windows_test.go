@@ -0,0 +1,203 @@
+//go:build windows
+// +build windows
+
+package limlistener
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipe  = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+)
+
+const (
+	pipeAccessDuplex       = 0x3
+	pipeUnlimitedInstances = 255
+	errorPipeConnected     = syscall.Errno(535)
+	errorPipeBusy          = syscall.Errno(231)
+)
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// namedPipeListener is a bare bones synchronous named pipe listener,
+// standing in for go-winio's
+type namedPipeListener struct {
+	path   string
+	closed int32
+}
+
+var pipeCount int32
+
+func newNamedPipeListener() *namedPipeListener {
+	n := atomic.AddInt32(&pipeCount, 1)
+	return &namedPipeListener{
+		path: fmt.Sprintf(`\\.\pipe\limlistener-test-%d-%d`, os.Getpid(), n),
+	}
+}
+
+func (l *namedPipeListener) Accept() (net.Conn, error) {
+	path, err := syscall.UTF16PtrFromString(l.path)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := procCreateNamedPipe.Call(uintptr(unsafe.Pointer(path)),
+		pipeAccessDuplex, 0, pipeUnlimitedInstances, 64<<10, 64<<10, 0, 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		return nil, err
+	}
+	if r, _, err := procConnectNamedPipe.Call(h, 0); r == 0 && err != errorPipeConnected {
+		syscall.CloseHandle(syscall.Handle(h))
+		return nil, err
+	}
+	if atomic.LoadInt32(&l.closed) == 1 {
+		syscall.CloseHandle(syscall.Handle(h))
+		return nil, net.ErrClosed
+	}
+	return pipeConn{os.NewFile(h, l.path), pipeAddr(l.path)}, nil
+}
+
+func (l *namedPipeListener) Close() error {
+	atomic.StoreInt32(&l.closed, 1)
+	// gets a pending Accept out of ConnectNamedPipe
+	if f, err := os.OpenFile(l.path, os.O_RDWR, 0); err == nil {
+		f.Close()
+	}
+	return nil
+}
+
+func (l *namedPipeListener) Addr() net.Addr {
+	return pipeAddr(l.path)
+}
+
+// dial connects to the pipe, waiting for Accept to create an instance
+func (l *namedPipeListener) dial(t *testing.T) *os.File {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f, err := os.OpenFile(l.path, os.O_RDWR, 0)
+		if err == nil {
+			t.Cleanup(func() { f.Close() })
+			return f
+		}
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, errorPipeBusy) || time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// pipeConn is a server end of a named pipe, pipes have no socket
+// options and both ends share the pipe's address
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func (c pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+// acceptPipe accepts a connection from a client reading everything
+// written to it, the bytes read are sent once the server closes it
+func acceptPipe(t *testing.T, ll *LimitedListener, pl *namedPipeListener) (LimitedConn, <-chan int64) {
+	t.Helper()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ll.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		accepted <- conn
+	}()
+	client := pl.dial(t)
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("accept failed")
+	}
+	read := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(ioutil.Discard, client)
+		read <- n
+	}()
+	return conn.(LimitedConn), read
+}
+
+func TestNamedPipeLimit(t *testing.T) {
+	pl := newNamedPipeListener()
+	ll := NewWithListener(pl)
+	defer ll.Close()
+	ll.SetLimits(0, 10000)
+	// socket level features are skipped on pipes
+	ll.SetSocketBuffers(50 * time.Millisecond)
+	conn, read := acceptPipe(t, &ll, pl)
+
+	// a full bucket and then 2000 bytes at 10000 bytes/sec
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 3024)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("3024 bytes took %v, want about 200ms", elapsed)
+	}
+	if wire := conn.Stats().WireBytes; wire != 3024 {
+		t.Errorf("%d bytes accounted, want 3024", wire)
+	}
+	ll.CloseConnection(conn)
+	if n := <-read; n != 3024 {
+		t.Errorf("client read %d bytes, want 3024", n)
+	}
+	if err := conn.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("closing again = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestNamedPipeClientsShareKey(t *testing.T) {
+	pl := newNamedPipeListener()
+	ll := NewWithListener(pl)
+	defer ll.Close()
+	ll.SetIPLimit(64 * 1024)
+
+	var conns []LimitedConn
+	for i := 0; i < 2; i++ {
+		conn, _ := acceptPipe(t, &ll, pl)
+		defer ll.CloseConnection(conn)
+		conns = append(conns, conn)
+	}
+	// every client of a pipe comes from its address
+	if conns[0].keyLimiter != conns[1].keyLimiter || conns[0].key != pl.path {
+		t.Errorf("pipe clients keyed %q and %q, want both %q", conns[0].key, conns[1].key, pl.path)
+	}
+}
+
+func TestNamedPipeListenerClose(t *testing.T) {
+	pl := newNamedPipeListener()
+	ll := NewWithListener(pl)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := ll.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept() = %v, want net.ErrClosed", err)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ll.Close()
+	wg.Wait()
+}
@@ -0,0 +1,96 @@
+package limlistener
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Decision is what an admission policy decided about a connection
+type Decision struct {
+	// Evict rejects the connection when accepting it,
+	// or closes it when reviewing it
+	Evict bool
+	// Class puts the connection in a class (see SetClass),
+	// empty keeps the current one
+	Class string
+	// ConnLimit overrides the connection's per-connection limit,
+	// 0 keeps the current one
+	ConnLimit int
+}
+
+// AdmissionPolicy lets an external policy engine drive shaping: it's
+// consulted for every accepted connection and periodically for each open one
+type AdmissionPolicy interface {
+	// Admit is consulted before an accepted connection is handed out,
+	// evicted connections are closed and never returned by Accept
+	Admit(conn LimitedConn) Decision
+	// Review is consulted periodically for every open connection
+	Review(conn LimitedConn) Decision
+}
+
+// SetAdmissionPolicy makes the listener consult policy for every accepted
+// connection and, every interval (if not 0), for each open one. A nil policy
+// stops consulting any.
+func (ll *LimitedListener) SetAdmissionPolicy(policy AdmissionPolicy, interval time.Duration) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.policy = policy
+	// stop reviews by the previous policy
+	if ll.policyStop != nil {
+		close(ll.policyStop)
+		ll.policyStop = nil
+	}
+	if policy != nil && interval > 0 {
+		ll.policyStop = make(chan struct{})
+		go ll.review(policy, interval, ll.policyStop)
+	}
+}
+
+func (ll *LimitedListener) getPolicy() AdmissionPolicy {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	return ll.policy
+}
+
+// review periodically consults policy for every open connection
+func (ll *LimitedListener) review(policy AdmissionPolicy, interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for _, conn := range ll.connections() {
+			if !ll.decide(*conn, policy.Review(*conn)) {
+				atomic.AddInt64(&ll.shed.evictedConns, 1)
+				ll.evict(*conn)
+			}
+		}
+	}
+}
+
+// decide applies a policy decision to a connection,
+// returns false if it has to be evicted
+func (ll *LimitedListener) decide(lc LimitedConn, d Decision) bool {
+	if d.Evict {
+		return false
+	}
+	if d.ConnLimit > 0 {
+		lc.class.setOverride(d.ConnLimit)
+	}
+	switch {
+	case d.Class != "":
+		ll.classify(lc, d.Class)
+	case d.ConnLimit > 0:
+		ll.mu.Lock()
+		limit := ll.connLimitFor(&lc)
+		ll.mu.Unlock()
+		lc.setLimit(limit)
+	}
+	return true
+}
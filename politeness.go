@@ -0,0 +1,187 @@
+package limlistener
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Politeness are the crawler etiquette settings of a dialer, zero
+// values leave that part out
+type Politeness struct {
+	// bandwidth shared by the connections towards each destination,
+	// see SetDestinationLimit
+	DestinationLimit int
+	// connections open at once towards each destination, dials beyond it
+	// wait for one of them to be cleaned up
+	MaxConnsPerHost int
+	// time between the start of two dials towards the same destination
+	CrawlDelay time.Duration
+}
+
+// SetPoliteness gathers what keeps a crawler or scraper polite in one
+// place: a bandwidth limit, a cap on concurrent connections and a delay
+// between dials towards each destination (host and port, as given to Dial).
+// Dials wait for their turn, giving up when their context is done.
+// Connections count against the cap until cleaned up with CloseConnection,
+// see Transport to keep http.Client connections in line.
+func (ld *LimitedDialer) SetPoliteness(p Politeness) {
+	if p.DestinationLimit > 0 {
+		ld.SetDestinationLimit(p.DestinationLimit)
+	}
+
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	if ld.politeness == nil {
+		ld.politeness = &politeness{
+			hosts: make(map[string]*hostTurns),
+		}
+	}
+	ld.politeness.set(p.MaxConnsPerHost, p.CrawlDelay)
+}
+
+// politeness hands out the turns to dial each destination
+type politeness struct {
+	mu       sync.Mutex
+	maxConns int
+	delay    time.Duration
+	hosts    map[string]*hostTurns
+	// hosts tracked after the last sweep
+	swept int
+}
+
+// hostTurns are the connections open towards a destination,
+// the dials waiting for one to go away and when the next one can start
+type hostTurns struct {
+	open    int
+	waiting []chan struct{}
+	next    time.Time
+}
+
+func (p *politeness) set(maxConns int, delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxConns = maxConns
+	p.delay = delay
+	// a higher cap lets waiting dials in
+	for _, h := range p.hosts {
+		for free := maxConns - h.open; len(h.waiting) > 0 && (maxConns <= 0 || free > 0); free-- {
+			p.wake(h)
+		}
+	}
+}
+
+// acquire waits for a turn to dial key, holding one of its connection
+// slots until the returned slot is released
+func (p *politeness) acquire(ctx context.Context, key string) (*hostSlot, error) {
+	if p == nil {
+		return nil, nil
+	}
+	p.mu.Lock()
+	h, ok := p.hosts[key]
+	if !ok {
+		p.sweep()
+		h = &hostTurns{}
+		p.hosts[key] = h
+	}
+	for p.maxConns > 0 && h.open >= p.maxConns {
+		ch := make(chan struct{})
+		h.waiting = append(h.waiting, ch)
+		p.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			p.mu.Lock()
+			if !p.forget(h, ch) {
+				// woken up meanwhile, the turn goes to the next one
+				p.wake(h)
+			}
+			p.mu.Unlock()
+			return nil, ctx.Err()
+		}
+		p.mu.Lock()
+	}
+	h.open++
+	// starts are spaced out by the crawl delay
+	start := time.Now()
+	if h.next.After(start) {
+		start = h.next
+	}
+	h.next = start.Add(p.delay)
+	p.mu.Unlock()
+
+	slot := &hostSlot{p: p, key: key}
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			slot.release()
+			return nil, ctx.Err()
+		}
+	}
+	return slot, nil
+}
+
+// wake lets the first waiting dial of h check for a
+// slot, must be called with the lock held
+func (p *politeness) wake(h *hostTurns) {
+	if len(h.waiting) == 0 {
+		return
+	}
+	close(h.waiting[0])
+	h.waiting = h.waiting[1:]
+}
+
+// forget drops ch from the dials waiting on h, telling if it was still
+// there, must be called with the lock held
+func (p *politeness) forget(h *hostTurns, ch chan struct{}) bool {
+	for i, w := range h.waiting {
+		if w == ch {
+			h.waiting = append(h.waiting[:i], h.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// sweep drops the destinations with nothing open, waiting nor delayed
+// every time their number doubled, must be called with the lock held
+func (p *politeness) sweep() {
+	if len(p.hosts) < 2*p.swept {
+		return
+	}
+	now := time.Now()
+	for key, h := range p.hosts {
+		if h.open == 0 && len(h.waiting) == 0 && !h.next.After(now) {
+			delete(p.hosts, key)
+		}
+	}
+	p.swept = len(p.hosts)
+}
+
+// hostSlot is a connection slot towards a destination,
+// given back once
+type hostSlot struct {
+	once sync.Once
+	p    *politeness
+	key  string
+}
+
+func (s *hostSlot) release() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.p.mu.Lock()
+		defer s.p.mu.Unlock()
+
+		if h, ok := s.p.hosts[s.key]; ok {
+			h.open--
+			s.p.wake(h)
+		}
+	})
+}
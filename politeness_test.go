@@ -0,0 +1,85 @@
+package limlistener
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// acceptAll accepts connections to l, keeping them open until it's closed
+func acceptAll(l net.Listener) {
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+}
+
+func TestPolitenessMaxConnsPerHost(t *testing.T) {
+	l := listen(t)
+	acceptAll(l)
+	ld := NewWithDialer(nil)
+	ld.SetPoliteness(Politeness{MaxConnsPerHost: 1})
+	addr := l.Addr().String()
+
+	first, err := ld.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := ld.DialContext(ctx, "tcp", addr); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("dial over the cap = %v, want it to wait until the deadline", err)
+	}
+
+	// a dial waiting for its turn gets it once the first one is cleaned up
+	dialed := make(chan error, 1)
+	go func() {
+		conn, err := ld.Dial("tcp", addr)
+		if err == nil {
+			ld.CloseConnection(conn)
+		}
+		dialed <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-dialed:
+		t.Fatalf("dial over the cap = %v, want it to wait", err)
+	default:
+	}
+	ld.CloseConnection(first)
+	select {
+	case err := <-dialed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting dial never got its turn")
+	}
+}
+
+func TestPolitenessCrawlDelay(t *testing.T) {
+	l := listen(t)
+	acceptAll(l)
+	ld := NewWithDialer(nil)
+	ld.SetPoliteness(Politeness{CrawlDelay: 100 * time.Millisecond})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		conn, err := ld.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ld.CloseConnection(conn)
+	}
+	// the second and third dials waited for their turn
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("3 dials took %v, want about 200ms", elapsed)
+	}
+}
@@ -0,0 +1,118 @@
+package limlistener
+
+import (
+	"sync"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// maxLimiterPools caps how many distinct limits limiters are pooled for
+const maxLimiterPools = 64
+
+// SetLimiterPool makes the listener reuse the limiters of the connections
+// cleaned up with CloseConnection (or AbortConnection) for the connections
+// accepted next, keyed by their limit, cutting down on allocations and GC
+// pressure under heavy connection churn. Connections must not be written to
+// once cleaned up, their limiters could already belong to another one.
+// Connections the listener closes itself (CloseAll, close modes, evictions)
+// or that are detached keep their limiters, their handlers may still be
+// writing to them.
+func (ll *LimitedListener) SetLimiterPool(enabled bool) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.limiterPool = nil
+	if enabled {
+		ll.limiterPool = &limiterPool{
+			pools: make(map[limiterKey]*sync.Pool),
+		}
+	}
+}
+
+type limiterKey struct {
+	limit rlimit.Limit
+	burst int
+}
+
+// limiterPool keeps spare limiters by limit and burst
+type limiterPool struct {
+	mu    sync.Mutex
+	pools map[limiterKey]*sync.Pool
+}
+
+// pool returns the pool of a limit and burst, nil if there are
+// already too many of them
+func (p *limiterPool) pool(limit rlimit.Limit, burst int) *sync.Pool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := limiterKey{limit, burst}
+	pool, ok := p.pools[key]
+	if !ok && len(p.pools) < maxLimiterPools {
+		pool = &sync.Pool{}
+		p.pools[key] = pool
+	}
+	return pool
+}
+
+// get returns a full limiter, reused if possible
+func (p *limiterPool) get(limit rlimit.Limit, burst int) *limiter {
+	if p == nil {
+		return newLimiter(limit, burst)
+	}
+	if pool := p.pool(limit, burst); pool != nil {
+		if v := pool.Get(); v != nil {
+			l := v.(*limiter)
+			l.reset(limit, burst)
+			return l
+		}
+	}
+	return newLimiter(limit, burst)
+}
+
+// put keeps l around to be reused, by its current limit and burst
+func (p *limiterPool) put(l *limiter) {
+	if p == nil || l == nil {
+		return
+	}
+	if pool := p.pool(l.Limit(), l.Burst()); pool != nil {
+		pool.Put(l)
+	}
+}
+
+// pooledLimiters are the limiters a connection got from the pool,
+// given back once when its handler cleans it up
+type pooledLimiters struct {
+	once     sync.Once
+	pool     *limiterPool
+	limiters [3]*limiter
+}
+
+func (pl *pooledLimiters) release() {
+	if pl == nil {
+		return
+	}
+	pl.once.Do(func() {
+		for _, l := range pl.limiters {
+			pl.pool.put(l)
+		}
+	})
+}
+
+// reset makes l as good as new: full, at limit and burst
+func (l *limiter) reset(limit rlimit.Limit, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// rate.Limiter can't be refilled directly, advancing it an hour at a
+	// fast enough finite rate does it and the next reservation, made
+	// before that, brings it back to now
+	now := time.Now()
+	l.lim.SetLimitAt(now, rlimit.Limit(1<<31))
+	l.lim.SetBurstAt(now.Add(time.Hour), burst)
+	l.lim.SetLimitAt(now.Add(time.Hour), limit)
+	l.tokens = float64(burst)
+	l.last = now
+	l.lastEvent = time.Time{}
+}
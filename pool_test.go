@@ -0,0 +1,78 @@
+package limlistener
+
+import (
+	"net"
+	"testing"
+)
+
+// pooledLimiter tells if l is one of the limiters of a connection
+// accepted after the ones holding it were cleaned up
+func pooledLimiter(t *testing.T, ll *LimitedListener, l *limiter) bool {
+	t.Helper()
+	// sync.Pool drops some of what it's given under the race detector
+	for i := 0; i < 20; i++ {
+		conn, err := ll.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lc := conn.(LimitedConn)
+		defer ll.CloseConnection(conn)
+		if lc.connLimiter == l || lc.readLimiter == l || lc.control == l {
+			return true
+		}
+	}
+	return false
+}
+
+func newPoolListener(t *testing.T) *LimitedListener {
+	ll := NewWithListener(&pipeListener{})
+	t.Cleanup(func() { ll.Close() })
+	ll.SetLimits(0, 64*1024)
+	ll.SetLimiterPool(true)
+	return &ll
+}
+
+func TestLimiterPoolCloseConnection(t *testing.T) {
+	ll := newPoolListener(t)
+	found := false
+	for i := 0; i < 20 && !found; i++ {
+		conn, err := ll.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := conn.(LimitedConn).connLimiter
+		ll.CloseConnection(conn)
+		found = pooledLimiter(t, ll, l)
+	}
+	if !found {
+		t.Error("limiters of cleaned up connections never reused")
+	}
+}
+
+func TestLimiterPoolSkipsListenerCloses(t *testing.T) {
+	for name, cleanup := range map[string]func(ll *LimitedListener, conn net.Conn){
+		"CloseAll": func(ll *LimitedListener, conn net.Conn) { ll.CloseAll() },
+		"AbortAll": func(ll *LimitedListener, conn net.Conn) { ll.AbortAll() },
+		"evict":    func(ll *LimitedListener, conn net.Conn) { ll.evict(conn.(LimitedConn)) },
+		"Detach": func(ll *LimitedListener, conn net.Conn) {
+			if _, err := ll.Detach(conn); err != nil {
+				t.Fatal(err)
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ll := newPoolListener(t)
+			conn, err := ll.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := conn.(LimitedConn).connLimiter
+			cleanup(ll, conn)
+			// the handler cleaning it up after the listener did
+			ll.CloseConnection(conn)
+			if pooledLimiter(t, ll, l) {
+				t.Error("limiter of a connection the handler may still write to was reused")
+			}
+		})
+	}
+}
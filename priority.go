@@ -0,0 +1,103 @@
+package limlistener
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WritePriority hints how urgent a write is
+type WritePriority int
+
+const (
+	// PriorityBulk writes wait their turn, plain Write uses it
+	PriorityBulk WritePriority = iota
+	// PriorityHigh writes (eg. keyframes, control packets) get the next
+	// tokens ahead of bulk writes queued up on the same connection
+	PriorityHigh
+)
+
+// priorityGate holds bulk writes back while high priority ones are waiting
+type priorityGate struct {
+	mu      sync.Mutex
+	pending int
+	// closed once the pending high priority writes are done
+	idle chan struct{}
+}
+
+func newPriorityGate() *priorityGate {
+	return &priorityGate{}
+}
+
+func (g *priorityGate) enter() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pending++
+	if g.pending == 1 {
+		g.idle = make(chan struct{})
+	}
+}
+
+func (g *priorityGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pending--
+	if g.pending == 0 {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// yield blocks a bulk chunk until no high priority write is waiting,
+// or ctx is done (eg. the connection closed or its write deadline)
+func (g *priorityGate) yield(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	for {
+		g.mu.Lock()
+		idle := g.idle
+		g.mu.Unlock()
+		if idle == nil {
+			return nil
+		}
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+type priorityKey struct{}
+
+func highPriority(ctx context.Context) bool {
+	high, _ := ctx.Value(priorityKey{}).(bool)
+	return high
+}
+
+// WriteWithPriority writes b like Write does, high priority writes jump
+// ahead of the bulk data queued on the connection (in async write mode
+// they skip the queue altogether). A non zero deadline drops what's left
+// of the write with os.ErrDeadlineExceeded when it can't be sent by then,
+// for frames that are worthless once late.
+func (lc LimitedConn) WriteWithPriority(b []byte, priority WritePriority, deadline time.Time) (int, error) {
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	if priority == PriorityBulk {
+		if lc.queue != nil && deadline.IsZero() {
+			return lc.push(b)
+		}
+	} else if lc.prio != nil {
+		lc.prio.enter()
+		defer lc.prio.leave()
+		ctx = context.WithValue(ctx, priorityKey{}, true)
+	}
+	return lc.writeContext(ctx, b)
+}
@@ -0,0 +1,81 @@
+package limlistener
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPriorityWriteGoesFirst(t *testing.T) {
+	ll := newTestListener(t)
+	conn := acceptDrained(t, ll)
+	conn.SetLimit(20000)
+
+	// bulk data hogging the connection
+	bulk := make(chan time.Time, 1)
+	go func() {
+		conn.Write(make([]byte, 10000))
+		bulk <- time.Now()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := conn.WriteWithPriority(make([]byte, 1000), PriorityHigh, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	high := time.Now()
+	if done := <-bulk; done.Before(high) {
+		t.Error("high priority write waited for the bulk one")
+	}
+}
+
+func TestBulkWriteYieldDeadline(t *testing.T) {
+	ll := newTestListener(t)
+	conn := acceptDrained(t, ll)
+	// a high priority write stuck on a peer not reading
+	conn.prio.enter()
+
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 100)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Write() = %v, want os.ErrDeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Write() gave up after %v", elapsed)
+	}
+	if _, err := conn.WriteWithPriority(make([]byte, 100), PriorityBulk, time.Now().Add(50*time.Millisecond)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("WriteWithPriority() = %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	// goes again once the high priority write is done
+	conn.SetWriteDeadline(time.Time{})
+	conn.prio.leave()
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Errorf("Write() = %v", err)
+	}
+}
+
+func TestBulkWriteYieldClose(t *testing.T) {
+	ll := newTestListener(t)
+	conn := acceptDrained(t, ll)
+	conn.prio.enter()
+	defer conn.prio.leave()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.Close()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 100))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Write() = %v, want net.ErrClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("closing the connection left the bulk write waiting")
+	}
+}
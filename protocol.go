@@ -0,0 +1,115 @@
+package limlistener
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProtocolStats are the counters of the connections that
+// negotiated the same ALPN protocol
+type ProtocolStats struct {
+	// connections that negotiated it
+	Conns int64
+	// bytes written to them
+	WireBytes int64
+	// time their writes waited on the limiters
+	Wait time.Duration
+}
+
+// protocolCounter are ProtocolStats updated atomically
+type protocolCounter struct {
+	conns     int64
+	wireBytes int64
+	wait      int64
+}
+
+// protocolStats break down the TLS connections by ALPN protocol
+type protocolStats struct {
+	mu       sync.Mutex
+	counters map[string]*protocolCounter
+}
+
+func newProtocolStats() *protocolStats {
+	return &protocolStats{
+		counters: make(map[string]*protocolCounter),
+	}
+}
+
+// counter returns the counter of protocol, creating it if needed
+func (ps *protocolStats) counter(protocol string) *protocolCounter {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	pc, ok := ps.counters[protocol]
+	if !ok {
+		pc = &protocolCounter{}
+		ps.counters[protocol] = pc
+	}
+	return pc
+}
+
+// ProtocolStats breaks down the traffic of TLS connections (ie. the
+// listener wraps a tls.Listener) by negotiated ALPN protocol (eg. "h2",
+// "http/1.1"), connections negotiating none are under "". Connections are
+// counted once their handshake is done.
+func (ll *LimitedListener) ProtocolStats() map[string]ProtocolStats {
+	ll.protocols.mu.Lock()
+	defer ll.protocols.mu.Unlock()
+
+	stats := make(map[string]ProtocolStats, len(ll.protocols.counters))
+	for protocol, pc := range ll.protocols.counters {
+		stats[protocol] = ProtocolStats{
+			Conns:     atomic.LoadInt64(&pc.conns),
+			WireBytes: atomic.LoadInt64(&pc.wireBytes),
+			Wait:      time.Duration(atomic.LoadInt64(&pc.wait)),
+		}
+	}
+	return stats
+}
+
+// connProtocol resolves the counter of a TLS connection's
+// protocol once its handshake is done
+type connProtocol struct {
+	mu      sync.Mutex
+	counter *protocolCounter
+}
+
+func newConnProtocol(conn net.Conn) *connProtocol {
+	if _, ok := conn.(*tls.Conn); !ok {
+		return nil
+	}
+	return &connProtocol{}
+}
+
+// get returns the counter of the connection protocol,
+// nil until the handshake is done
+func (cp *connProtocol) get(lc LimitedConn) *protocolCounter {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if cp.counter != nil {
+		return cp.counter
+	}
+	state := lc.conn.(*tls.Conn).ConnectionState()
+	if !state.HandshakeComplete {
+		return nil
+	}
+	cp.counter = lc.listener.protocols.counter(state.NegotiatedProtocol)
+	atomic.AddInt64(&cp.counter.conns, 1)
+	return cp.counter
+}
+
+// accountProtocol adds w bytes written after waiting
+// wait for them to the connection protocol
+func (lc LimitedConn) accountProtocol(w int, wait time.Duration) {
+	if lc.protocol == nil {
+		return
+	}
+	if pc := lc.protocol.get(lc); pc != nil {
+		atomic.AddInt64(&pc.wireBytes, int64(w))
+		atomic.AddInt64(&pc.wait, int64(wait))
+	}
+}
@@ -0,0 +1,201 @@
+package limlistener
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// WriteQueuePolicy decides what happens when a connection's write
+// queue has no room left for a new write
+type WriteQueuePolicy int
+
+const (
+	// QueueBlock makes Write wait until the pacing goroutine has
+	// drained enough of the queue
+	QueueBlock WriteQueuePolicy = iota
+	// QueueDropOldest discards the oldest queued writes until the
+	// new one fits
+	QueueDropOldest
+	// QueueError makes Write fail with ErrQueueFull
+	QueueError
+)
+
+// ErrQueueFull is returned by Write on a queued connection using
+// the QueueError policy when the queue is full
+var ErrQueueFull = errors.New("limlistener: write queue full")
+
+// ErrFlushTimeout is returned by Close and CloseWithTimeout when the
+// queued writes couldn't be flushed in time, the rest being discarded
+var ErrFlushTimeout = errors.New("limlistener: timed out flushing write queue")
+
+// writeQueue is a bounded (in bytes) buffer of pending writes that
+// a pacing goroutine drains through the rate limiters
+type writeQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	bufs   [][]byte
+	size   int
+	max    int
+	policy WriteQueuePolicy
+	closed bool
+	// no longer taking writes, the pending ones being flushed
+	closing bool
+	// how long Close flushes pending writes for, 0 discards them
+	flushTimeout time.Duration
+	// closed once the pacing goroutine is done
+	done chan struct{}
+	// first error hit while draining, reported on subsequent writes
+	err error
+}
+
+func newWriteQueue(max int, policy WriteQueuePolicy) *writeQueue {
+	q := &writeQueue{
+		max:    max,
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push enqueues a copy of b, applying the queue policy when full,
+// returns how many bytes were dropped to make room for it too
+func (q *writeQueue) push(b []byte) (n int, dropped int, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.err != nil {
+			return 0, dropped, q.err
+		}
+		if q.closed || q.closing {
+			return 0, dropped, net.ErrClosed
+		}
+		// an empty queue always takes the write, even an oversized one,
+		// otherwise it could never be sent
+		if q.size == 0 || q.size+len(b) <= q.max {
+			break
+		}
+		switch q.policy {
+		case QueueDropOldest:
+			dropped += len(q.bufs[0])
+			q.size -= len(q.bufs[0])
+			q.bufs[0] = nil
+			q.bufs = q.bufs[1:]
+			continue
+		case QueueError:
+			return 0, dropped, ErrQueueFull
+		}
+		q.cond.Wait()
+	}
+
+	// the caller is free to reuse b as soon as we return
+	buf := make([]byte, len(b))
+	copy(buf, b)
+	q.bufs = append(q.bufs, buf)
+	q.size += len(buf)
+	q.cond.Broadcast()
+	return len(b), dropped, nil
+}
+
+// pop waits for the next queued write, returns false once the
+// queue has been closed
+func (q *writeQueue) pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.bufs) == 0 && !q.closed && !q.closing {
+		q.cond.Wait()
+	}
+	if len(q.bufs) == 0 {
+		return nil, false
+	}
+	b := q.bufs[0]
+	q.bufs[0] = nil
+	q.bufs = q.bufs[1:]
+	q.size -= len(b)
+	// wake up any writer blocked on a full queue
+	q.cond.Broadcast()
+	return b, true
+}
+
+// drain is the pacing goroutine, it pushes queued writes through the
+// connection limiters until the queue is closed or a write fails
+func (q *writeQueue) drain(lc LimitedConn) {
+	defer close(q.done)
+	defer contain("write queue", q.fail, lc)
+	for {
+		b, ok := q.pop()
+		if !ok {
+			return
+		}
+		if _, err := lc.write(b); err != nil {
+			q.fail(err)
+			lc.listener.reportError(lc, err)
+			return
+		}
+	}
+}
+
+// fail records the draining error and discards whatever is pending
+func (q *writeQueue) fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.err = err
+	q.closed = true
+	q.bufs = nil
+	q.size = 0
+	q.cond.Broadcast()
+}
+
+// close discards pending writes and stops the pacing goroutine
+func (q *writeQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.bufs = nil
+	q.size = 0
+	q.cond.Broadcast()
+}
+
+// setFlushTimeout sets how long close flushes pending writes for
+func (q *writeQueue) setFlushTimeout(timeout time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.flushTimeout = timeout
+}
+
+func (q *writeQueue) getFlushTimeout() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.flushTimeout
+}
+
+// flush stops taking writes and waits up to timeout for the pending ones
+// to go out, discarding whatever is left after that
+func (q *writeQueue) flush(timeout time.Duration) error {
+	q.mu.Lock()
+	q.closing = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-q.done:
+	case <-timer.C:
+		q.close()
+		return ErrFlushTimeout
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.err
+}
@@ -0,0 +1,83 @@
+package limlistener
+
+import rlimit "golang.org/x/time/rate"
+
+// SetReadLimits defines global and per-connection ingress limits, 0 for
+// none. Reads are throttled by holding back the next read from the socket
+// (never reading more than an MTU at a time) so the kernel receive buffer
+// fills up and TCP flow control slows the sender down, instead of data
+// piling up in the process. The receive buffer still absorbs a burst of its
+// own size, see SetSocketBuffers to keep it in line with the limits.
+// Bytes already buffered by Peek or sniffing are never throttled.
+func (ll *LimitedListener) SetReadLimits(globalLimit, connLimit int) {
+	ll.mu.Lock()
+	ll.readGlobal.SetLimit(readLimit(globalLimit))
+	ll.readConnLimit = connLimit
+	ll.mu.Unlock()
+
+	for _, conn := range ll.connections() {
+		conn.readLimiter.SetLimit(readLimit(connLimit))
+		conn.tuneBuffers()
+	}
+}
+
+// readLimit turns a limit of 0 into no limit
+func readLimit(limit int) rlimit.Limit {
+	if limit <= 0 {
+		return rlimit.Inf
+	}
+	return rlimit.Limit(limit)
+}
+
+// readThrottled tells if reads of the connection have any limit
+func (lc LimitedConn) readThrottled() bool {
+	if lc.readLimiter == nil || lc.exempt.is() {
+		return false
+	}
+	return lc.readLimiter.Limit() != rlimit.Inf || lc.listener.readGlobal.Limit() != rlimit.Inf
+}
+
+// throttledRead reads at most an MTU from the socket and then waits for
+// the bytes read, so the next read is held back at the limits. The wait
+// gives up at the read deadline.
+func (lc LimitedConn) throttledRead(b []byte) (int, error) {
+	// what was peeked at already left the socket
+	if lc.peek.buffered() > 0 {
+		return lc.peek.read(b)
+	}
+	if mtu := lc.mtu.get(); len(b) > mtu {
+		b = b[:mtu]
+	}
+	n, err := lc.peek.read(b)
+	if n > 0 {
+		ctx, cancel := lc.closing.readContext()
+		defer cancel()
+		lc.readLimiter.ensureBurst(n)
+		lc.listener.readGlobal.ensureBurst(n)
+		if werr := lc.readLimiter.WaitN(ctx, n); werr != nil && err == nil {
+			err = waitErr(ctx, werr)
+		}
+		if werr := lc.listener.readGlobal.WaitN(ctx, n); werr != nil && err == nil {
+			err = waitErr(ctx, werr)
+		}
+	}
+	return n, err
+}
+
+// chargedRead reads at most a chunk from the socket and then waits for the
+// bytes read on the write limiters, for connections whose traffic in both
+// directions comes out of the same budget
+func (lc LimitedConn) chargedRead(b []byte) (int, error) {
+	if size := lc.chunkSize(); len(b) > size {
+		b = b[:size]
+	}
+	n, err := lc.conn.Read(b)
+	if n > 0 {
+		ctx, cancel := lc.closing.readContext()
+		defer cancel()
+		if werr := lc.waitN(ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,60 @@
+package limlistener
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadLimitBackpressuresSender(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetReadLimits(0, 20000)
+	conn, client := acceptPair(t, ll)
+	// keep the kernel from buffering much on either side
+	conn.conn.(*net.TCPConn).SetReadBuffer(16 << 10)
+	client.(*net.TCPConn).SetWriteBuffer(16 << 10)
+
+	var sent int64
+	go func() {
+		b := make([]byte, 4096)
+		for i := 0; i < 512; i++ {
+			n, err := client.Write(b)
+			atomic.AddInt64(&sent, int64(n))
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go io.Copy(ioutil.Discard, conn)
+
+	time.Sleep(time.Second)
+	// the sender is held back by TCP flow control: past what was read
+	// only the socket buffers worth made it out, not the 2 MB written
+	s := atomic.LoadInt64(&sent)
+	if s > 512<<10 {
+		t.Errorf("the sender got %d bytes out in 1s reading at 20000 bytes/sec", s)
+	}
+	if s == 0 {
+		t.Error("nothing was sent")
+	}
+	t.Logf("%d bytes sent in 1s", s)
+}
+
+func TestReadLimitRate(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetReadLimits(0, 10000)
+	conn, client := acceptPair(t, ll)
+	go client.Write(make([]byte, 3024))
+
+	// a full bucket and then 2000 bytes at 10000 bytes/sec
+	start := time.Now()
+	if _, err := io.ReadFull(conn, make([]byte, 3024)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("reading 3024 bytes took %v, want about 200ms", elapsed)
+	}
+}
@@ -0,0 +1,292 @@
+package limlistener
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// RegistryStats describe a registry of per-key limiters
+type RegistryStats struct {
+	// keys currently tracked and the most allowed, 0 for no cap
+	Entries    int
+	MaxEntries int
+	// entries dropped to make room for new keys
+	Evictions int64
+}
+
+// KeyStats describe a per-key limiter as it goes away
+type KeyStats struct {
+	// when it was created and last acquired or released
+	Created  time.Time
+	LastUsed time.Time
+	// connections that ever held it and the ones still holding it
+	Conns int
+	Open  int
+	// last state of its bucket
+	Limiter LimiterState
+	// pushed out by the entries cap rather than gone idle
+	Evicted bool
+}
+
+// keyedLimiter is a rate limiter shared by all connections with the same key
+type keyedLimiter struct {
+	key     string
+	limiter *limiter
+	// connections currently holding it
+	refs int
+	// position in the idle list, nil while in use
+	elem     *list.Element
+	created  time.Time
+	lastUsed time.Time
+	conns    int
+}
+
+// removedKey is a key waiting to be reported to the idle hook
+type removedKey struct {
+	key   string
+	stats KeyStats
+}
+
+// registry keeps one rate limiter per key (eg. client IP). Without a cap
+// entries live for as long as there are connections using them, with one
+// idle entries are kept (so reconnecting keeps the bucket state) and the
+// least recently used ones are evicted once the cap is reached. Entries in
+// use are never evicted, their keys would get a fresh bucket on the next
+// connection and escape their limit, the cap is exceeded until they're
+// released instead.
+type registry struct {
+	mu       sync.Mutex
+	limit    int
+	burst    int
+	limiters map[string]*keyedLimiter
+	// limits of the keys that don't get the common one
+	keyLimits map[string]int
+	// entries nobody holds, most recently released at the front
+	lru        *list.List
+	maxEntries int
+	evictions  int64
+	// called for every key going away, and the ones yet to be reported
+	onIdle  func(key string, stats KeyStats)
+	removed []removedKey
+}
+
+func newRegistry(limit, burst, maxEntries int) *registry {
+	return &registry{
+		limit:      limit,
+		burst:      burst,
+		limiters:   make(map[string]*keyedLimiter),
+		keyLimits:  make(map[string]int),
+		lru:        list.New(),
+		maxEntries: maxEntries,
+	}
+}
+
+// acquire returns the limiter for key, creating it if needed
+func (r *registry) acquire(key string) *limiter {
+	r.mu.Lock()
+	// report after unlocking
+	defer r.notify()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	kl, ok := r.limiters[key]
+	if !ok {
+		kl = &keyedLimiter{
+			key:     key,
+			limiter: newLimiter(rlimit.Limit(r.keyLimit(key)), r.burst),
+			created: now,
+		}
+		r.limiters[key] = kl
+		r.evict()
+	} else if kl.elem != nil {
+		// in use again
+		r.lru.Remove(kl.elem)
+		kl.elem = nil
+	}
+	kl.refs++
+	kl.conns++
+	kl.lastUsed = now
+	return kl.limiter
+}
+
+// release drops a connection's hold on key's limiter l
+func (r *registry) release(key string, l *limiter) {
+	r.mu.Lock()
+	defer r.notify()
+	defer r.mu.Unlock()
+
+	kl, ok := r.limiters[key]
+	// not tracked anymore
+	if !ok || kl.limiter != l {
+		return
+	}
+	kl.refs--
+	kl.lastUsed = time.Now()
+	if kl.refs > 0 {
+		return
+	}
+	if r.maxEntries <= 0 {
+		r.remove(kl, false)
+		return
+	}
+	kl.elem = r.lru.PushFront(kl)
+	// might have been holding the registry over its cap
+	r.evict()
+}
+
+// evict drops the least recently used idle entries over the cap, while
+// every entry is in use it stays over it. Must be called with the lock held.
+func (r *registry) evict() {
+	for r.maxEntries > 0 && len(r.limiters) > r.maxEntries && r.lru.Len() > 0 {
+		r.remove(r.lru.Back().Value.(*keyedLimiter), true)
+		r.evictions++
+	}
+}
+
+// remove must be called with the lock held
+func (r *registry) remove(kl *keyedLimiter, evicted bool) {
+	if kl.elem != nil {
+		r.lru.Remove(kl.elem)
+		kl.elem = nil
+	}
+	delete(r.limiters, kl.key)
+	if r.onIdle != nil {
+		r.removed = append(r.removed, removedKey{
+			key:   kl.key,
+			stats: kl.stats(evicted),
+		})
+	}
+}
+
+func (kl *keyedLimiter) stats(evicted bool) KeyStats {
+	return KeyStats{
+		Created:  kl.created,
+		LastUsed: kl.lastUsed,
+		Conns:    kl.conns,
+		Open:     kl.refs,
+		Limiter:  kl.limiter.State(),
+		Evicted:  evicted,
+	}
+}
+
+// keyStats describes every key currently tracked
+func (r *registry) keyStats() map[string]KeyStats {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := make(map[string]KeyStats, len(r.limiters))
+	for key, kl := range r.limiters {
+		stats[key] = kl.stats(false)
+	}
+	return stats
+}
+
+// notify reports the removed keys to the idle hook,
+// must be called without the lock held
+func (r *registry) notify() {
+	r.mu.Lock()
+	removed, hook := r.removed, r.onIdle
+	r.removed = nil
+	r.mu.Unlock()
+
+	for _, rk := range removed {
+		hook(rk.key, rk.stats)
+	}
+}
+
+// setOnIdle sets the hook called for every key going away
+func (r *registry) setOnIdle(hook func(key string, stats KeyStats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onIdle = hook
+}
+
+// setMaxEntries caps how many keys are tracked, 0 for no cap
+func (r *registry) setMaxEntries(maxEntries int) {
+	r.mu.Lock()
+	defer r.notify()
+	defer r.mu.Unlock()
+
+	r.maxEntries = maxEntries
+	if maxEntries <= 0 {
+		// idle entries aren't kept without a cap
+		for _, kl := range r.limiters {
+			if kl.refs <= 0 {
+				r.remove(kl, false)
+			}
+		}
+		return
+	}
+	r.evict()
+}
+
+func (r *registry) stats() RegistryStats {
+	if r == nil {
+		return RegistryStats{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return RegistryStats{
+		Entries:    len(r.limiters),
+		MaxEntries: r.maxEntries,
+		Evictions:  r.evictions,
+	}
+}
+
+// ensureBurst makes sure every key's limiter can grant n tokens at once
+func (r *registry) ensureBurst(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.burst < n {
+		r.burst = n
+	}
+	for _, kl := range r.limiters {
+		kl.limiter.ensureBurst(n)
+	}
+}
+
+// setLimit updates the limit of every key
+func (r *registry) setLimit(limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.limit = limit
+	for key, kl := range r.limiters {
+		if _, ok := r.keyLimits[key]; !ok {
+			kl.limiter.SetLimit(rlimit.Limit(limit))
+		}
+	}
+}
+
+// setKeyLimit gives key its own limit, a negative one
+// puts it back on the common limit
+func (r *registry) setKeyLimit(key string, limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if limit < 0 {
+		delete(r.keyLimits, key)
+	} else {
+		r.keyLimits[key] = limit
+	}
+	if kl, ok := r.limiters[key]; ok {
+		kl.limiter.SetLimit(rlimit.Limit(r.keyLimit(key)))
+	}
+}
+
+// keyLimit returns the limit of key, must be called with the lock held
+func (r *registry) keyLimit(key string) int {
+	if limit, ok := r.keyLimits[key]; ok {
+		return limit
+	}
+	return r.limit
+}
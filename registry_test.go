@@ -0,0 +1,70 @@
+package limlistener
+
+import "testing"
+
+func TestRegistryKeepsLimitersInUse(t *testing.T) {
+	r := newRegistry(1024, 1024, 2)
+	a := r.acquire("a")
+	b := r.acquire("b")
+	// all in use, over the cap rather than evicting one
+	c := r.acquire("c")
+	if stats := r.stats(); stats.Entries != 3 || stats.Evictions != 0 {
+		t.Errorf("stats = %+v, want 3 entries and no evictions", stats)
+	}
+	// reconnecting still shares the bucket, no way around the limit
+	if again := r.acquire("a"); again != a {
+		t.Error("key in use got a new limiter")
+	}
+	r.release("a", a)
+
+	// b going idle brings the registry back down to its cap
+	r.release("b", b)
+	if stats := r.stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v, want 2 entries and 1 eviction", stats)
+	}
+	if _, ok := r.keyStats()["b"]; ok {
+		t.Error("idle key not evicted")
+	}
+	r.release("a", a)
+	r.release("c", c)
+	if stats := r.stats(); stats.Entries != 2 {
+		t.Errorf("stats = %+v, want idle keys kept up to the cap", stats)
+	}
+}
+
+func TestRegistryEvictsLeastRecentlyUsed(t *testing.T) {
+	r := newRegistry(1024, 1024, 2)
+	for _, key := range []string{"a", "b"} {
+		r.release(key, r.acquire(key))
+	}
+	// a is used again, b is the least recently used now
+	r.release("a", r.acquire("a"))
+	r.release("c", r.acquire("c"))
+	keys := r.keyStats()
+	if _, ok := keys["b"]; ok || len(keys) != 2 {
+		t.Errorf("tracked %v, want a and c", keys)
+	}
+}
+
+func TestIPLimitNotEscapedByChurn(t *testing.T) {
+	l := newAddrListener(t, "192.0.2.1", "192.0.2.2", "192.0.2.1")
+	ll := NewWithListener(l)
+	defer ll.Close()
+	ll.SetIPLimit(64 * 1024)
+	ll.SetIPMaxEntries(1)
+
+	var conns []LimitedConn
+	for i := 0; i < 3; i++ {
+		conn, err := ll.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ll.CloseConnection(conn)
+		conns = append(conns, conn.(LimitedConn))
+	}
+	// the second IP pushing the registry over its cap didn't
+	// get the first one a fresh bucket
+	if conns[2].keyLimiter != conns[0].keyLimiter {
+		t.Error("IP with an open connection lost its limiter")
+	}
+}
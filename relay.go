@@ -0,0 +1,98 @@
+package limlistener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// size of the buffers shared by relays
+	relayBufferSize = 32 * 1024
+)
+
+// relayBuffers is the pool of copy buffers shared by all relays
+var relayBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, relayBufferSize)
+		return &b
+	},
+}
+
+// RelayStats has the combined outcome of a relay
+type RelayStats struct {
+	// bytes copied from a to b and from b to a
+	AToB int64
+	BToA int64
+	// how long the relay ran for
+	Duration time.Duration
+}
+
+// closeWriter is implemented by connections that support half-close
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Relay bi-directionally copies between a and b (eg. a limited connection and
+// its upstream) until both directions are done. When one side is done sending
+// the other one gets its write side closed, if supported, so half-closes are
+// propagated. If either direction fails both connections are closed to unblock
+// the other one and the first error is returned, otherwise closing them is up
+// to the caller.
+func Relay(a, b net.Conn) (RelayStats, error) {
+	var stats RelayStats
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		stats.AToB, errs[0] = relayHalf(b, a)
+	}()
+	go func() {
+		defer wg.Done()
+		stats.BToA, errs[1] = relayHalf(a, b)
+	}()
+	wg.Wait()
+
+	stats.Duration = time.Since(start)
+	for _, err := range errs {
+		if err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// relayHalf copies one direction of a relay
+func relayHalf(dst, src net.Conn) (n int64, err error) {
+	buf := relayBuffers.Get().(*[]byte)
+	defer relayBuffers.Put(buf)
+	defer contain("relay", func(perr error) { err = perr }, src, dst)
+
+	n, err = io.CopyBuffer(writerOnly{dst}, src, *buf)
+	if err != nil {
+		// a closed connection is how the other direction unblocks us
+		if errors.Is(err, net.ErrClosed) {
+			return n, nil
+		}
+		dst.Close()
+		src.Close()
+		reportConnError(err, src, dst)
+		return n, err
+	}
+	// src is done sending, let dst know
+	if cw, ok := dst.(closeWriter); ok {
+		cw.CloseWrite()
+	}
+	return n, nil
+}
+
+// writerOnly hides any io.ReaderFrom of the destination so the copy
+// always goes through Write and the shared buffer
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,54 @@
+package limlistener
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestRelay(t *testing.T) {
+	ll := newTestListener(t)
+	conn, client := acceptPair(t, ll)
+
+	// an upstream answering 3000 bytes once the request is done
+	upstream := listen(t)
+	go func() {
+		c, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(ioutil.Discard, c)
+		c.Write(make([]byte, 3000))
+	}()
+	up, err := net.Dial("tcp", upstream.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+
+	read := make(chan int64, 1)
+	go func() {
+		client.Write(make([]byte, 5000))
+		// the half-close makes its way to the upstream
+		client.(*net.TCPConn).CloseWrite()
+		n, _ := io.Copy(ioutil.Discard, client)
+		read <- n
+	}()
+
+	stats, err := Relay(conn, up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.AToB != 5000 || stats.BToA != 3000 {
+		t.Errorf("relayed %d and %d bytes, want 5000 and 3000", stats.AToB, stats.BToA)
+	}
+	// and back to the client once the upstream is done
+	if n := <-read; n != 3000 {
+		t.Errorf("client read %d bytes, want 3000", n)
+	}
+	if wire := conn.Stats().WireBytes; wire != 3000 {
+		t.Errorf("%d bytes accounted, want 3000", wire)
+	}
+}
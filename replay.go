@@ -0,0 +1,113 @@
+package limlistener
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrBadCapture is returned when reading something that isn't a capture stream
+var ErrBadCapture = errors.New("limlistener: not a capture stream")
+
+// CaptureRecord is a chunk of traffic read back from a capture
+type CaptureRecord struct {
+	// when the chunk was written
+	Time time.Time
+	// id of the connection it was written on
+	ID   int
+	Data []byte
+}
+
+// CaptureReader reads back the records of a capture stream
+type CaptureReader struct {
+	r io.Reader
+}
+
+// NewCaptureReader checks that r is a capture stream
+// and returns a reader for its records
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrBadCapture
+		}
+		return nil, err
+	}
+	if string(magic) != captureMagic {
+		return nil, ErrBadCapture
+	}
+	return &CaptureReader{r: r}, nil
+}
+
+// Next returns the next record, io.EOF once there are no more
+func (cr *CaptureReader) Next() (CaptureRecord, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(cr.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return CaptureRecord{}, ErrBadCapture
+		}
+		return CaptureRecord{}, err
+	}
+	// a corrupt length mustn't get a huge buffer allocated
+	size := binary.BigEndian.Uint32(hdr[12:])
+	if size > MaxCaptureRecord {
+		return CaptureRecord{}, fmt.Errorf("%w: record of %d bytes", ErrBadCapture, size)
+	}
+	rec := CaptureRecord{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(hdr[0:]))),
+		ID:   int(binary.BigEndian.Uint32(hdr[8:])),
+		Data: make([]byte, size),
+	}
+	if _, err := io.ReadFull(cr.r, rec.Data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return CaptureRecord{}, ErrBadCapture
+		}
+		return CaptureRecord{}, err
+	}
+	return rec, nil
+}
+
+// Replay writes the records of connection id (every record when id is
+// negative) read from the capture src into dst (eg. a LimitedConn) at the
+// pacing they were originally observed with. A scale above 1 replays faster
+// (2 is twice as fast), below 1 slower and 0 as fast as dst takes them.
+// Returns the number of bytes replayed.
+func Replay(dst io.Writer, src io.Reader, id int, scale float64) (int64, error) {
+	cr, err := NewCaptureReader(src)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	var first time.Time
+	start := time.Now()
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if id >= 0 && rec.ID != id {
+			continue
+		}
+		if first.IsZero() {
+			first = rec.Time
+		}
+		// wait until the record's (scaled) offset into the session
+		if scale > 0 {
+			offset := time.Duration(float64(rec.Time.Sub(first)) / scale)
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		w, err := dst.Write(rec.Data)
+		n += int64(w)
+		if err != nil {
+			return n, err
+		}
+	}
+}
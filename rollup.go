@@ -0,0 +1,141 @@
+package limlistener
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// keys a rollup keeps by default, see Rollup
+	DefaultRollupKeys = 100
+	// key the connections past a rollup's keys are added up under
+	RollupOther = "other"
+)
+
+// RollupStats are the counters of the open connections sharing a key
+type RollupStats struct {
+	Conns          int
+	WireBytes      int64
+	LogicalBytes   int64
+	DroppedBytes   int64
+	RejectedWrites int64
+	// time their writes waited on the limiters
+	Wait time.Duration
+}
+
+func (rs *RollupStats) add(stats ConnStats) {
+	rs.Conns++
+	rs.WireBytes += stats.WireBytes
+	rs.LogicalBytes += stats.LogicalBytes
+	rs.DroppedBytes += stats.DroppedBytes
+	rs.RejectedWrites += stats.RejectedWrites
+	rs.Wait += stats.Wait.Sum
+}
+
+func (rs *RollupStats) merge(other RollupStats) {
+	rs.Conns += other.Conns
+	rs.WireBytes += other.WireBytes
+	rs.LogicalBytes += other.LogicalBytes
+	rs.DroppedBytes += other.DroppedBytes
+	rs.RejectedWrites += other.RejectedWrites
+	rs.Wait += other.Wait
+}
+
+// Pivot tells the key a connection is rolled up under,
+// an empty one leaves it out
+type Pivot func(conn LimitedConn) string
+
+// ByClass rolls connections up by class, leaving out unclassified ones
+func ByClass(conn LimitedConn) string {
+	return conn.Class()
+}
+
+// ByPrefix returns a pivot rolling connections up by the network of their
+// client IP, its first v4Bits (eg. 24) or v6Bits (eg. 48) bits
+func ByPrefix(v4Bits, v6Bits int) Pivot {
+	return func(conn LimitedConn) string {
+		ip := addrIP(conn.RemoteAddr())
+		if ip == nil {
+			return ""
+		}
+		bits, size := v6Bits, 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits, size = ip4, v4Bits, 32
+		}
+		if bits <= 0 || bits > size {
+			bits = size
+		}
+		return ip.Mask(net.CIDRMask(bits, size)).String() + "/" + strconv.Itoa(bits)
+	}
+}
+
+// ByContext returns a pivot rolling connections up by the value of key in
+// their context (eg. a tenant ID set through SetConnContext)
+func ByContext(key interface{}) Pivot {
+	return func(conn LimitedConn) string {
+		v := conn.Context().Value(key)
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+}
+
+// Nested returns a pivot rolling connections up by every one of pivots at
+// once, their keys joined by "|" (eg. "gold|10.0.0.0/24")
+func Nested(pivots ...Pivot) Pivot {
+	return func(conn LimitedConn) string {
+		keys := make([]string, len(pivots))
+		for i, pivot := range pivots {
+			if keys[i] = pivot(conn); keys[i] == "" {
+				return ""
+			}
+		}
+		return strings.Join(keys, "|")
+	}
+}
+
+// Rollup adds up the counters of the open connections by the key pivot puts
+// them under, so exporters get aggregates without going through every
+// connection. At most maxKeys keys are returned (DefaultRollupKeys when 0):
+// the busiest ones by bytes written, the rest being added up under
+// RollupOther.
+func (ll *LimitedListener) Rollup(pivot Pivot, maxKeys int) map[string]RollupStats {
+	if maxKeys <= 0 {
+		maxKeys = DefaultRollupKeys
+	}
+	rollup := make(map[string]RollupStats)
+	for _, conn := range ll.connections() {
+		key := pivot(*conn)
+		if key == "" {
+			continue
+		}
+		rs := rollup[key]
+		rs.add(conn.listenerStats())
+		rollup[key] = rs
+	}
+	if len(rollup) <= maxKeys {
+		return rollup
+	}
+
+	// keep the busiest keys, leaving room for the rest
+	keys := make([]string, 0, len(rollup))
+	for key := range rollup {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return rollup[keys[i]].WireBytes > rollup[keys[j]].WireBytes
+	})
+	var other RollupStats
+	for _, key := range keys[maxKeys-1:] {
+		other.merge(rollup[key])
+		delete(rollup, key)
+	}
+	other.merge(rollup[RollupOther])
+	rollup[RollupOther] = other
+	return rollup
+}
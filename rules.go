@@ -0,0 +1,99 @@
+package limlistener
+
+// ConnCountRule changes the listener limits while the number
+// of open connections is within a range
+type ConnCountRule struct {
+	// the rule holds while there are at least MinConns open connections
+	// and, unless it's 0, at most MaxConns
+	MinConns int
+	MaxConns int
+	// limits that apply while the rule holds,
+	// 0 keeps the one set by SetLimits
+	GlobalLimit int
+	ConnLimit   int
+}
+
+func (r ConnCountRule) holds(conns int) bool {
+	return conns >= r.MinConns && (r.MaxConns == 0 || conns <= r.MaxConns)
+}
+
+// SetConnCountRules replaces the connection count rules, eg.
+//
+//	ll.SetConnCountRules(
+//		// above 100 connections drop to 1 MB/sec each
+//		limlistener.ConnCountRule{MinConns: 101, ConnLimit: 1 * MEGABYTE},
+//		// below 10 connections raise to 10 MB/sec each
+//		limlistener.ConnCountRule{MaxConns: 9, ConnLimit: 10 * MEGABYTE},
+//	)
+//
+// Rules are evaluated in order as connections are accepted and closed (or
+// go inactive, see SetInactiveAfter), the first one holding applies. When none does the limits set by SetLimits do.
+func (ll *LimitedListener) SetConnCountRules(rules ...ConnCountRule) {
+	ll.mu.Lock()
+	ll.rules = rules
+	// force the limits to be recomputed
+	ll.activeRule = -2
+	ll.mu.Unlock()
+
+	ll.applyRules()
+}
+
+// applyRules recomputes the limits if a different rule holds now
+func (ll *LimitedListener) applyRules() {
+	ll.mu.Lock()
+	conns := ll.activeConns()
+	active := -1
+	for i, r := range ll.rules {
+		if r.holds(conns) {
+			active = i
+			break
+		}
+	}
+	changed := active != ll.activeRule
+	ll.activeRule = active
+	ll.mu.Unlock()
+
+	if changed {
+		ll.applyLimits()
+	}
+}
+
+// applyLimits sets the limits of the rule that holds,
+// or the ones set by SetLimits, on the listener and all its connections
+func (ll *LimitedListener) applyLimits() {
+	ll.mu.Lock()
+	globalLimit, connLimit := ll.baseGlobalLimit, ll.baseConnLimit
+	setGlobal := ll.limitsSet
+	if ll.activeRule >= 0 {
+		r := ll.rules[ll.activeRule]
+		if r.GlobalLimit > 0 {
+			globalLimit = r.GlobalLimit
+			setGlobal = true
+		}
+		if r.ConnLimit > 0 {
+			connLimit = r.ConnLimit
+		}
+	}
+	ll.connLimit = connLimit
+	global := ll.global
+	budget := ll.budget
+	clock := ll.clock
+	mtu := ll.mtu
+	ll.mu.Unlock()
+
+	// set the global limiter
+	if setGlobal {
+		global.setLimitSmoothed(globalLimit, ll.limitTransition(), mtu)
+		// the budget might need to tighten the new limit
+		if budget != nil {
+			budget.attach(global.limiter, globalLimit, clock)
+		}
+	}
+	// update each running connection's rate limiter
+	for _, conn := range ll.connections() {
+		ll.mu.Lock()
+		limit := ll.connLimitFor(conn)
+		ll.mu.Unlock()
+		conn.setLimit(limit)
+	}
+}
@@ -0,0 +1,157 @@
+package limlistener
+
+import (
+	"sync"
+	"time"
+)
+
+// saturationSamples are how many times the global limiter
+// is sampled within a saturation window
+const saturationSamples = 50
+
+// LoadShedding configures the listener overload protection
+type LoadShedding struct {
+	// how far back saturation is measured
+	Window time.Duration
+	// saturation above which new connections are refused, 0 only
+	// measures it
+	Threshold float64
+	// saturation below which connections are accepted again,
+	// 0 uses Threshold
+	Recover float64
+	// told every time the listener starts or stops shedding
+	OnChange func(shedding bool, saturation float64)
+}
+
+// saturationMonitor samples whether the global limiter is exhausted
+type saturationMonitor struct {
+	mu       sync.Mutex
+	cfg      LoadShedding
+	samples  [saturationSamples]bool
+	n        int
+	next     int
+	shedding bool
+	stop     chan struct{}
+}
+
+// SetLoadShedding starts measuring the global saturation, the fraction of
+// the last window the global limiter had no room for a full chunk, and
+// refuses new connections while it stays above the threshold, accepting
+// them again once it drops below the recovery level. A zero window stops it.
+func (ll *LimitedListener) SetLoadShedding(cfg LoadShedding) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	// stop measuring the previous window
+	if ll.saturation != nil {
+		close(ll.saturation.stop)
+		ll.saturation = nil
+	}
+	if cfg.Window <= 0 {
+		return
+	}
+	if cfg.Recover <= 0 || cfg.Recover > cfg.Threshold {
+		cfg.Recover = cfg.Threshold
+	}
+	sm := &saturationMonitor{
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+	ll.saturation = sm
+	go ll.sample(sm)
+}
+
+// Saturation returns the fraction of the last load shedding window the
+// global limiter was exhausted, 0 if not measured
+func (ll *LimitedListener) Saturation() float64 {
+	ll.mu.Lock()
+	sm := ll.saturation
+	ll.mu.Unlock()
+
+	if sm == nil {
+		return 0
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.level()
+}
+
+// shedding tells if new connections are being refused
+func (ll *LimitedListener) shedding() bool {
+	ll.mu.Lock()
+	sm := ll.saturation
+	ll.mu.Unlock()
+
+	if sm == nil {
+		return false
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.shedding
+}
+
+// sample periodically checks if the global limiter has room for a chunk
+func (ll *LimitedListener) sample(sm *saturationMonitor) {
+	ticker := time.NewTicker(sm.cfg.Window / saturationSamples)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sm.stop:
+			return
+		case <-ticker.C:
+		}
+		ll.mu.Lock()
+		mtu := ll.mtu
+		ll.mu.Unlock()
+
+		changed, shedding, saturation := sm.add(ll.global.delay(mtu) > 0)
+		if changed && sm.cfg.OnChange != nil {
+			sm.cfg.OnChange(shedding, saturation)
+		}
+	}
+}
+
+// add records a sample, returning if shedding started or stopped,
+// whether it's shedding and the saturation level
+func (sm *saturationMonitor) add(exhausted bool) (changed, shedding bool, level float64) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.samples[sm.next] = exhausted
+	sm.next = (sm.next + 1) % len(sm.samples)
+	if sm.n < len(sm.samples) {
+		sm.n++
+	}
+	level = sm.level()
+	// decide once there's a whole window to go by
+	if sm.cfg.Threshold <= 0 || sm.n < len(sm.samples) {
+		return false, false, level
+	}
+	switch {
+	case !sm.shedding && level > sm.cfg.Threshold:
+		sm.shedding = true
+		changed = true
+	case sm.shedding && level < sm.cfg.Recover:
+		sm.shedding = false
+		changed = true
+	}
+	return changed, sm.shedding, level
+}
+
+// level is the fraction of exhausted samples,
+// must be called with the lock held
+func (sm *saturationMonitor) level() float64 {
+	if sm.n == 0 {
+		return 0
+	}
+	exhausted := 0
+	for i := 0; i < sm.n; i++ {
+		if sm.samples[i] {
+			exhausted++
+		}
+	}
+	return float64(exhausted) / float64(sm.n)
+}
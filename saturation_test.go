@@ -0,0 +1,64 @@
+package limlistener
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaturationHysteresis(t *testing.T) {
+	sm := &saturationMonitor{cfg: LoadShedding{Threshold: 0.5, Recover: 0.2}}
+	// nothing decided before a whole window
+	for i := 0; i < saturationSamples-1; i++ {
+		if changed, _, _ := sm.add(true); changed {
+			t.Fatalf("shedding changed after %d samples", i+1)
+		}
+	}
+	if changed, shedding, level := sm.add(true); !changed || !shedding || level != 1 {
+		t.Fatalf("add() = %v, %v, %v, want shedding to start at 1", changed, shedding, level)
+	}
+	// keeps shedding between the recovery level and the threshold
+	var samples int
+	for {
+		changed, shedding, level := sm.add(false)
+		samples++
+		if changed {
+			if shedding || level >= 0.2 {
+				t.Errorf("add() = %v, %v, %v, want shedding to stop below 0.2", changed, shedding, level)
+			}
+			break
+		}
+	}
+	if want := saturationSamples*4/5 + 1; samples != want {
+		t.Errorf("recovered after %d samples, want %d", samples, want)
+	}
+}
+
+func TestLoadSheddingSignal(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetLimits(1000, 1000)
+	changes := make(chan bool, 2)
+	ll.SetLoadShedding(LoadShedding{
+		Window:    100 * time.Millisecond,
+		Threshold: 0.5,
+		OnChange: func(shedding bool, saturation float64) {
+			changes <- shedding
+		},
+	})
+	// a second to refill at 1000 bytes/sec
+	ll.global.limiter.take(ll.mtu)
+
+	select {
+	case shedding := <-changes:
+		if !shedding {
+			t.Error("stopped shedding, want it to start")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("never started shedding")
+	}
+	if saturation := ll.Saturation(); saturation <= 0.5 {
+		t.Errorf("Saturation() = %v, want above the threshold", saturation)
+	}
+	if !ll.shedding() {
+		t.Error("not refusing connections while shedding")
+	}
+}
@@ -0,0 +1,79 @@
+package limlistener
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LimitStep is a per-connection limit taking effect
+// some time after the connection was accepted
+type LimitStep struct {
+	After time.Duration
+	Limit int
+}
+
+// limitSchedule stops the steps of a connection
+type limitSchedule struct {
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// replace stops the running steps, handing out the channel
+// stopping the next ones (nil to just stop them)
+func (ls *limitSchedule) replace(next chan struct{}) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.stop != nil {
+		close(ls.stop)
+	}
+	ls.stop = next
+}
+
+// SetLimitSchedule changes the limit of conn over its lifetime, eg. full
+// speed for 10s and then 1 MB/s, each step taking effect its After since the
+// connection was accepted (right away if that's passed already). Changes are
+// recorded in the audit trail by "schedule". A new schedule replaces the
+// previous one, an empty one stops it; it also stops with the connection.
+func (ll *LimitedListener) SetLimitSchedule(conn net.Conn, steps []LimitStep) {
+	lc := conn.(LimitedConn)
+	// only accepted connections have a schedule
+	if lc.schedule == nil {
+		return
+	}
+	sorted := make([]LimitStep, len(steps))
+	copy(sorted, steps)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].After < sorted[j].After
+	})
+
+	if len(sorted) == 0 {
+		lc.schedule.replace(nil)
+		return
+	}
+	stop := make(chan struct{})
+	lc.schedule.replace(stop)
+	go ll.runSchedule(lc, sorted, stop)
+}
+
+// runSchedule applies the steps as they're due
+func (ll *LimitedListener) runSchedule(lc LimitedConn, steps []LimitStep, stop chan struct{}) {
+	for _, step := range steps {
+		clock := ll.getClock()
+		if wait := step.After - clock.Now().Sub(lc.accepted); wait > 0 {
+			select {
+			case <-stop:
+				return
+			case <-clock.After(wait):
+			}
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		lc.SetLimitBy("schedule", step.Limit)
+	}
+}
@@ -0,0 +1,25 @@
+package limlistener
+
+import "net"
+
+// Serve runs the accept loop calling handler on its own goroutine for each
+// connection and cleaning it up (see CloseConnection) once handler returns.
+// Panics in handler only take down their connection, they're logged (or
+// handed to the error handler) and counted in PanicStats.
+// Serve returns the first error Accept hits, eg. once the listener is closed
+// (temporary ones are retried as configured by SetAcceptBackoff).
+func (ll *LimitedListener) Serve(handler func(net.Conn)) error {
+	for {
+		conn, err := ll.Accept()
+		if err != nil {
+			return err
+		}
+		go ll.serveConn(conn, handler)
+	}
+}
+
+func (ll *LimitedListener) serveConn(conn net.Conn, handler func(net.Conn)) {
+	defer ll.CloseConnection(conn)
+	defer contain("serve", nil, conn)
+	handler(conn)
+}
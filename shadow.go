@@ -0,0 +1,88 @@
+package limlistener
+
+import (
+	"sync"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// shadowLimiter is a limiter evaluated alongside the enforced ones
+// but never waited on, nil when there's no shadow limit
+type shadowLimiter struct {
+	mu      sync.Mutex
+	limiter *limiter
+}
+
+func (s *shadowLimiter) get() *limiter {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.limiter
+}
+
+// set replaces the shadow limit, 0 removes it
+func (s *shadowLimiter) set(limit, burst int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case limit <= 0:
+		s.limiter = nil
+	case s.limiter == nil:
+		s.limiter = newLimiter(rlimit.Limit(limit), burst)
+	default:
+		s.limiter.SetLimit(rlimit.Limit(limit))
+	}
+}
+
+// probeN tells how long n bytes would have waited on the shadow limit
+func (s *shadowLimiter) probeN(n int) time.Duration {
+	l := s.get()
+	if l == nil {
+		return 0
+	}
+	// nothing waits on it so the burst can grow freely with the chunks
+	l.ensureBurst(n)
+	return l.probeN(n)
+}
+
+// SetShadowLimits evaluates a second set of global and per-connection limits
+// alongside the enforced ones, without ever delaying writes because of them.
+// How much the shadow limits would have throttled traffic is reported by
+// ShadowStats and each connection's Stats, so a tighter policy can be safely
+// tried in production. A limit of 0 removes that shadow limit.
+func (ll *LimitedListener) SetShadowLimits(globalLimit, connLimit int) {
+	ll.mu.Lock()
+	ll.shadowGlobal.set(globalLimit, ll.mtu)
+	ll.shadowConnLimit = connLimit
+	ll.mu.Unlock()
+
+	for _, conn := range ll.connections() {
+		conn.shadow.set(connLimit, conn.mtu.get())
+	}
+}
+
+// ShadowStats returns how much writes of all connections would
+// have been delayed under the shadow limits
+func (ll *LimitedListener) ShadowStats() ThrottleStats {
+	return ll.shadowStats.snapshot()
+}
+
+// probeShadow accounts n bytes against the shadow limits
+func (lc LimitedConn) probeShadow(n int) {
+	if lc.listener == nil {
+		return
+	}
+	delay := lc.shadow.probeN(n)
+	if d := lc.listener.shadowGlobal.probeN(n); d > delay {
+		delay = d
+	}
+	if delay > 0 {
+		lc.stats.shadow.add(n, delay)
+		lc.listener.shadowStats.add(n, delay)
+	}
+}
@@ -0,0 +1,79 @@
+package limlistener
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+// ShedStats count the demand a listener turned away rather than served
+type ShedStats struct {
+	// connections turned away on accept and closed later on by
+	// admission policies or to relieve descriptor pressure
+	RefusedConns int64
+	EvictedConns int64
+	// bytes dropped by write queues, two-rate limits or missed deadlines
+	DroppedBytes int64
+	// writes rejected by full write queues
+	RejectedWrites int64
+}
+
+// shedCounters are ShedStats updated atomically
+type shedCounters struct {
+	refusedConns   int64
+	evictedConns   int64
+	droppedBytes   int64
+	rejectedWrites int64
+}
+
+func (sc *shedCounters) snapshot() ShedStats {
+	return ShedStats{
+		RefusedConns:   atomic.LoadInt64(&sc.refusedConns),
+		EvictedConns:   atomic.LoadInt64(&sc.evictedConns),
+		DroppedBytes:   atomic.LoadInt64(&sc.droppedBytes),
+		RejectedWrites: atomic.LoadInt64(&sc.rejectedWrites),
+	}
+}
+
+// ShedStats returns how much demand the listener has turned away
+func (ll *LimitedListener) ShedStats() ShedStats {
+	return ll.shed.snapshot()
+}
+
+// dropped accounts n bytes of the connection that never went out
+func (lc LimitedConn) dropped(n int) {
+	if n <= 0 {
+		return
+	}
+	atomic.AddInt64(&lc.stats.droppedBytes, int64(n))
+	if lc.listener != nil {
+		atomic.AddInt64(&lc.listener.shed.droppedBytes, int64(n))
+	}
+}
+
+// rejected accounts a write of the connection refused outright
+func (lc LimitedConn) rejected() {
+	atomic.AddInt64(&lc.stats.rejectedWrites, 1)
+	if lc.listener != nil {
+		atomic.AddInt64(&lc.listener.shed.rejectedWrites, 1)
+	}
+}
+
+// shedWait accounts the n bytes a failed wait leaves unsent
+// if the wait failed because they were shed
+func (lc LimitedConn) shedWait(err error, n int) {
+	if errors.Is(err, ErrExcessDropped) || errors.Is(err, os.ErrDeadlineExceeded) {
+		lc.dropped(n)
+	}
+}
+
+// push enqueues b on the connection write queue, accounting
+// whatever the queue policy sheds
+func (lc LimitedConn) push(b []byte) (int, error) {
+	n, dropped, err := lc.queue.push(b)
+	lc.dropped(dropped)
+	if errors.Is(err, ErrQueueFull) {
+		lc.rejected()
+	}
+	return n, err
+}
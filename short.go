@@ -0,0 +1,57 @@
+package limlistener
+
+import "sync/atomic"
+
+// SetShortConnections makes the first bytes written on each connection
+// accepted from now on skip the limiter waits altogether, for tiny
+// request/response exchanges (eg. DNS over TCP) that would otherwise pay
+// the pacing latency. They are charged to the global, per-connection,
+// class and per-IP limiters after the fact, so whatever the connection
+// writes next waits for them. A bytes of 0 turns it off.
+func (ll *LimitedListener) SetShortConnections(bytes int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.shortBytes = bytes
+}
+
+// shortConn are the bytes of a connection left to go out without waiting
+type shortConn struct {
+	remaining int64
+}
+
+func newShortConn(bytes int) *shortConn {
+	if bytes <= 0 {
+		return nil
+	}
+	return &shortConn{remaining: int64(bytes)}
+}
+
+// take takes n of the bytes left if they are all there
+func (sc *shortConn) take(n int) bool {
+	if sc == nil {
+		return false
+	}
+	for {
+		remaining := atomic.LoadInt64(&sc.remaining)
+		if remaining < int64(n) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&sc.remaining, remaining, remaining-int64(n)) {
+			return true
+		}
+	}
+}
+
+// chargeShort charges n bytes that went out without waiting to
+// the limiters, going into debt if they weren't there yet
+func (lc LimitedConn) chargeShort(n int) {
+	lc.global.take(n)
+	lc.connLimiter.take(n)
+	if _, limiter := lc.class.get(); limiter != nil {
+		limiter.take(n)
+	}
+	if lc.keyLimiter != nil {
+		lc.keyLimiter.take(n)
+	}
+}
@@ -0,0 +1,118 @@
+package limlistener
+
+import (
+	"time"
+)
+
+// Snapshot is a point in time view of the counters of a listener,
+// see DeltaSince
+type Snapshot struct {
+	Time time.Time
+	// counters of the open connections by id
+	Conns map[int]ConnStats
+	// counters of every connection accepted so far, closed ones included
+	Total RollupStats
+	Shed  ShedStats
+}
+
+// Snapshot takes a snapshot of the listener counters
+func (ll *LimitedListener) Snapshot() Snapshot {
+	// closed connections are either in the totals or still listed
+	ll.mu.Lock()
+	total := ll.closedStats
+	conns := make([]*LimitedConn, 0, len(ll.conns))
+	for _, conn := range ll.conns {
+		conns = append(conns, conn)
+	}
+	ll.mu.Unlock()
+
+	s := Snapshot{
+		Time:  time.Now(),
+		Conns: make(map[int]ConnStats, len(conns)),
+		Total: total,
+		Shed:  ll.ShedStats(),
+	}
+	for _, conn := range conns {
+		stats := conn.listenerStats()
+		s.Conns[conn.id] = stats
+		s.Total.add(stats)
+	}
+	return s
+}
+
+// RateStats are counters turned into rates per second
+type RateStats struct {
+	// bytes/sec
+	WireBytes    float64
+	LogicalBytes float64
+	DroppedBytes float64
+	// writes rejected per second
+	RejectedWrites float64
+	// share of the time spent waiting on the limiters, adding up to
+	// more than 1 over several connections waiting at once
+	Wait float64
+}
+
+// Delta are the rates between two snapshots
+type Delta struct {
+	Interval time.Duration
+	// rates of the connections open in the later snapshot, the ones
+	// opened in between counted from when they were accepted
+	Conns map[int]RateStats
+	// rates of all connections, the ones closed in between included
+	Total RateStats
+	// connections accepted and closed in between
+	Opened int
+	Closed int
+	// demand turned away in between
+	Shed ShedStats
+}
+
+// DeltaSince returns the rates between prev and s, so polling consumers
+// don't have to diff the counters themselves. Both have to be snapshots of
+// the same listener, prev the earlier one.
+func (s Snapshot) DeltaSince(prev Snapshot) Delta {
+	d := Delta{
+		Interval: s.Time.Sub(prev.Time),
+		Conns:    make(map[int]RateStats, len(s.Conns)),
+		Opened:   s.Total.Conns - prev.Total.Conns,
+		Shed: ShedStats{
+			RefusedConns:   s.Shed.RefusedConns - prev.Shed.RefusedConns,
+			EvictedConns:   s.Shed.EvictedConns - prev.Shed.EvictedConns,
+			DroppedBytes:   s.Shed.DroppedBytes - prev.Shed.DroppedBytes,
+			RejectedWrites: s.Shed.RejectedWrites - prev.Shed.RejectedWrites,
+		},
+	}
+	for id := range prev.Conns {
+		if _, ok := s.Conns[id]; !ok {
+			d.Closed++
+		}
+	}
+	if d.Interval <= 0 {
+		return d
+	}
+
+	for id, stats := range s.Conns {
+		var cur, old RollupStats
+		cur.add(stats)
+		// opened in between when not there before
+		if stats, ok := prev.Conns[id]; ok {
+			old.add(stats)
+		}
+		d.Conns[id] = rates(cur, old, d.Interval)
+	}
+	d.Total = rates(s.Total, prev.Total, d.Interval)
+	return d
+}
+
+// rates turns the counters grown from old to cur over interval into rates
+func rates(cur, old RollupStats, interval time.Duration) RateStats {
+	seconds := interval.Seconds()
+	return RateStats{
+		WireBytes:      float64(cur.WireBytes-old.WireBytes) / seconds,
+		LogicalBytes:   float64(cur.LogicalBytes-old.LogicalBytes) / seconds,
+		DroppedBytes:   float64(cur.DroppedBytes-old.DroppedBytes) / seconds,
+		RejectedWrites: float64(cur.RejectedWrites-old.RejectedWrites) / seconds,
+		Wait:           float64(cur.Wait-old.Wait) / float64(interval),
+	}
+}
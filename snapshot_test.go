@@ -0,0 +1,49 @@
+package limlistener
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotDelta(t *testing.T) {
+	ll := newTestListener(t)
+	kept := acceptDrained(t, ll)
+	closed := acceptDrained(t, ll)
+	closed.Write(make([]byte, 300))
+	prev := ll.Snapshot()
+
+	kept.Write(make([]byte, 1000))
+	closed.Write(make([]byte, 200))
+	ll.CloseConnection(closed)
+	opened := acceptDrained(t, ll)
+	opened.Write(make([]byte, 500))
+	s := ll.Snapshot()
+	// rates come out as the bytes sent in between
+	s.Time = prev.Time.Add(time.Second)
+
+	d := s.DeltaSince(prev)
+	if d.Interval != time.Second || d.Opened != 1 || d.Closed != 1 {
+		t.Errorf("delta over %v with %d opened and %d closed, want 1s with 1 of each", d.Interval, d.Opened, d.Closed)
+	}
+	for _, tt := range []struct {
+		conn LimitedConn
+		rate float64
+	}{
+		{kept, 1000},
+		{opened, 500},
+	} {
+		if rate := d.Conns[tt.conn.id].WireBytes; rate != tt.rate {
+			t.Errorf("connection %d sent %v bytes/sec, want %v", tt.conn.id, rate, tt.rate)
+		}
+	}
+	if _, ok := d.Conns[closed.id]; ok {
+		t.Error("closed connection still has rates")
+	}
+	// the closed one's last bytes are in the totals
+	if rate := d.Total.WireBytes; rate != 1700 {
+		t.Errorf("total of %v bytes/sec, want 1700", rate)
+	}
+	if total := s.Total.WireBytes; total != 2000 {
+		t.Errorf("snapshot total of %d bytes, want 2000", total)
+	}
+}
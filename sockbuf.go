@@ -0,0 +1,71 @@
+package limlistener
+
+import (
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// bufferSetter are connections whose kernel buffers can be sized
+// (eg. *net.TCPConn)
+type bufferSetter interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// SetSocketBuffers sizes the kernel send and receive buffers of every
+// connection to the bandwidth-delay product of its write and read limits
+// and rtt, an estimate of the round trip time, and keeps doing it as limits
+// change.
+// Oversized kernel buffers queue up seconds of data below the limiter and
+// defeat pacing for latency sensitive traffic. An rtt of 0 stops resizing.
+func (ll *LimitedListener) SetSocketBuffers(rtt time.Duration) {
+	ll.mu.Lock()
+	ll.bufferRTT = rtt
+	ll.mu.Unlock()
+
+	for _, conn := range ll.connections() {
+		conn.tuneBuffers()
+	}
+}
+
+// tuneBuffers resizes the kernel buffers of the connection to its limit,
+// best effort so errors are ignored
+func (lc LimitedConn) tuneBuffers() {
+	if lc.listener == nil {
+		return
+	}
+	lc.listener.mu.Lock()
+	rtt := lc.listener.bufferRTT
+	lc.listener.mu.Unlock()
+	if rtt <= 0 {
+		return
+	}
+	bs, ok := lc.conn.(bufferSetter)
+	if !ok {
+		return
+	}
+	if size, ok := lc.bdp(lc.connLimiter, rtt); ok {
+		bs.SetWriteBuffer(size)
+	}
+	if size, ok := lc.bdp(lc.readLimiter, rtt); ok {
+		bs.SetReadBuffer(size)
+	}
+}
+
+// bdp returns the bandwidth-delay product of a limiter
+func (lc LimitedConn) bdp(l *limiter, rtt time.Duration) (int, bool) {
+	if l == nil {
+		return 0, false
+	}
+	limit := l.Limit()
+	if limit == rlimit.Inf || limit <= 0 {
+		return 0, false
+	}
+	size := int(float64(limit) * rtt.Seconds())
+	// never below a couple of chunks
+	if min := 2 * lc.mtu.get(); size < min {
+		size = min
+	}
+	return size, true
+}
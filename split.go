@@ -0,0 +1,212 @@
+package limlistener
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// SplitBudget divides a bandwidth budget among children in fixed
+// proportions, every child is a GlobalLimiter of its own (eg. a tenant's
+// listener) whose limit is its weight's share of the parent's. Every child
+// gets 1 byte/sec and the rest is split by weight, rounded down, and
+// whenever shares change the ones that shrink do so before the ones that
+// grow, so the children never add up to more than the parent.
+type SplitBudget struct {
+	mu       sync.Mutex
+	limit    BytesPerSecond
+	children map[string]*splitChild
+}
+
+type splitChild struct {
+	weight  int
+	limiter *GlobalLimiter
+}
+
+// NewSplitBudget creates a budget to be split, a limit of 0
+// leaves it and all of its children unlimited
+func NewSplitBudget(limit BytesPerSecond) *SplitBudget {
+	return &SplitBudget{
+		limit:    limit,
+		children: make(map[string]*splitChild),
+	}
+}
+
+// SetLimit changes the budget being split, every child's limit follows.
+// It fails if there'd be less than 1 byte/sec for each child.
+func (s *SplitBudget) SetLimit(limit BytesPerSecond) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if limit > 0 && int(limit) < len(s.children) {
+		return fmt.Errorf("limlistener: budget of %v can't be split in %d", limit, len(s.children))
+	}
+	s.limit = limit
+	s.rebalance()
+	return nil
+}
+
+// Limit returns the budget being split
+func (s *SplitBudget) Limit() BytesPerSecond {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.limit
+}
+
+// Add creates a child with the given weight and returns its limiter,
+// the shares of the existing children shrink to make room for it
+func (s *SplitBudget) Add(name string, weight int) (*GlobalLimiter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if weight <= 0 {
+		return nil, fmt.Errorf("limlistener: invalid weight %d for %q", weight, name)
+	}
+	if _, ok := s.children[name]; ok {
+		return nil, fmt.Errorf("limlistener: %q already has a share", name)
+	}
+	if s.limit > 0 && int(s.limit) < len(s.children)+1 {
+		return nil, fmt.Errorf("limlistener: budget of %v can't be split in %d", s.limit, len(s.children)+1)
+	}
+	// starts out with nothing and gets its share once the others shrank,
+	// a limit of 0 would be none at all
+	c := &splitChild{
+		weight:  weight,
+		limiter: NewGlobalLimiter(1),
+	}
+	if s.limit == 0 {
+		c.limiter.SetLimit(0)
+	}
+	s.children[name] = c
+	s.rebalance()
+	return c.limiter, nil
+}
+
+// Child returns the limiter of the named child, nil if there's none
+func (s *SplitBudget) Child(name string) *GlobalLimiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.children[name]; ok {
+		return c.limiter
+	}
+	return nil
+}
+
+// Remove deletes the named child, the rest take over its share in
+// proportion to their weights. Its limiter is left with 1 byte/sec,
+// whatever still uses it should be moved elsewhere.
+func (s *SplitBudget) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.children[name]
+	if !ok {
+		return fmt.Errorf("limlistener: %q has no share", name)
+	}
+	s.drop(name, c)
+	s.rebalance()
+	return nil
+}
+
+// Merge deletes the child from and hands its whole weight over to the
+// child into (eg. a deleted tenant's traffic going back to its parent),
+// the shares of the rest are unchanged
+func (s *SplitBudget) Merge(from, into string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if from == into {
+		return fmt.Errorf("limlistener: can't merge %q into itself", from)
+	}
+	f, ok := s.children[from]
+	if !ok {
+		return fmt.Errorf("limlistener: %q has no share", from)
+	}
+	t, ok := s.children[into]
+	if !ok {
+		return fmt.Errorf("limlistener: %q has no share", into)
+	}
+	s.drop(from, f)
+	t.weight += f.weight
+	s.rebalance()
+	return nil
+}
+
+// Shares returns the limit of every child, by name
+func (s *SplitBudget) Shares() map[string]BytesPerSecond {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shares := make(map[string]BytesPerSecond, len(s.children))
+	for name, c := range s.children {
+		shares[name] = BytesPerSecond(c.limiter.Limit())
+	}
+	return shares
+}
+
+// drop takes a child out of the split and its limit down to the
+// minimum, must be called with the lock held
+func (s *SplitBudget) drop(name string, c *splitChild) {
+	delete(s.children, name)
+	if s.limit > 0 {
+		c.limiter.SetLimit(1)
+	}
+}
+
+// rebalance gives every child its share of the budget, shrinking
+// ones first, must be called with the lock held
+func (s *SplitBudget) rebalance() {
+	for _, ch := range s.plan() {
+		ch.c.limiter.SetLimit(ch.limit)
+	}
+}
+
+// splitChange is a child's limit going to limit
+type splitChange struct {
+	c     *splitChild
+	limit int
+	delta int
+}
+
+// plan returns the changes rebalance makes, in the order it makes them,
+// must be called with the lock held
+func (s *SplitBudget) plan() []splitChange {
+	total := 0
+	for _, c := range s.children {
+		total += c.weight
+	}
+	// 0 would be unlimited, the 1 byte/sec each gets is taken out first
+	// so the rounded shares can't add up to more than the budget
+	spare := int64(s.limit) - int64(len(s.children))
+	changes := make([]splitChange, 0, len(s.children))
+	for _, c := range s.children {
+		limit := 0
+		if s.limit > 0 {
+			limit = 1 + int(spare*int64(c.weight)/int64(total))
+		}
+		if delta := splitDelta(c.limiter.Limit(), limit); delta != 0 {
+			changes = append(changes, splitChange{c: c, limit: limit, delta: delta})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].delta < changes[j].delta
+	})
+	return changes
+}
+
+// splitDelta orders a limit change, with 0 being unlimited
+// and so bigger than any other
+func splitDelta(from, to int) int {
+	switch {
+	case from == to:
+		return 0
+	case to == 0:
+		return math.MaxInt32
+	case from == 0:
+		return math.MinInt32
+	}
+	return to - from
+}
@@ -0,0 +1,113 @@
+package limlistener
+
+import "testing"
+
+// sumShares returns what the children of s add up to
+func sumShares(s *SplitBudget) BytesPerSecond {
+	var sum BytesPerSecond
+	for _, share := range s.Shares() {
+		sum += share
+	}
+	return sum
+}
+
+func TestSplitBudgetNeverExceedsParent(t *testing.T) {
+	tests := []struct {
+		limit   BytesPerSecond
+		weights []int
+	}{
+		{3, []int{100, 1, 1}},
+		{10, []int{7, 1, 1, 1}},
+		{1000, []int{1, 1, 1}},
+		{5, []int{1, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		s := NewSplitBudget(tt.limit)
+		for i, weight := range tt.weights {
+			if _, err := s.Add(string(rune('a'+i)), weight); err != nil {
+				t.Fatal(err)
+			}
+			if sum := sumShares(s); sum > tt.limit {
+				t.Errorf("%v split by %v: children add up to %v", tt.limit, tt.weights[:i+1], sum)
+			}
+		}
+		for name, share := range s.Shares() {
+			// 0 would be unlimited
+			if share < 1 {
+				t.Errorf("%v split by %v: %s got %v", tt.limit, tt.weights, name, share)
+			}
+		}
+	}
+}
+
+func TestSplitBudgetShares(t *testing.T) {
+	s := NewSplitBudget(1002)
+	s.Add("gold", 3)
+	s.Add("silver", 1)
+	// a byte/sec each, the other 1000 split 3 to 1
+	want := map[string]BytesPerSecond{"gold": 751, "silver": 251}
+	for name, share := range s.Shares() {
+		if share != want[name] {
+			t.Errorf("%s got %v, want %v", name, share, want[name])
+		}
+	}
+
+	if err := s.Merge("silver", "gold"); err != nil {
+		t.Fatal(err)
+	}
+	if shares := s.Shares(); len(shares) != 1 || shares["gold"] != 1002 {
+		t.Errorf("shares after merging = %v, want gold with everything", shares)
+	}
+	if err := s.SetLimit(0); err != nil {
+		t.Fatal(err)
+	}
+	if limit := s.Child("gold").Limit(); limit != 0 {
+		t.Errorf("child of an unlimited budget limited to %d", limit)
+	}
+}
+
+func TestSplitBudgetShrinksFirst(t *testing.T) {
+	s := NewSplitBudget(1000)
+	a, _ := s.Add("a", 1)
+	b, _ := s.Add("b", 1)
+	limits := map[*GlobalLimiter]int{a: a.Limit(), b: b.Limit()}
+
+	// c coming in, b swapping most of its weight with it
+	s.mu.Lock()
+	c := &splitChild{weight: 1, limiter: NewGlobalLimiter(1)}
+	s.children["c"] = c
+	s.children["b"].weight = 2
+	s.children["a"].weight = 6
+	changes := s.plan()
+	s.mu.Unlock()
+	limits[c.limiter] = 1
+
+	for i, ch := range changes {
+		if i > 0 && changes[i-1].delta > 0 && ch.delta < 0 {
+			t.Errorf("change %d shrinks after one grew", i)
+		}
+		limits[ch.c.limiter] = ch.limit
+		sum := 0
+		for _, limit := range limits {
+			sum += limit
+		}
+		if sum > 1000 {
+			t.Errorf("children add up to %d after change %d", sum, i)
+		}
+	}
+}
+
+func TestSplitBudgetTooSmall(t *testing.T) {
+	s := NewSplitBudget(2)
+	s.Add("a", 1)
+	s.Add("b", 1)
+	if _, err := s.Add("c", 1); err == nil {
+		t.Error("split 2 bytes/sec in 3")
+	}
+	if err := s.SetLimit(1); err == nil {
+		t.Error("split 1 byte/sec in 2")
+	}
+	if _, err := s.Add("d", 0); err == nil {
+		t.Error("added a child without weight")
+	}
+}
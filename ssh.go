@@ -0,0 +1,69 @@
+package limlistener
+
+import (
+	"context"
+	"io"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// LimitChannel throttles a logical channel carried by a connection, such
+// as an SSH channel (golang.org/x/crypto/ssh.Channel) handed to an SFTP or
+// SCP server, to limit bytes/sec each way: writes wait before each chunk
+// and reads hold back the next read, so the channel window stays closed and
+// the client slows its uploads down. With the SSH server running over a
+// LimitedConn the connection limit still caps all of its channels together.
+func LimitChannel(ch io.ReadWriteCloser, limit int) io.ReadWriteCloser {
+	return &limitedChannel{
+		ReadWriteCloser: ch,
+		limiter:         newLimiter(rlimit.Limit(limit), defaultMTU),
+		readLimiter:     newLimiter(readLimit(limit), defaultMTU),
+	}
+}
+
+type limitedChannel struct {
+	io.ReadWriteCloser
+	limiter     *limiter
+	readLimiter *limiter
+}
+
+func (c *limitedChannel) Write(b []byte) (int, error) {
+	return writeChunks(context.Background(), c.limiter, b, c.ReadWriteCloser.Write)
+}
+
+// Read reads at most an MTU from the channel and then waits
+// for the bytes read, like throttled connection reads do
+func (c *limitedChannel) Read(b []byte) (int, error) {
+	if len(b) > defaultMTU {
+		b = b[:defaultMTU]
+	}
+	n, err := c.ReadWriteCloser.Read(b)
+	if n > 0 {
+		if werr := c.readLimiter.WaitN(context.Background(), n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// writeChunks writes b through write an MTU at a time,
+// waiting on l before each chunk
+func writeChunks(ctx context.Context, l *limiter, b []byte, write func([]byte) (int, error)) (n int, err error) {
+	for len(b) > 0 {
+		s := b
+		if len(s) > defaultMTU {
+			s = b[:defaultMTU]
+		}
+		b = b[len(s):]
+
+		if err := l.WaitN(ctx, len(s)); err != nil {
+			return n, err
+		}
+		written, err := write(s)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,45 @@
+package limlistener
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimitChannelBothWays(t *testing.T) {
+	for _, dir := range []string{"download", "upload"} {
+		server, client := net.Pipe()
+		ch := LimitChannel(server, 10000)
+
+		start := time.Now()
+		done := make(chan error, 1)
+		// a full bucket and then 2000 bytes at 10000 bytes/sec
+		if dir == "download" {
+			go func() {
+				_, err := io.CopyN(ioutil.Discard, client, 3024)
+				done <- err
+			}()
+			if _, err := ch.Write(make([]byte, 3024)); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			go func() {
+				_, err := client.Write(make([]byte, 3024))
+				done <- err
+			}()
+			if _, err := io.ReadFull(ch, make([]byte, 3024)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 400*time.Millisecond {
+			t.Errorf("%s of 3024 bytes took %v, want about 200ms", dir, elapsed)
+		}
+		ch.Close()
+		client.Close()
+	}
+}
@@ -0,0 +1,131 @@
+package limlistener
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats are the running counters of a connection
+type ConnStats struct {
+	// bytes actually written to the underlying connection
+	WireBytes int64
+	// bytes the application reported as written before any encoding
+	// (eg. compression) happening above the connection, zero unless reported
+	LogicalBytes int64
+	// writes that would have been delayed while in dry-run mode
+	DryRun ThrottleStats
+	// writes that would have been delayed under the shadow limits
+	Shadow ThrottleStats
+	// time each chunk waited on the limiters and then took to be
+	// written, telling apart the limiter from the network being slow
+	Wait  Histogram
+	Write Histogram
+	// time between a write returning and the next one starting, the
+	// application not writing rather than the limiters holding it back
+	Gap Histogram
+	// last time anything was read or written
+	LastActive time.Time
+	// bytes shed and writes rejected, see ShedStats
+	DroppedBytes   int64
+	RejectedWrites int64
+}
+
+// connStats are updated atomically from the writing goroutines
+type connStats struct {
+	wireBytes    int64
+	logicalBytes int64
+	dryRun       throttleCounter
+	shadow       throttleCounter
+	wait         histogram
+	write        histogram
+	gap          histogram
+	// unix nanos
+	lastActive     int64
+	lastWrite      int64
+	droppedBytes   int64
+	rejectedWrites int64
+}
+
+func newConnStats() *connStats {
+	return &connStats{
+		lastActive: time.Now().UnixNano(),
+	}
+}
+
+// touch records activity on the connection,
+// returning when it was last active before
+func (cs *connStats) touch() int64 {
+	return atomic.SwapInt64(&cs.lastActive, time.Now().UnixNano())
+}
+
+// writeStarted observes the gap since the previous write returned
+func (cs *connStats) writeStarted() {
+	last := atomic.LoadInt64(&cs.lastWrite)
+	if last == 0 {
+		return
+	}
+	if gap := time.Now().UnixNano() - last; gap > 0 {
+		cs.gap.observe(time.Duration(gap))
+	}
+}
+
+// writeDone marks where the next gap starts
+func (cs *connStats) writeDone() {
+	atomic.StoreInt64(&cs.lastWrite, time.Now().UnixNano())
+}
+
+func (cs *connStats) snapshot() ConnStats {
+	return ConnStats{
+		WireBytes:      atomic.LoadInt64(&cs.wireBytes),
+		LogicalBytes:   atomic.LoadInt64(&cs.logicalBytes),
+		DryRun:         cs.dryRun.snapshot(),
+		Shadow:         cs.shadow.snapshot(),
+		Wait:           cs.wait.snapshot(),
+		Write:          cs.write.snapshot(),
+		Gap:            cs.gap.snapshot(),
+		LastActive:     time.Unix(0, atomic.LoadInt64(&cs.lastActive)),
+		DroppedBytes:   atomic.LoadInt64(&cs.droppedBytes),
+		RejectedWrites: atomic.LoadInt64(&cs.rejectedWrites),
+	}
+}
+
+// Shaping returns the share of the connection's time spent waiting on the
+// limiters out of that plus the time the application took between writes,
+// close to 1 when shaping is what holds it back and close to 0 when it's
+// the application stalling
+func (cs ConnStats) Shaping() float64 {
+	total := cs.Wait.Sum + cs.Gap.Sum
+	if total == 0 {
+		return 0
+	}
+	return float64(cs.Wait.Sum) / float64(total)
+}
+
+// Stats returns the connection counters
+func (lc LimitedConn) Stats() ConnStats {
+	return lc.stats.snapshot()
+}
+
+// ReportLogical accounts n logical bytes for the connection, for when the
+// application encodes (eg. gzips) its data above the connection
+func (lc LimitedConn) ReportLogical(n int) {
+	atomic.AddInt64(&lc.stats.logicalBytes, int64(n))
+}
+
+// LogicalWriter wraps the encoder writing into the connection (eg. a
+// gzip.Writer) so everything written into it is reported as logical bytes
+func (lc LimitedConn) LogicalWriter(w io.Writer) io.Writer {
+	return logicalWriter{w: w, lc: lc}
+}
+
+type logicalWriter struct {
+	w  io.Writer
+	lc LimitedConn
+}
+
+func (lw logicalWriter) Write(b []byte) (int, error) {
+	n, err := lw.w.Write(b)
+	lw.lc.ReportLogical(n)
+	return n, err
+}
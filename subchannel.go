@@ -0,0 +1,76 @@
+package limlistener
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// SetControlLimit defines the per-connection limit of the control
+// sub-channel (see ControlWriter), 0 for none
+func (ll *LimitedListener) SetControlLimit(connLimit int) {
+	ll.mu.Lock()
+	ll.controlConnLimit = connLimit
+	ll.mu.Unlock()
+
+	for _, conn := range ll.connections() {
+		conn.control.SetLimit(readLimit(connLimit))
+	}
+}
+
+type controlKey struct{}
+
+func controlWrite(ctx context.Context) bool {
+	control, _ := ctx.Value(controlKey{}).(bool)
+	return control
+}
+
+// ControlWriter returns the control sub-channel of the connection, for
+// protocol traffic (eg. heartbeats, acks) that must never be held back by
+// bulk payloads. Its writes are multiplexed over the same socket as the
+// data ones but are only limited by the control limit (see SetControlLimit),
+// jump ahead of the data chunks waiting on the limiters, skip the async
+// write queue and are never coalesced.
+func (lc LimitedConn) ControlWriter() io.Writer {
+	return subWriter{lc: lc, control: true}
+}
+
+// DataWriter returns the data sub-channel of the connection, for bulk
+// payloads, shaped like plain Write by every configured limit
+func (lc LimitedConn) DataWriter() io.Writer {
+	return subWriter{lc: lc}
+}
+
+type subWriter struct {
+	lc      LimitedConn
+	control bool
+}
+
+func (sw subWriter) Write(b []byte) (int, error) {
+	if !sw.control {
+		return sw.lc.Write(b)
+	}
+	ctx := context.WithValue(context.Background(), controlKey{}, true)
+	ctx = context.WithValue(ctx, priorityKey{}, true)
+	sw.lc.prio.enter()
+	defer sw.lc.prio.leave()
+	return sw.lc.writeContext(ctx, b)
+}
+
+// waitControl waits for a control chunk to be allowed out
+func (lc LimitedConn) waitControl(ctx context.Context, n int) error {
+	// dialed connections have no control limit
+	if lc.control == nil {
+		return nil
+	}
+	start := time.Now()
+	lc.control.ensureBurst(n)
+	if err := lc.control.WaitN(ctx, n); err != nil {
+		lc.events.failed(n, err)
+		return err
+	}
+	waited := time.Since(start)
+	lc.stats.wait.observe(waited)
+	lc.events.waited(n, waited)
+	return nil
+}
@@ -0,0 +1,69 @@
+package limlistener
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+)
+
+// Tap mirrors the bytes written through connections, after they've
+// been throttled, for debugging or recording
+type Tap struct {
+	// Func gets every chunk written on a tapped connection along with the
+	// connection id, it's called from the writing goroutine and must not
+	// retain b
+	Func func(id int, b []byte)
+	// Sample is the fraction of new connections that get tapped,
+	// 0 taps them all
+	Sample float64
+	// MaxBytes caps how many bytes get mirrored per connection,
+	// 0 means no cap
+	MaxBytes int64
+}
+
+// WriterTap returns a tap function that copies everything into w,
+// writes from concurrent connections are serialized
+func WriterTap(w io.Writer) func(id int, b []byte) {
+	var mu sync.Mutex
+	return func(id int, b []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Write(b)
+	}
+}
+
+// connTap is a tap attached to a single connection
+type connTap struct {
+	mu   sync.Mutex
+	tap  *Tap
+	sent int64
+}
+
+// newConnTap decides whether a new connection gets tapped,
+// returns nil when it doesn't
+func newConnTap(tap *Tap) *connTap {
+	if tap == nil || tap.Func == nil {
+		return nil
+	}
+	if tap.Sample > 0 && tap.Sample < 1 && rand.Float64() >= tap.Sample {
+		return nil
+	}
+	return &connTap{tap: tap}
+}
+
+// mirror hands b to the tap, within the per-connection cap
+func (ct *connTap) mirror(id int, b []byte) {
+	ct.mu.Lock()
+	if max := ct.tap.MaxBytes; max > 0 {
+		if left := max - ct.sent; int64(len(b)) > left {
+			b = b[:left]
+		}
+	}
+	ct.sent += int64(len(b))
+	ct.mu.Unlock()
+
+	if len(b) > 0 {
+		ct.tap.Func(id, b)
+	}
+}
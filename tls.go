@@ -0,0 +1,225 @@
+package limlistener
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// estimated bytes each TLS record adds on the wire: 5 bytes of header
+	// plus the AEAD tag (16 bytes), TLS 1.3 also adds the 1 byte inner
+	// content type and TLS 1.2 the 8 bytes explicit nonce
+	TLS13RecordOverhead = 22
+	TLS12RecordOverhead = 29
+	// how long the first read or write of a connection waits for its
+	// handshake to classify it, unless SetTLSHandshakeTimeout says otherwise
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// SetTLSOverhead charges an estimated perRecord bytes of TLS record overhead
+// for every chunk written, for when the listener hands out plaintext
+// connections (eg. wrapping a tls.Listener) so the shaped rate matches the
+// ciphertext on the wire. Chunks get smaller so that payload plus overhead
+// still fit the MTU. Applies to connections accepted from now on.
+func (ll *LimitedListener) SetTLSOverhead(perRecord int) error {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	if perRecord < 0 || perRecord >= ll.mtu {
+		return fmt.Errorf("limlistener: TLS record overhead of %d bytes doesn't fit the %d bytes MTU", perRecord, ll.mtu)
+	}
+	ll.recordOverhead = perRecord
+	return nil
+}
+
+// TLSClassifier decides the limit class of a TLS connection
+// out of its handshake (eg. SNI hostname or negotiated ALPN protocol)
+type TLSClassifier func(state tls.ConnectionState) string
+
+// SetTLSClassifier classifies connections accepted from now on that are
+// *tls.Conn (ie. the listener wraps a tls.Listener) with fn. Their first
+// read or write completes the handshake (see SetTLSHandshakeTimeout) and
+// classifies them before anything goes through the limiters, so they are in
+// their class from the very first byte without a stalled client holding up
+// Accept. Reads and writes of connections whose handshake fails return its
+// error. Admission policies see them before they are classified.
+func (ll *LimitedListener) SetTLSClassifier(fn TLSClassifier) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.tlsClassifier = fn
+}
+
+// SetTLSHandshakeTimeout sets how long the first read or write of a TLS
+// connection waits for the handshake classifying it (see SetTLSClassifier),
+// 10s by default. 0 leaves it to the deadlines of the connection, which
+// bound the handshake either way and are back in place once it's done.
+// Applies to connections accepted from now on.
+func (ll *LimitedListener) SetTLSHandshakeTimeout(timeout time.Duration) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.tlsHandshakeTimeout = timeout
+}
+
+// ClassifyByALPN returns a classifier mapping negotiated ALPN protocols
+// (eg. "h2", "grpc-exp") to classes, connections negotiating none
+// of them go in the fallback class
+func ClassifyByALPN(classes map[string]string, fallback string) TLSClassifier {
+	return func(state tls.ConnectionState) string {
+		if class, ok := classes[state.NegotiatedProtocol]; ok {
+			return class
+		}
+		return fallback
+	}
+}
+
+// ClassifyBySNI returns a classifier mapping SNI hostnames to classes,
+// a "*.example.com" entry matches any subdomain of example.com and
+// connections matching none of them go in the fallback class
+func ClassifyBySNI(classes map[string]string, fallback string) TLSClassifier {
+	return func(state tls.ConnectionState) string {
+		host := strings.TrimSuffix(strings.ToLower(state.ServerName), ".")
+		if class, ok := classes[host]; ok {
+			return class
+		}
+		// try the wildcard of each parent domain
+		for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+			host = host[i+1:]
+			if class, ok := classes["*."+host]; ok {
+				return class
+			}
+		}
+		return fallback
+	}
+}
+
+// tlsHandshake is the handshake a TLS connection completes on its
+// first read or write, classifying and advertising its rates
+type tlsHandshake struct {
+	once sync.Once
+	err  error
+	// nil when it's only there to advertise the rates
+	classifier TLSClassifier
+	timeout    time.Duration
+	advertise  bool
+}
+
+// deferTLS leaves the classification of a TLS connection being accepted to
+// its first read or write, classifying it right away if its handshake is
+// done already (eg. attached connections). The rate advertisement waits
+// for the handshake too, rather than writing it in Accept.
+func (ll *LimitedListener) deferTLS(lc *LimitedConn) {
+	ll.mu.Lock()
+	fn, timeout := ll.tlsClassifier, ll.tlsHandshakeTimeout
+	advertise := ll.advertiseRate || ll.onRateAssigned != nil
+	ll.mu.Unlock()
+
+	tc, ok := lc.conn.(*tls.Conn)
+	if !ok || fn == nil && !advertise {
+		return
+	}
+	if !tc.ConnectionState().HandshakeComplete {
+		lc.handshake = &tlsHandshake{classifier: fn, timeout: timeout, advertise: advertise}
+		return
+	}
+	if fn != nil {
+		ll.classify(*lc, fn(tc.ConnectionState()))
+	}
+}
+
+// finishHandshake completes the pending TLS handshake of the connection,
+// if any. Only the first call does, the others wait for it and all of them
+// get its error.
+func (lc LimitedConn) finishHandshake() error {
+	h := lc.handshake
+	if h == nil {
+		return nil
+	}
+	h.once.Do(func() {
+		h.err = lc.runHandshake(h)
+	})
+	return h.err
+}
+
+func (lc LimitedConn) runHandshake(h *tlsHandshake) error {
+	tc := lc.conn.(*tls.Conn)
+	read, write := lc.closing.deadlines()
+	deadline := earliest(read, write)
+	if h.timeout > 0 {
+		deadline = earliest(deadline, time.Now().Add(h.timeout))
+	}
+	tc.SetDeadline(deadline)
+	err := tc.Handshake()
+	// back to the connection's own, set in the meantime or not
+	read, write = lc.closing.deadlines()
+	tc.SetReadDeadline(read)
+	tc.SetWriteDeadline(write)
+	if err != nil {
+		return err
+	}
+	if h.classifier != nil {
+		lc.listener.classify(lc, h.classifier(tc.ConnectionState()))
+	}
+	if h.advertise {
+		return lc.listener.advertise(lc)
+	}
+	return nil
+}
+
+// earliest returns the earliest of a and b, the zero time being none
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || !b.IsZero() && b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// ClientIdentity returns the identity of the client certificate presented
+// on a mutual TLS connection: its SPIFFE ID when it has one, otherwise its
+// subject common name, its first DNS SAN or its first email SAN. It's empty
+// when the client presented no certificate.
+func ClientIdentity(state tls.ConnectionState) string {
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	cert := state.PeerCertificates[0]
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String()
+		}
+	}
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
+
+// ClassifyByIdentity returns a classifier mapping client certificate
+// identities (see ClientIdentity) to classes, eg. contractual bandwidth
+// tiers. With a nil map every identity is its own class so each client gets
+// the limits of SetClass(identity, ...). Clients without a certificate or
+// with an unknown identity go in the fallback class.
+func ClassifyByIdentity(tiers map[string]string, fallback string) TLSClassifier {
+	return func(state tls.ConnectionState) string {
+		identity := ClientIdentity(state)
+		if identity == "" {
+			return fallback
+		}
+		if tiers == nil {
+			return identity
+		}
+		if class, ok := tiers[identity]; ok {
+			return class
+		}
+		return fallback
+	}
+}
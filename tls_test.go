@@ -0,0 +1,261 @@
+package limlistener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testTLSConfig returns a server config with a self-signed certificate
+// for localhost and a client config trusting it
+func testTLSConfig(t testing.TB) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost", "gold.example", "bronze.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	client = &tls.Config{RootCAs: pool}
+	return server, client
+}
+
+// newTLSTestListener returns a listener wrapping a TLS one, along with
+// a client config trusting it
+func newTLSTestListener(t *testing.T) (*LimitedListener, *tls.Config) {
+	t.Helper()
+	serverConfig, clientConfig := testTLSConfig(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll := NewWithTLSListener(l, serverConfig)
+	t.Cleanup(func() { ll.Close() })
+	return ll, clientConfig
+}
+
+// dialTLS connects to ll as host, reading whatever the server writes
+// until it closes the connection
+func dialTLS(ll *LimitedListener, config *tls.Config, host string) {
+	config = config.Clone()
+	config.ServerName = host
+	go func() {
+		c, err := tls.Dial("tcp", ll.Addr().String(), config)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(ioutil.Discard, c)
+	}()
+}
+
+func TestTLSClassifiedOnFirstWrite(t *testing.T) {
+	ll, clientConfig := newTLSTestListener(t)
+	ll.SetClass("gold", 1<<20, 0)
+	ll.SetTLSClassifier(ClassifyBySNI(map[string]string{
+		"gold.example": "gold",
+	}, "bronze"))
+
+	for _, host := range []string{"gold.example", "bronze.example"} {
+		dialTLS(ll, clientConfig, host)
+		conn, err := ll.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lc := conn.(LimitedConn)
+		// classified before its first byte
+		if _, err := lc.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		want := "gold"
+		if host != "gold.example" {
+			want = "bronze"
+		}
+		if class := lc.Class(); class != want {
+			t.Errorf("%s: class = %q, want %q", host, class, want)
+		}
+		if want == "gold" {
+			if limit := lc.LimiterState().Limit; limit != 1<<20 {
+				t.Errorf("%s: limit = %v, want the class one", host, limit)
+			}
+		}
+		ll.CloseConnection(conn)
+	}
+}
+
+func TestTLSFailedHandshakeFailsReads(t *testing.T) {
+	ll, _ := newTLSTestListener(t)
+	ll.SetTLSClassifier(ClassifyBySNI(nil, "default"))
+
+	// garbage instead of a handshake
+	go func() {
+		c, err := net.Dial("tcp", ll.Addr().String())
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		c.Read(make([]byte, 1))
+	}()
+	conn, err := ll.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ll.CloseConnection(conn)
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read a connection failing its handshake")
+	}
+	if _, err := conn.Write([]byte("hello")); err == nil {
+		t.Error("wrote to a connection failing its handshake")
+	}
+	if class := conn.(LimitedConn).Class(); class != "" {
+		t.Errorf("class = %q, want none", class)
+	}
+}
+
+func TestTLSStalledClientDoesntHoldUpAccept(t *testing.T) {
+	ll, clientConfig := newTLSTestListener(t)
+	ll.SetTLSClassifier(ClassifyBySNI(nil, "default"))
+
+	// never sends a ClientHello
+	stalled, err := net.Dial("tcp", ll.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	first, err := ll.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ll.CloseConnection(first)
+
+	start := time.Now()
+	dialTLS(ll, clientConfig, "localhost")
+	conn, err := ll.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ll.CloseConnection(conn)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Accept() took %v behind a stalled client", elapsed)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if class := conn.(LimitedConn).Class(); class != "default" {
+		t.Errorf("class = %q, want default", class)
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	ll, _ := newTLSTestListener(t)
+	ll.SetTLSClassifier(ClassifyBySNI(nil, "default"))
+	ll.SetTLSHandshakeTimeout(100 * time.Millisecond)
+
+	stalled, err := net.Dial("tcp", ll.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	conn, err := ll.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ll.CloseConnection(conn)
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("Read() = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("handshake gave up after %v", elapsed)
+	}
+}
+
+func TestTLSHandshakeKeepsDeadline(t *testing.T) {
+	ll, clientConfig := newTLSTestListener(t)
+	ll.SetTLSClassifier(ClassifyBySNI(nil, "default"))
+
+	// the client finishes the handshake and then sends nothing
+	dialTLS(ll, clientConfig, "localhost")
+	conn, err := ll.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ll.CloseConnection(conn)
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("Read() = %v, want a timeout", err)
+	}
+	// still in place after the handshake
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Read() timed out after %v, want about 300ms", elapsed)
+	}
+}
+
+func TestTLSRateAdvertisedWithClass(t *testing.T) {
+	ll, clientConfig := newTLSTestListener(t)
+	ll.SetClass("gold", 1<<20, 0)
+	ll.SetTLSClassifier(ClassifyBySNI(nil, "gold"))
+	ll.SetRateAdvertisement(true)
+
+	config := clientConfig.Clone()
+	config.ServerName = "localhost"
+	rates := make(chan Rates, 1)
+	go func() {
+		c, err := tls.Dial("tcp", ll.Addr().String(), config)
+		if err != nil {
+			rates <- Rates{}
+			return
+		}
+		defer c.Close()
+		r, _ := ReadRateFrame(c)
+		rates <- r
+		io.Copy(ioutil.Discard, c)
+	}()
+	conn, err := ll.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ll.CloseConnection(conn)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-rates; r.Write != 1<<20 {
+		t.Errorf("advertised %+v, want the class limit", r)
+	}
+}
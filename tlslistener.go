@@ -0,0 +1,88 @@
+package limlistener
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// NewWithTLSListener wraps l in a tls.Listener and limits what's outside
+// of TLS: accepted connections are LimitedConn over a *tls.Conn, so the
+// limiters see plaintext and the estimated record overhead of the lowest
+// version config allows is charged on top (see SetTLSOverhead). TLS
+// classifiers work in this order, the handshake isn't throttled. config can
+// come from autocert.Manager.TLSConfig, ACME challenges only go through the
+// handshake and so aren't throttled either.
+func NewWithTLSListener(l net.Listener, config *tls.Config) *LimitedListener {
+	ll := NewWithListener(tls.NewListener(l, config))
+	ll.recordOverhead = tlsRecordOverhead(config)
+	return &ll
+}
+
+// NewTLSWithLimits limits l and layers TLS inside of the limits: the
+// returned listener hands out *tls.Conn running over a LimitedConn, so the
+// limiters see the exact ciphertext including the handshake. Closing the
+// *tls.Conn cleans up the connection like CloseConnection does, the limits
+// are managed through the returned LimitedListener. config can come from
+// autocert.Manager.TLSConfig, connections of ACME tls-alpn-01 challenges
+// are exempted from the limits once their ClientHello is in, for as long
+// as the handshake ends up negotiating the ACME protocol.
+func NewTLSWithLimits(l net.Listener, config *tls.Config) (net.Listener, *LimitedListener) {
+	ll := NewWithListener(l)
+	ll.exemptions = true
+	return tls.NewListener(releasingListener{ll: &ll}, exemptACME(config)), &ll
+}
+
+// tlsRecordOverhead estimates the per-record overhead of the
+// TLS versions config allows, the largest one if several
+func tlsRecordOverhead(config *tls.Config) int {
+	if config != nil && config.MinVersion >= tls.VersionTLS13 {
+		return TLS13RecordOverhead
+	}
+	return TLS12RecordOverhead
+}
+
+// releasingListener hands out connections that clean up after
+// themselves on Close, for when something else (eg. tls.Conn) wraps
+// them and CloseConnection can't be called with them
+type releasingListener struct {
+	ll *LimitedListener
+}
+
+func (rl releasingListener) Accept() (net.Conn, error) {
+	conn, err := rl.ll.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return releasingConn{
+		LimitedConn: conn.(LimitedConn),
+		release:     rl.ll.CloseConnection,
+		once:        &sync.Once{},
+	}, nil
+}
+
+func (rl releasingListener) Close() error {
+	return rl.ll.Close()
+}
+
+func (rl releasingListener) Addr() net.Addr {
+	return rl.ll.Addr()
+}
+
+type releasingConn struct {
+	LimitedConn
+	// CloseConnection of the listener or dialer
+	release func(net.Conn)
+	once    *sync.Once
+}
+
+// Close closes and cleans up the connection only once, wrappers may
+// close it more than that: closing it again returns net.ErrClosed
+func (rc releasingConn) Close() error {
+	err := net.ErrClosed
+	rc.once.Do(func() {
+		err = rc.LimitedConn.Close()
+		rc.release(rc.LimitedConn)
+	})
+	return err
+}
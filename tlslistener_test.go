@@ -0,0 +1,111 @@
+package limlistener
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// tlsClient dials addr and reads everything the server writes, the
+// number of bytes read is sent once the server closes the connection
+func tlsClient(t *testing.T, addr string, config *tls.Config) <-chan int64 {
+	t.Helper()
+	read := make(chan int64, 1)
+	go func() {
+		c, err := tls.Dial("tcp", addr, config)
+		if err != nil {
+			read <- -1
+			return
+		}
+		defer c.Close()
+		n, _ := io.Copy(ioutil.Discard, c)
+		read <- n
+	}()
+	return read
+}
+
+func TestLimitsOutsideTLS(t *testing.T) {
+	serverConfig, clientConfig := testTLSConfig(t)
+	serverConfig.MinVersion = tls.VersionTLS13
+	clientConfig.ServerName = "localhost"
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll := NewWithTLSListener(l, serverConfig)
+	defer ll.Close()
+
+	read := tlsClient(t, l.Addr().String(), clientConfig)
+	conn, err := ll.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := conn.(LimitedConn)
+	// the limits wrap TLS
+	tc, ok := lc.conn.(*tls.Conn)
+	if !ok {
+		t.Fatalf("accepted a LimitedConn over %T, want *tls.Conn", lc.conn)
+	}
+	if lc.recordOverhead != TLS13RecordOverhead {
+		t.Errorf("record overhead = %d, want the TLS 1.3 one", lc.recordOverhead)
+	}
+	payload := make([]byte, 64*1024)
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if version := tc.ConnectionState().Version; version != tls.VersionTLS13 {
+		t.Errorf("negotiated %x, want TLS 1.3", version)
+	}
+	// the limiters see plaintext, the handshake went around them
+	if wire := lc.Stats().WireBytes; wire != int64(len(payload)) {
+		t.Errorf("wire bytes = %d, want the %d plaintext ones", wire, len(payload))
+	}
+	ll.CloseConnection(conn)
+	if n := <-read; n != int64(len(payload)) {
+		t.Errorf("client read %d bytes, want %d", n, len(payload))
+	}
+}
+
+func TestLimitsInsideTLS(t *testing.T) {
+	serverConfig, clientConfig := testTLSConfig(t)
+	clientConfig.ServerName = "localhost"
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, ll := NewTLSWithLimits(l, serverConfig)
+	defer tl.Close()
+
+	read := tlsClient(t, l.Addr().String(), clientConfig)
+	conn, err := tl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*tls.Conn); !ok {
+		t.Fatalf("accepted %T, want *tls.Conn", conn)
+	}
+	payload := make([]byte, 64*1024)
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	infos := ll.Conns(nil)
+	if len(infos) != 1 {
+		t.Fatalf("listener tracks %d connections, want 1", len(infos))
+	}
+	// the limiters see ciphertext, handshake included
+	if wire := infos[0].Stats.WireBytes; wire <= int64(len(payload)) {
+		t.Errorf("wire bytes = %d, want more than the %d plaintext ones", wire, len(payload))
+	}
+	// closing the *tls.Conn cleans up like CloseConnection
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-read; n != int64(len(payload)) {
+		t.Errorf("client read %d bytes, want %d", n, len(payload))
+	}
+	if n := len(ll.Conns(nil)); n != 0 {
+		t.Errorf("listener still tracks %d connections", n)
+	}
+}
@@ -0,0 +1,44 @@
+package limlistener
+
+import (
+	"context"
+	"runtime/trace"
+	"sync/atomic"
+)
+
+// SetTracing wraps the writes of every connection in runtime/trace tasks,
+// with regions around the limiter waits and the writes to the socket, so
+// go tool trace shows where a throttled transfer spends its time. It costs
+// next to nothing while no trace is being collected.
+func (ll *LimitedListener) SetTracing(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&ll.trace, v)
+}
+
+func (ll *LimitedListener) tracing() bool {
+	// dialed connections have no listener
+	return ll != nil && atomic.LoadInt32(&ll.trace) == 1 && trace.IsEnabled()
+}
+
+// traceTask starts a task for a write of the connection, to be ended
+// once the write returns
+func (lc LimitedConn) traceTask(ctx context.Context, name string) (context.Context, func()) {
+	if !lc.listener.tracing() {
+		return ctx, func() {}
+	}
+	ctx, task := trace.NewTask(ctx, name)
+	trace.Logf(ctx, "conn", "%d", lc.id)
+	return ctx, task.End
+}
+
+// traceRegion starts a region within the write task, to be ended
+// once it's done
+func (lc LimitedConn) traceRegion(ctx context.Context, name string) func() {
+	if !lc.listener.tracing() {
+		return func() {}
+	}
+	return trace.StartRegion(ctx, name).End
+}
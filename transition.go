@@ -0,0 +1,67 @@
+package limlistener
+
+import (
+	"sync/atomic"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// SetLimitTransition smooths limit changes: when a global, class or
+// per-connection limit changes its bucket is emptied and kept to a single
+// chunk for window, so the tokens accrued before the change can't burst out
+// on top of the new rate and output never goes above the new cap. A window
+// of 0 applies changes as they are.
+func (ll *LimitedListener) SetLimitTransition(window time.Duration) {
+	atomic.StoreInt64(ll.transition, int64(window))
+}
+
+// limitTransition returns the window limit changes are smoothed over
+func (ll *LimitedListener) limitTransition() time.Duration {
+	// dialed connections have no listener
+	if ll == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(ll.transition))
+}
+
+// setLimitSmoothed sets a new rate, smoothing the change over window
+// with the bucket kept to chunk meanwhile
+func (l *limiter) setLimitSmoothed(limit rlimit.Limit, window time.Duration, chunk int) {
+	changed := l.Limit() != limit
+	l.SetLimit(limit)
+	if !changed || window <= 0 || limit == rlimit.Inf {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.advance(now)
+	burst := l.lim.Burst()
+	if chunk < burst {
+		l.lim.SetBurstAt(now, chunk)
+		l.advance(now)
+	}
+	// take what was accrued so far
+	if n := int(l.tokens); n > 0 {
+		if r := l.lim.ReserveN(now, n); r.OK() {
+			l.took(now, n)
+		}
+	}
+	l.mu.Unlock()
+
+	if chunk < burst {
+		time.AfterFunc(window, func() {
+			l.ensureBurst(burst)
+		})
+	}
+}
+
+// setLimitSmoothed sets a new global limit, see limiter.setLimitSmoothed
+func (g *GlobalLimiter) setLimitSmoothed(limit int, window time.Duration, chunk int) {
+	g.mu.Lock()
+	g.limit = limit
+	g.mu.Unlock()
+
+	g.limiter.setLimitSmoothed(rlimit.Limit(limit), window, chunk)
+}
@@ -0,0 +1,40 @@
+package limlistener
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Transport returns a copy of base (of http.DefaultTransport when nil)
+// dialing its connections through ld: request bodies are throttled like
+// any other write and response downloads are charged to the same
+// connection, destination and global limiters as they're read, so an
+// http.Client (eg. a polite crawler) shares one budget in both
+// directions. Connections the transport closes are cleaned up like
+// CloseConnection does.
+func (ld *LimitedDialer) Transport(base *http.Transport) *http.Transport {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	t := base.Clone()
+	// TLS has to run over the limited connections too
+	t.DialTLSContext = nil
+	t.DialTLS = nil
+	t.Dial = nil
+	t.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := ld.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		lconn := conn.(LimitedConn)
+		lconn.chargeReads = true
+		return releasingConn{
+			LimitedConn: lconn,
+			release:     ld.CloseConnection,
+			once:        &sync.Once{},
+		}, nil
+	}
+	return t
+}
@@ -0,0 +1,94 @@
+package limlistener
+
+import (
+	"context"
+	"time"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// tryWriteSlack is how far ahead non-blocking writes take tokens: buckets
+// hold about a chunk so the tokens accrued while the caller's timer fires
+// late would otherwise be lost
+const tryWriteSlack = 2 * time.Millisecond
+
+// TryWrite writes as many chunks of b as the limiters allow right now and
+// returns without blocking, for event loop servers that can't block in
+// Write. The rest of b, b[n:], is left to the caller to retry after wait,
+// the time until the next chunk is allowed out. Only the global, class,
+// per-connection and per-IP limits are consulted, two-rate limits, fast
+// start and path pacing don't apply and the async write queue is skipped.
+func (lc LimitedConn) TryWrite(b []byte) (n int, wait time.Duration, err error) {
+	if err := lc.finishHandshake(); err != nil {
+		return 0, 0, err
+	}
+	// in dry-run mode nothing is ever held back
+	if lc.listener.dryRunning() {
+		n, err = lc.writeContext(context.Background(), b)
+		return n, 0, err
+	}
+	lc.stats.writeStarted()
+	defer lc.stats.writeDone()
+	for len(b) > 0 {
+		size := lc.chunkSize() - lc.recordOverhead
+		s := b
+		if len(b) > size {
+			s = b[:size]
+		}
+		lc.probeShadow(len(s) + lc.recordOverhead)
+		if wait = lc.allowN(len(s) + lc.recordOverhead); wait > 0 {
+			return n, wait, nil
+		}
+		w, err := lc.send(context.Background(), s, lc.recordOverhead)
+		n += w
+		if err != nil {
+			return n, 0, err
+		}
+		b = b[len(s):]
+	}
+	return n, 0, nil
+}
+
+// allowN takes n tokens from every limiter if they are all available right
+// now, otherwise it takes none and returns how long until they would be
+func (lc LimitedConn) allowN(n int) time.Duration {
+	limiters := lc.bucketLimiters()
+	now := time.Now()
+	reserved := make([]*rlimit.Reservation, 0, len(limiters))
+	giveBack := func() {
+		for i, r := range reserved {
+			limiters[i].cancel(now, r, n)
+		}
+	}
+	for _, l := range limiters {
+		r, delay := l.reserveNow(now, n, tryWriteSlack)
+		if delay > 0 {
+			giveBack()
+			return delay
+		}
+		reserved = append(reserved, r)
+	}
+	if lc.global.window.enabled() {
+		if delay := lc.global.window.probeN(n); delay > 0 {
+			giveBack()
+			return delay
+		}
+	}
+	return 0
+}
+
+// bucketLimiters returns the token buckets non-blocking writes consult,
+// a windowed global limit isn't one
+func (lc LimitedConn) bucketLimiters() []*limiter {
+	limiters := []*limiter{lc.connLimiter}
+	if _, limiter := lc.class.get(); limiter != nil {
+		limiters = append(limiters, limiter)
+	}
+	if lc.keyLimiter != nil {
+		limiters = append(limiters, lc.keyLimiter)
+	}
+	if !lc.global.window.enabled() {
+		limiters = append(limiters, lc.global.limiter)
+	}
+	return limiters
+}
@@ -0,0 +1,156 @@
+package limlistener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// ExcessAction decides what happens to traffic above the peak rate,
+// or above the committed one when the global limit has nothing to spare
+type ExcessAction int
+
+const (
+	// ExcessDelay holds excess traffic back to the committed rate
+	ExcessDelay ExcessAction = iota
+	// ExcessDrop fails the write with ErrExcessDropped, meant for message
+	// oriented traffic (see WriteMessage) where a lost message is acceptable
+	ExcessDrop
+)
+
+// ErrExcessDropped is returned by writes dropped for exceeding the
+// two-rate limits of their connection
+var ErrExcessDropped = errors.New("limlistener: write exceeds the connection rates, dropped")
+
+// TwoRate are two-rate three-color (trTCM) limits: traffic up to the
+// committed rate always goes out, even over the global limit, traffic up
+// to the peak rate goes out only as long as the global limit has tokens
+// to spare for it and whatever's left is excess
+type TwoRate struct {
+	// committed and peak rates in bytes/sec
+	Committed int
+	Peak      int
+	Excess    ExcessAction
+}
+
+// twoRateMeter marks each chunk of a connection green (within the
+// committed rate), yellow (within the peak rate) or red (excess)
+type twoRateMeter struct {
+	committed *limiter
+	peak      *limiter
+	excess    ExcessAction
+}
+
+func newTwoRateMeter(tr TwoRate, burst int) *twoRateMeter {
+	return &twoRateMeter{
+		committed: newLimiter(rlimit.Limit(tr.Committed), burst),
+		peak:      newLimiter(rlimit.Limit(tr.Peak), burst),
+		excess:    tr.Excess,
+	}
+}
+
+// waitN takes the place of both the connection and global waits
+func (m *twoRateMeter) waitN(ctx context.Context, n int, global *GlobalLimiter) error {
+	m.committed.ensureBurst(n)
+	m.peak.ensureBurst(n)
+	// green, charged to the global limit without waiting on it
+	if m.committed.probeN(n) == 0 {
+		m.peak.take(n)
+		global.take(n)
+		return nil
+	}
+	// yellow, paced to the peak rate as long as the global limit
+	// can spare it by then
+	if global.delay(n) <= m.peak.delay(n) {
+		switch {
+		case m.excess != ExcessDrop:
+			if err := m.peak.WaitN(ctx, n); err != nil {
+				return err
+			}
+			global.take(n)
+			return nil
+		case m.peak.probeN(n) == 0:
+			global.take(n)
+			return nil
+		}
+	}
+	// red
+	if m.excess == ExcessDrop {
+		return ErrExcessDropped
+	}
+	if err := m.committed.WaitN(ctx, n); err != nil {
+		return err
+	}
+	m.peak.take(n)
+	global.take(n)
+	return nil
+}
+
+// twoRateState is the meter a connection is currently subject to
+type twoRateState struct {
+	mu    sync.Mutex
+	meter *twoRateMeter
+}
+
+func (s *twoRateState) get() *twoRateMeter {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.meter
+}
+
+func (s *twoRateState) set(meter *twoRateMeter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.meter = meter
+}
+
+// SetTwoRate replaces the per-connection limit of the connections in class
+// with two-rate limits, an empty class applies to the connections without
+// two-rate limits of their own class. A zero TwoRate removes them, other
+// ones need 0 < Committed <= Peak.
+func (ll *LimitedListener) SetTwoRate(class string, tr TwoRate) error {
+	if tr != (TwoRate{}) && (tr.Committed <= 0 || tr.Peak < tr.Committed) {
+		return fmt.Errorf("limlistener: two-rate limits of %d committed and %d peak bytes/sec, want 0 < committed <= peak", tr.Committed, tr.Peak)
+	}
+	ll.mu.Lock()
+	if ll.twoRates == nil {
+		ll.twoRates = make(map[string]TwoRate)
+	}
+	if tr == (TwoRate{}) {
+		delete(ll.twoRates, class)
+	} else {
+		ll.twoRates[class] = tr
+	}
+	ll.mu.Unlock()
+
+	for _, conn := range ll.connections() {
+		ll.applyTwoRate(*conn)
+	}
+	return nil
+}
+
+// applyTwoRate gives a connection the two-rate limits of its class
+func (ll *LimitedListener) applyTwoRate(lc LimitedConn) {
+	name := lc.Class()
+
+	ll.mu.Lock()
+	tr, ok := ll.twoRates[name]
+	if !ok {
+		tr, ok = ll.twoRates[""]
+	}
+	ll.mu.Unlock()
+
+	var meter *twoRateMeter
+	if ok {
+		meter = newTwoRateMeter(tr, lc.mtu.get())
+	}
+	lc.twoRate.set(meter)
+}
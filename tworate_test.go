@@ -0,0 +1,60 @@
+package limlistener
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetTwoRateInvalid(t *testing.T) {
+	ll := newTestListener(t)
+	for _, tr := range []TwoRate{
+		{Committed: 0, Peak: 10000},
+		{Committed: -1, Peak: 10000},
+		{Committed: 10000, Peak: 0},
+		{Committed: 10000, Peak: 5000},
+	} {
+		if err := ll.SetTwoRate("", tr); err == nil {
+			t.Errorf("SetTwoRate(%+v) succeeded", tr)
+		}
+	}
+	if len(ll.twoRates) != 0 {
+		t.Errorf("invalid two-rate limits kept: %v", ll.twoRates)
+	}
+	if err := ll.SetTwoRate("", TwoRate{Committed: 1000, Peak: 1000}); err != nil {
+		t.Error(err)
+	}
+	// the zero value removes them
+	if err := ll.SetTwoRate("", TwoRate{}); err != nil || len(ll.twoRates) != 0 {
+		t.Errorf("removing two-rate limits = %v, left %v", err, ll.twoRates)
+	}
+}
+
+func TestTwoRateUnlimitedGlobal(t *testing.T) {
+	ll := newTestListener(t)
+	// a global limit of 0 means none, there's always some to spare
+	ll.SetLimits(0, 0)
+	if err := ll.SetTwoRate("", TwoRate{Committed: 1000, Peak: 20000}); err != nil {
+		t.Fatal(err)
+	}
+	conn := acceptDrained(t, ll)
+
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 10000)); err != nil {
+		t.Fatal(err)
+	}
+	// at the peak rate, rather than the committed one or never
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("10000 bytes took %v with a peak rate of 20000 bytes/sec", elapsed)
+	}
+}
+
+func TestLimiterDelayUnlimited(t *testing.T) {
+	for _, l := range []*limiter{newLimiter(0, 1000), newLimiter(readLimit(0), 1000)} {
+		if delay := l.delay(5000); delay != 0 {
+			t.Errorf("unlimited limiter delays 5000 bytes by %v", delay)
+		}
+		if delay := l.probeN(5000); delay != 0 {
+			t.Errorf("unlimited limiter probes 5000 bytes at %v", delay)
+		}
+	}
+}
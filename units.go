@@ -0,0 +1,91 @@
+package limlistener
+
+import (
+	"fmt"
+	"math"
+)
+
+// BytesPerSecond is a rate in bytes/sec, the APIs taking one make the
+// compiler catch a rate in other units (eg. megabits) passed as bytes.
+// Units are decimal: a megabyte is 10^6 bytes, a kilobit 1000 bits.
+type BytesPerSecond int
+
+// FromBps makes a rate out of bits/sec
+func FromBps(bits float64) BytesPerSecond {
+	return bytesPerSecond(bits / 8)
+}
+
+// FromKbps makes a rate out of kilobits/sec
+func FromKbps(kbits float64) BytesPerSecond {
+	return bytesPerSecond(kbits * 1000 / 8)
+}
+
+// FromMbps makes a rate out of megabits/sec
+func FromMbps(mbits float64) BytesPerSecond {
+	return bytesPerSecond(mbits * 1000 * 1000 / 8)
+}
+
+// FromGbps makes a rate out of gigabits/sec
+func FromGbps(gbits float64) BytesPerSecond {
+	return bytesPerSecond(gbits * 1000 * 1000 * 1000 / 8)
+}
+
+// FromKBps makes a rate out of kilobytes/sec
+func FromKBps(kbytes float64) BytesPerSecond {
+	return bytesPerSecond(kbytes * 1000)
+}
+
+// FromMBps makes a rate out of megabytes/sec
+func FromMBps(mbytes float64) BytesPerSecond {
+	return bytesPerSecond(mbytes * 1000 * 1000)
+}
+
+// bytesPerSecond rounds a rate to whole bytes, a positive one never
+// rounds down to 0 as that stands for no limit
+func bytesPerSecond(bytes float64) BytesPerSecond {
+	if bytes > 0 && bytes < 1 {
+		return 1
+	}
+	return BytesPerSecond(math.Round(bytes))
+}
+
+// Mbps returns the rate in megabits/sec
+func (r BytesPerSecond) Mbps() float64 {
+	return float64(r) * 8 / 1000 / 1000
+}
+
+// String formats the rate with the largest unit it's at least one of,
+// eg. "12.5 MB/s"
+func (r BytesPerSecond) String() string {
+	switch v := float64(r); {
+	case r == 0:
+		return "unlimited"
+	case v >= 1e9:
+		return fmt.Sprintf("%.3g GB/s", v/1e9)
+	case v >= 1e6:
+		return fmt.Sprintf("%.3g MB/s", v/1e6)
+	case v >= 1e3:
+		return fmt.Sprintf("%.3g kB/s", v/1e3)
+	}
+	return fmt.Sprintf("%d B/s", int(r))
+}
+
+// SetRates is SetLimits with typed rates
+func (ll *LimitedListener) SetRates(global, conn BytesPerSecond) {
+	ll.SetLimits(int(global), int(conn))
+}
+
+// SetReadRates is SetReadLimits with typed rates
+func (ll *LimitedListener) SetReadRates(global, conn BytesPerSecond) {
+	ll.SetReadLimits(int(global), int(conn))
+}
+
+// SetRates is SetLimits with typed rates
+func (ld *LimitedDialer) SetRates(global, conn BytesPerSecond) {
+	ld.SetLimits(int(global), int(conn))
+}
+
+// SetRate is SetLimit with a typed rate
+func (lc *LimitedConn) SetRate(rate BytesPerSecond) {
+	lc.SetLimit(int(rate))
+}
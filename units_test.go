@@ -0,0 +1,41 @@
+package limlistener
+
+import "testing"
+
+func TestBytesPerSecond(t *testing.T) {
+	tests := []struct {
+		rate BytesPerSecond
+		want BytesPerSecond
+		str  string
+	}{
+		{FromMbps(100), 12500000, "12.5 MB/s"},
+		{FromGbps(1), 125000000, "125 MB/s"},
+		{FromGbps(10), 1250000000, "1.25 GB/s"},
+		{FromKbps(64), 8000, "8 kB/s"},
+		{FromKBps(1.5), 1500, "1.5 kB/s"},
+		{FromMBps(2), 2000000, "2 MB/s"},
+		{FromBps(4000), 500, "500 B/s"},
+		// positive rates never round down to no limit
+		{FromBps(1), 1, "1 B/s"},
+		{FromBps(0), 0, "unlimited"},
+	}
+	for _, tt := range tests {
+		if tt.rate != tt.want {
+			t.Errorf("rate = %d, want %d", tt.rate, tt.want)
+		}
+		if s := tt.rate.String(); s != tt.str {
+			t.Errorf("%d.String() = %q, want %q", tt.rate, s, tt.str)
+		}
+	}
+	if mbps := FromMbps(100).Mbps(); mbps != 100 {
+		t.Errorf("Mbps() = %v, want 100", mbps)
+	}
+}
+
+func TestSetRates(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetRates(FromMbps(80), FromMbps(8))
+	if global, conn := ll.Limits(); global != 10000000 || conn != 1000000 {
+		t.Errorf("Limits() = %d, %d, want 10000000, 1000000", global, conn)
+	}
+}
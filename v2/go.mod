@@ -0,0 +1,11 @@
+module github.com/lrascao/limlistener/v2
+
+go 1.16
+
+require (
+	github.com/lrascao/limlistener v1.0.0
+	golang.org/x/time v0.0.0-20210611083556-38a9dc6acbc6 // indirect
+)
+
+// until v1 is tagged
+replace github.com/lrascao/limlistener => ../
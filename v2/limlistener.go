@@ -0,0 +1,204 @@
+// Package limlistener v2 is the pointer based API of the bandwidth limited
+// listener: connections are *Conn, safe to share between goroutines, and
+// closing one releases everything it holds so there's no CloseConnection to
+// remember. It's built on top of v1, which stays frozen for compatibility.
+package limlistener
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	v1 "github.com/lrascao/limlistener"
+)
+
+// Limiter is a bandwidth limit that can be inspected and changed at
+// runtime, both *Conn and *GlobalLimiter are one
+type Limiter interface {
+	// SetLimit sets a new limit in bytes/sec
+	SetLimit(limit int)
+	// Limit returns the configured limit in bytes/sec, 0 when unlimited
+	Limit() int
+	// State returns the current state of the token bucket
+	State() LimiterState
+}
+
+// re-exported from v1
+type (
+	GlobalLimiter = v1.GlobalLimiter
+	LimiterState  = v1.LimiterState
+	ConnStats     = v1.ConnStats
+	ConnInfo      = v1.ConnInfo
+)
+
+// NewGlobalLimiter creates a global budget of limit bytes/sec
+func NewGlobalLimiter(limit int) *GlobalLimiter {
+	return v1.NewGlobalLimiter(limit)
+}
+
+var _ Limiter = (*GlobalLimiter)(nil)
+
+// Listener is a net.Listener throttling the bandwidth of the
+// connections it accepts, both globally and per connection
+type Listener struct {
+	ll *v1.LimitedListener
+}
+
+// New creates a Listener throttling the connections accepted by l
+func New(l net.Listener) *Listener {
+	ll := v1.NewWithListener(l)
+	return &Listener{ll: &ll}
+}
+
+// V1 returns the underlying v1 listener, for the settings
+// that have no v2 counterpart yet
+func (l *Listener) V1() *v1.LimitedListener {
+	return l.ll
+}
+
+// SetLimits defines both new global and per-connection limits
+func (l *Listener) SetLimits(globalLimit, connLimit int) {
+	l.ll.SetLimits(globalLimit, connLimit)
+}
+
+// SetMTU sets the size of the chunks writes are split into
+func (l *Listener) SetMTU(mtu int) error {
+	return l.ll.SetMTU(mtu)
+}
+
+// GlobalLimiter returns the budget shared by all connections
+func (l *Listener) GlobalLimiter() *GlobalLimiter {
+	return l.ll.GlobalLimiter()
+}
+
+// Conns describes the open connections
+func (l *Listener) Conns() []ConnInfo {
+	return l.ll.Conns(nil)
+}
+
+// Accept waits for the next connection, it's always a *Conn
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.ll.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{lc: conn.(v1.LimitedConn), ll: l.ll}, nil
+}
+
+// Close stops accepting connections
+func (l *Listener) Close() error {
+	return l.ll.Close()
+}
+
+// Addr returns the listener's network address
+func (l *Listener) Addr() net.Addr {
+	return l.ll.Addr()
+}
+
+// Conn is a throttled connection
+type Conn struct {
+	ll *v1.LimitedListener
+
+	mu     sync.Mutex
+	lc     v1.LimitedConn
+	closed bool
+}
+
+var _ Limiter = (*Conn)(nil)
+
+// Write waits on the connection, class and global limits
+// before writing each chunk of b
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.conn().Write(b)
+}
+
+// Read reads from the connection, throttled by any read limits
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.conn().Read(b)
+}
+
+// Close closes the connection and releases everything it holds,
+// closing it again returns net.ErrClosed
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	err := c.lc.Close()
+	// releases what it holds, closing it again is a no-op
+	c.ll.CloseConnection(c.lc)
+	return err
+}
+
+// ID returns the connection id
+func (c *Conn) ID() int {
+	return c.conn().ID()
+}
+
+// SetLimit sets a new per-connection limit
+func (c *Conn) SetLimit(limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lc.SetLimit(limit)
+}
+
+// Limit returns the per-connection limit, 0 when unlimited
+func (c *Conn) Limit() int {
+	limit := c.State().Limit
+	if math.IsInf(limit, 1) {
+		return 0
+	}
+	return int(limit)
+}
+
+// State returns the state of the connection token bucket
+func (c *Conn) State() LimiterState {
+	return c.conn().LimiterState()
+}
+
+// Stats returns the connection counters
+func (c *Conn) Stats() ConnStats {
+	return c.conn().Stats()
+}
+
+// LocalAddr returns the local network address
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn().LocalAddr()
+}
+
+// RemoteAddr returns the remote network address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn().RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn().SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn().SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn().SetWriteDeadline(t)
+}
+
+// V1 returns the underlying v1 connection
+func (c *Conn) V1() v1.LimitedConn {
+	return c.conn()
+}
+
+func (c *Conn) conn() v1.LimitedConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lc
+}
@@ -0,0 +1,111 @@
+package limlistener
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// acceptConn dials l and returns the accepted connection, along with
+// the client end closed when the test is done
+func acceptConn(t *testing.T, l *Listener) (*Conn, net.Conn) {
+	t.Helper()
+	dialed := make(chan net.Conn, 1)
+	go func() {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			dialed <- nil
+			return
+		}
+		dialed <- c
+	}()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := <-dialed
+	if client == nil {
+		t.Fatal("dial failed")
+	}
+	t.Cleanup(func() { client.Close() })
+	return conn.(*Conn), client
+}
+
+func newTestListener(t *testing.T) *Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll := New(l)
+	t.Cleanup(func() { ll.Close() })
+	return ll
+}
+
+func TestConnLimit(t *testing.T) {
+	ll := newTestListener(t)
+	conn, _ := acceptConn(t, ll)
+	defer conn.Close()
+
+	if limit := conn.Limit(); limit != 0 {
+		t.Errorf("unlimited conn: Limit() = %d, want 0", limit)
+	}
+	conn.SetLimit(64 * 1024)
+	if limit := conn.Limit(); limit != 64*1024 {
+		t.Errorf("Limit() = %d, want %d", limit, 64*1024)
+	}
+}
+
+// failingConn fails to close
+type failingConn struct {
+	net.Conn
+}
+
+func (c failingConn) Close() error {
+	c.Conn.Close()
+	return errCloseFailed
+}
+
+var errCloseFailed = errors.New("close failed")
+
+// failingListener hands out connections failing to close
+type failingListener struct {
+	net.Listener
+}
+
+func (l failingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return failingConn{c}, nil
+}
+
+func TestConnCloseError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll := New(failingListener{l})
+	defer ll.Close()
+	conn, _ := acceptConn(t, ll)
+
+	if err := conn.Close(); err != errCloseFailed {
+		t.Errorf("Close() = %v, want the wrapped connection's error", err)
+	}
+}
+
+func TestConnCloseTwice(t *testing.T) {
+	ll := newTestListener(t)
+	conn, _ := acceptConn(t, ll)
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("closing again = %v, want net.ErrClosed", err)
+	}
+	if conns := ll.Conns(); len(conns) != 0 {
+		t.Errorf("listener still tracks %d connections", len(conns))
+	}
+}
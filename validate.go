@@ -0,0 +1,133 @@
+package limlistener
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// ValidationError is an inconsistency found in a listener's configuration
+type ValidationError struct {
+	// what's inconsistent, eg. "conn limit" or "class web"
+	Field   string
+	Problem string
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Problem
+}
+
+// ValidationErrors are all the inconsistencies Validate found
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return "limlistener: invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks the listener limits are consistent with each other (eg.
+// per-connection limits not above the global one, floors not above caps,
+// rules not overlapping), returning ValidationErrors listing every problem
+// or nil when there are none
+func (ll *LimitedListener) Validate() error {
+	budget := ll.getBudget()
+
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	var errs ValidationErrors
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: field, Problem: fmt.Sprintf(format, args...)})
+	}
+	// 0 stands for no limit in the checks below
+	exceeds := func(limit, cap int) bool {
+		return cap > 0 && limit > cap
+	}
+
+	if ll.limitsSet && ll.baseConnLimit <= 0 {
+		add("conn limit", "%d would never let a byte out", ll.baseConnLimit)
+	}
+	if exceeds(ll.baseConnLimit, ll.baseGlobalLimit) {
+		add("conn limit", "%d exceeds the global limit of %d", ll.baseConnLimit, ll.baseGlobalLimit)
+	}
+	if ll.mtu <= ll.recordOverhead {
+		add("mtu", "%d doesn't fit the %d bytes per-chunk overhead", ll.mtu, ll.recordOverhead)
+	}
+
+	for name, cl := range ll.classes {
+		field := "class " + name
+		if exceeds(cl.connLimit, ll.baseGlobalLimit) {
+			add(field, "conn limit of %d exceeds the global limit of %d", cl.connLimit, ll.baseGlobalLimit)
+		}
+		if exceeds(cl.connLimit, cl.limit) {
+			add(field, "conn limit of %d exceeds the class cap of %d", cl.connLimit, cl.limit)
+		}
+		if exceeds(cl.limit, ll.baseGlobalLimit) {
+			add(field, "cap of %d exceeds the global limit of %d", cl.limit, ll.baseGlobalLimit)
+		}
+	}
+
+	for i, r := range ll.rules {
+		field := fmt.Sprintf("rule %d", i)
+		if r.MaxConns > 0 && r.MinConns > r.MaxConns {
+			add(field, "min conns %d above max conns %d never holds", r.MinConns, r.MaxConns)
+		}
+		ruleGlobal := r.GlobalLimit
+		if ruleGlobal == 0 {
+			ruleGlobal = ll.baseGlobalLimit
+		}
+		if exceeds(r.ConnLimit, ruleGlobal) {
+			add(field, "conn limit of %d exceeds the global limit of %d", r.ConnLimit, ruleGlobal)
+		}
+		for j, prev := range ll.rules[:i] {
+			if rulesOverlap(prev, r) {
+				add(field, "overlaps rule %d, which wins", j)
+				break
+			}
+		}
+	}
+
+	if readGlobal := ll.readGlobal.Limit(); readGlobal != rlimit.Inf && exceeds(ll.readConnLimit, int(readGlobal)) {
+		add("read conn limit", "%d exceeds the global read limit of %d", ll.readConnLimit, int(readGlobal))
+	}
+	if shadow := ll.shadowGlobal.get(); shadow != nil && exceeds(ll.shadowConnLimit, int(shadow.Limit())) {
+		add("shadow conn limit", "%d exceeds the global shadow limit of %d", ll.shadowConnLimit, int(shadow.Limit()))
+	}
+
+	if budget != nil {
+		budget.mu.Lock()
+		floor, tightenAt := budget.floor, budget.tightenAt
+		budget.mu.Unlock()
+		if tightenAt > 0 && exceeds(floor, ll.baseGlobalLimit) {
+			add("budget", "floor of %d exceeds the global limit of %d", floor, ll.baseGlobalLimit)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// rulesOverlap tells if there's a connection count both rules hold at
+func rulesOverlap(a, b ConnCountRule) bool {
+	max := func(r ConnCountRule) int {
+		if r.MaxConns == 0 {
+			return math.MaxInt32
+		}
+		return r.MaxConns
+	}
+	lo, hi := a.MinConns, max(a)
+	if b.MinConns > lo {
+		lo = b.MinConns
+	}
+	if m := max(b); m < hi {
+		hi = m
+	}
+	return lo <= hi
+}
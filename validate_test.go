@@ -0,0 +1,48 @@
+package limlistener
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetLimits(10000, 1000)
+	ll.SetClass("web", 500, 5000)
+	if err := ll.Validate(); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+
+	ll.SetLimits(1000, 2000)
+	ll.SetClass("web", 500, 400)
+	ll.SetConnCountRules(
+		ConnCountRule{MinConns: 10, MaxConns: 5},
+		ConnCountRule{MinConns: 1, ConnLimit: 100},
+		ConnCountRule{MinConns: 2, ConnLimit: 100},
+	)
+	err := ll.Validate()
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Validate() = %v, want ValidationErrors", err)
+	}
+	want := map[string]bool{
+		"conn limit": true,
+		"class web":  true,
+		"rule 0":     true,
+		"rule 2":     true,
+	}
+	got := make(map[string]bool)
+	for _, e := range errs {
+		got[e.Field] = true
+	}
+	for field := range want {
+		if !got[field] {
+			t.Errorf("no problem reported for %s in %v", field, err)
+		}
+	}
+	for field := range got {
+		if !want[field] {
+			t.Errorf("unexpected problem reported for %s in %v", field, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package limlistener
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// window caps the bytes sent over fixed consecutive intervals
+// (eg. no more than 100 MB every 10s) instead of an instantaneous rate
+type window struct {
+	mu       sync.Mutex
+	clock    Clock
+	limit    int
+	interval time.Duration
+	// start of the current window and bytes already sent in it
+	start time.Time
+	used  int
+}
+
+func newWindow(clock Clock) *window {
+	return &window{
+		clock: clock,
+	}
+}
+
+// enabled tells if a window limit is currently configured
+func (w *window) enabled() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.limit > 0 && w.interval > 0
+}
+
+func (w *window) set(limit int, interval time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.limit = limit
+	w.interval = interval
+	// start over with a fresh window
+	w.start = w.clock.Now()
+	w.used = 0
+}
+
+func (w *window) setClock(clock Clock) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.clock = clock
+	w.start = clock.Now()
+}
+
+// roll moves the window forward if its interval has elapsed,
+// must be called with the lock held
+func (w *window) roll(now time.Time) {
+	elapsed := now.Sub(w.start)
+	if elapsed < w.interval {
+		return
+	}
+	// keep windows aligned to the original start
+	w.start = w.start.Add(elapsed - elapsed%w.interval)
+	w.used = 0
+}
+
+// waitN blocks until n bytes fit in the current window
+func (w *window) waitN(ctx context.Context, n int) error {
+	for {
+		w.mu.Lock()
+		if w.limit <= 0 || w.interval <= 0 {
+			// the window got disabled while we were waiting
+			w.mu.Unlock()
+			return nil
+		}
+		now := w.clock.Now()
+		w.roll(now)
+		// a write larger than the whole window goes out on a fresh one
+		if w.used+n <= w.limit || w.used == 0 {
+			w.used += n
+			w.mu.Unlock()
+			return nil
+		}
+		wait := w.start.Add(w.interval).Sub(now)
+		clock := w.clock
+		w.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clock.After(wait):
+		}
+	}
+}
+
+// probeN accounts n bytes only if they fit in the current window,
+// otherwise it returns how long waitN would have waited
+func (w *window) probeN(n int) time.Duration {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.limit <= 0 || w.interval <= 0 {
+		return 0
+	}
+	now := w.clock.Now()
+	w.roll(now)
+	if w.used+n <= w.limit || w.used == 0 {
+		w.used += n
+		return 0
+	}
+	return w.start.Add(w.interval).Sub(now)
+}
+
+// delay tells how long it'd take for n more bytes to fit in a window
+func (w *window) delay(n int) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.clock.Now()
+	w.roll(now)
+	if w.used+n <= w.limit || w.used == 0 {
+		return 0
+	}
+	return w.start.Add(w.interval).Sub(now)
+}
+
+// available tells how many more bytes fit in the current window
+func (w *window) available() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.roll(w.clock.Now())
+	return w.limit - w.used
+}
+
+// take accounts n bytes in the current window even if they don't fit
+func (w *window) take(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.roll(w.clock.Now())
+	w.used += n
+}
@@ -0,0 +1,65 @@
+package limlistener
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWindowReset(t *testing.T) {
+	clock := newManualClock()
+	start := clock.Now()
+	w := newWindow(clock)
+	w.set(1000, 10*time.Second)
+
+	if wait := w.probeN(600); wait != 0 {
+		t.Fatalf("probeN(600) = %v, want it to fit", wait)
+	}
+	clock.advance(4 * time.Second)
+	// the rest of the window has to go by
+	if wait := w.probeN(600); wait != 6*time.Second {
+		t.Errorf("probeN(600) over the limit = %v, want 6s", wait)
+	}
+	if avail := w.available(); avail != 400 {
+		t.Errorf("available() = %d, want 400", avail)
+	}
+
+	// waiting moves the clock on to the next window
+	if err := w.waitN(context.Background(), 600); err != nil {
+		t.Fatal(err)
+	}
+	if now := clock.Now(); !now.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("waited until %v, want the next window at %v", now.Sub(start), 10*time.Second)
+	}
+	if avail := w.available(); avail != 400 {
+		t.Errorf("available() in the next window = %d, want 400", avail)
+	}
+
+	// windows stay aligned to the first one
+	clock.advance(25 * time.Second)
+	w.roll(clock.Now())
+	if got := w.start.Sub(start); got != 30*time.Second {
+		t.Errorf("window started %v in, want 30s", got)
+	}
+	// a write larger than the limit goes out on a fresh window
+	if wait := w.probeN(5000); wait != 0 {
+		t.Errorf("probeN(5000) on a fresh window = %v, want it let out", wait)
+	}
+}
+
+func TestGlobalWindow(t *testing.T) {
+	ll := newTestListener(t)
+	clock := newManualClock()
+	ll.SetClock(clock)
+	ll.GlobalLimiter().SetWindow(1000, time.Second)
+	conn := acceptDrained(t, ll)
+
+	start := clock.Now()
+	if _, err := conn.Write(make([]byte, 2500)); err != nil {
+		t.Fatal(err)
+	}
+	// the last 500 bytes went out in the third window
+	if elapsed := clock.Now().Sub(start); elapsed < 2*time.Second || elapsed >= 3*time.Second {
+		t.Errorf("2500 bytes took %v of windows of 1000, want 2s", elapsed)
+	}
+}
@@ -0,0 +1,43 @@
+package limlistener
+
+import (
+	"net"
+	"sync/atomic"
+
+	rlimit "golang.org/x/time/rate"
+)
+
+// ConnOptions are the limits of a connection wrapped with WrapConn
+type ConnOptions struct {
+	// per-connection limit in bytes/sec, 0 for none
+	Limit int
+	// chunk size writes are split into, defaults to 1024 bytes
+	MTU int
+	// optional budget shared with other connections, listeners and dialers
+	Global *GlobalLimiter
+}
+
+// ids of wrapped connections
+var wrappedConns int64
+
+// WrapConn throttles a connection accepted by someone else (eg. handed
+// over by an SSH or RPC server library) without owning its listener.
+// Listener features (classes, rules, budgets...) aren't available, share
+// a GlobalLimiter in opts to cap several wrapped connections together.
+func WrapConn(conn net.Conn, opts ConnOptions) LimitedConn {
+	limit := rlimit.Limit(opts.Limit)
+	if opts.Limit <= 0 {
+		limit = rlimit.Inf
+	}
+	mtu := opts.MTU
+	if mtu <= 0 {
+		mtu = defaultMTU
+	}
+	global := opts.Global
+	if global == nil {
+		global = newGlobalLimiter(rlimit.Inf, 0)
+	}
+	global.limiter.ensureBurst(mtu)
+	id := int(atomic.AddInt64(&wrappedConns, 1) - 1)
+	return newLimitedConn(id, conn, global, newLimiter(limit, mtu), mtu)
+}
@@ -0,0 +1,110 @@
+package limlistener
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// madeConns returns a connection made each way other than accepting
+func madeConns(t *testing.T) map[string]LimitedConn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, c)
+		}
+	}()
+
+	ld := NewWithDialer(&net.Dialer{})
+	dialed, err := ld.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := net.Pipe()
+	go io.Copy(ioutil.Discard, client)
+	t.Cleanup(func() { client.Close() })
+	wrapped := WrapConn(server, ConnOptions{Limit: 10000})
+	streamServer, streamClient := net.Pipe()
+	go io.Copy(ioutil.Discard, streamClient)
+	t.Cleanup(func() { streamClient.Close() })
+
+	return map[string]LimitedConn{
+		"dialed":  dialed.(LimitedConn),
+		"wrapped": wrapped,
+		"stream":  wrapped.Stream(streamServer, 10000),
+	}
+}
+
+func TestMadeConnsBehaveAlike(t *testing.T) {
+	for name, conn := range madeConns(t) {
+		if conn.Context() == nil {
+			t.Errorf("%s: no context", name)
+		}
+		if _, err := conn.Write(make([]byte, 100)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if stats := conn.Stats(); stats.WireBytes != 100 {
+			t.Errorf("%s: %d bytes accounted, want 100", name, stats.WireBytes)
+		}
+		if err := conn.Close(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if err := conn.Close(); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("%s: closing again = %v, want net.ErrClosed", name, err)
+		}
+	}
+}
+
+func TestWrapConnLimit(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client)
+	conn := WrapConn(server, ConnOptions{Limit: 10000})
+	defer conn.Close()
+
+	// a full bucket and then 2000 bytes at 10000 bytes/sec
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 3024)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 400*time.Millisecond {
+		t.Errorf("3024 bytes took %v, want about 200ms", elapsed)
+	}
+}
+
+// countingLimiter counts the bytes waited for
+type countingLimiter struct {
+	n int64
+}
+
+func (cl *countingLimiter) WaitN(ctx context.Context, n int) error {
+	atomic.AddInt64(&cl.n, int64(n))
+	return nil
+}
+
+func TestMadeConnsSetLimiter(t *testing.T) {
+	for name, conn := range madeConns(t) {
+		cl := &countingLimiter{}
+		conn.SetLimiter(cl)
+		if _, err := conn.Write(make([]byte, 100)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if n := atomic.LoadInt64(&cl.n); n != 100 {
+			t.Errorf("%s: waited for %d bytes on the custom limiter, want 100", name, n)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,73 @@
+package limlistener
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// SetYieldEvery makes writes of new connections yield to the other
+// connections writing through the same global limiter every chunks chunks,
+// so a single large write doesn't monopolize it taking chunk after chunk as
+// soon as it's granted. 0 never yields. With grants (see SetMaxGrant)
+// writes can only yield between grants, every chunk of a grant counts.
+// See WriteYielding to set it for a single write.
+func (ll *LimitedListener) SetYieldEvery(chunks int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	ll.yieldEvery = chunks
+}
+
+type yieldKey struct{}
+
+// WriteYielding writes b like Write does, yielding to the other connections
+// every maxChunks chunks (see SetYieldEvery) instead of the configured
+// default. It's always synchronous, even in async write mode.
+func (lc LimitedConn) WriteYielding(b []byte, maxChunks int) (int, error) {
+	ctx := context.WithValue(context.Background(), yieldKey{}, maxChunks)
+	return lc.writeContext(ctx, b)
+}
+
+// yieldEvery returns how many chunks a write takes before yielding
+func (lc LimitedConn) yieldEvery(ctx context.Context) int {
+	if chunks, ok := ctx.Value(yieldKey{}).(int); ok {
+		return chunks
+	}
+	return lc.yieldChunks
+}
+
+// yieldCounter counts the chunks a write took since it last yielded, a
+// grant counts as all of its chunks
+type yieldCounter struct {
+	every, chunks int
+}
+
+// sent counts n more chunks
+func (yc *yieldCounter) sent(n int) {
+	yc.chunks += n
+}
+
+// due tells if the write has to yield before its next reservation, what
+// a grant took past the cadence counts towards the next yield
+func (yc *yieldCounter) due() bool {
+	if yc.every <= 0 || yc.chunks < yc.every {
+		return false
+	}
+	yc.chunks %= yc.every
+	return true
+}
+
+// yield lets the other connections writing through the global limiter go
+// first: they get the CPU and, if any is there, the global bucket refills
+// a chunk for them before this write takes another one
+func (lc LimitedConn) yield(chunk int) {
+	runtime.Gosched()
+	if atomic.LoadInt32(&lc.global.writers) <= 1 {
+		return
+	}
+	if delay := lc.global.delay(chunk); delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,70 @@
+package limlistener
+
+import "testing"
+
+// yieldsOver returns after which chunks a write taking grants of the
+// given chunks yields, every one of them
+func yieldsOver(every int, grants []int) []int {
+	var at []int
+	yc := yieldCounter{every: every}
+	chunks := 0
+	for _, granted := range grants {
+		if yc.due() {
+			at = append(at, chunks)
+		}
+		yc.sent(granted)
+		chunks += granted
+	}
+	return at
+}
+
+func TestYieldCadence(t *testing.T) {
+	tests := []struct {
+		name   string
+		every  int
+		grants []int
+		want   []int
+	}{
+		{"chunk at a time", 4, []int{1, 1, 1, 1, 1, 1, 1, 1, 1}, []int{4, 8}},
+		{"grants within", 4, []int{2, 2, 2, 2, 2}, []int{4, 8}},
+		{"grants straddling", 4, []int{3, 3, 3, 3}, []int{6, 9}},
+		{"grants over", 4, []int{16, 16, 16}, []int{16, 32}},
+		{"never", 0, []int{16, 16}, nil},
+	}
+	for _, tt := range tests {
+		got := yieldsOver(tt.every, tt.grants)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: yields after chunks %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: yields after chunks %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestYieldCadenceWithGrants(t *testing.T) {
+	ll := newTestListener(t)
+	ll.SetMTU(1024)
+	ll.SetMaxGrant(16 * 1024)
+	conn := acceptDrained(t, ll)
+
+	// the grants a 64 KB write takes, 16 chunks each
+	size := conn.chunkSize() - conn.recordOverhead
+	var grants []int
+	for left := 64 * 1024; left > 0; {
+		granted := conn.grantChunks(left, size)
+		grants = append(grants, granted)
+		left -= granted * size
+	}
+	if len(grants) != 4 || grants[0] != 16 {
+		t.Fatalf("grants = %v, want 4 of 16 chunks", grants)
+	}
+	// yielding every 32 chunks is every other grant, not every 32 grants
+	if got := yieldsOver(32, grants); len(got) != 1 || got[0] != 32 {
+		t.Errorf("yields after chunks %v, want after 32", got)
+	}
+}